@@ -0,0 +1,56 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/model/hmm"
+	"github.com/golang/glog"
+)
+
+// Estimates a transform using the input HMM and the sequences in the data
+// file and writes it to the output file. The input model is not modified.
+func doAdapt() {
+
+	if *inputModel == nil {
+		glog.Fatal("need an HMM model to estimate a transform - use the --input-model flag")
+	}
+	obs := getObserver(true)
+	defer (*inputModel).Close()
+	opt := []hmm.Option{hmm.OAssign(getAssigner())}
+	if *adaptAlign {
+		opt = append(opt, hmm.UseAlignments(true))
+	}
+	m, err := hmm.ReadJSON(*inputModel, opt...)
+	gjoa.Fatal(err)
+
+	t, err := m.EstimateTransform(obs, *adaptType, hmm.NumClasses(*adaptClasses), hmm.MinOccupancy(*adaptMinOcc))
+	gjoa.Fatal(err)
+	am, err := t.Apply(m)
+	gjoa.Fatal(err)
+	ll, err := labelLogLikelihood(m, obs)
+	gjoa.Fatal(err)
+	all, err := labelLogLikelihood(am, obs)
+	gjoa.Fatal(err)
+	n := float64(len(obs))
+	glog.Infof("log likelihood before:%f, avg per obs:%f", ll, ll/n)
+	glog.Infof("log likelihood after:%f, avg per obs:%f", all, all/n)
+	gjoa.Fatal(t.WriteFile(*adaptOut))
+}
+
+// Returns the total log likelihood of the data given the labels. This is the
+// objective maximized by the transform.
+func labelLogLikelihood(m *hmm.Model, obs obsSlice) (float64, error) {
+	var ll float64
+	for _, o := range obs {
+		p, err := m.LabelLogProb(o)
+		if err != nil {
+			return 0, err
+		}
+		ll += p
+	}
+	return ll, nil
+}
@@ -6,7 +6,9 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"os"
 	osuser "os/user"
@@ -16,7 +18,8 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/akualab/gjoa"
-	"github.com/akualab/gjoa/model"
+	"github.com/akualab/gjoa/eval"
+	"github.com/akualab/gjoa/model/hmm"
 	"github.com/alecthomas/kingpin"
 	"github.com/golang/glog"
 )
@@ -49,18 +52,53 @@ var (
 	config    = app.Command("config", "Updates fields in properties file.")
 	configMap = config.Arg("properties", "Set properties.").StringMap()
 
-	rand     = app.Command("rand", "Generate random data using model.")
-	randSeed = rand.Flag("seed", "Seed for random number generator.").Default("0").Int()
+	randCmd  = app.Command("rand", "Generate random data using model.")
+	randSeed = randCmd.Flag("seed", "Seed for random number generator.").Default("0").Int()
+	randNum  = randCmd.Flag("num-samples", "Number of samples.").Short('n').Default("1000").Int()
+	randOut  = randCmd.Flag("output", "Output file. Default is stdout.").Short('o').String()
 
-	train   = app.Command("train", "Estimate model parameters.")
-	numIter = train.Flag("num-iterations", "Number of training iterations.").Int()
+	trainCmd = app.Command("train", "Estimate model parameters.")
+	numIter  = trainCmd.Flag("num-iterations", "Number of training iterations.").Default("10").Int()
+	trainOut = trainCmd.Flag("output", "Output model file.").Short('o').Required().String()
+	trainAcc = trainCmd.Flag("accumulate", "Update the sufficient statistics once and write the model without estimating the parameters. Use the merge command to combine models trained on data shards.").Bool()
 
-	gaussian = train.Command("gaussian", "Select a Gaussian model.")
-	gmm      = train.Command("gmm", "Select a Gaussian mixture model.")
-	numGMM   = gmm.Flag("num-components", "Number of GMM components.").Int()
+	gaussianCmd = trainCmd.Command("gaussian", "Select a Gaussian model.")
+	gmmCmd      = trainCmd.Command("gmm", "Select a Gaussian mixture model.")
+	numGMM      = gmmCmd.Flag("num-components", "Number of GMM components.").Int()
+	mapTau      = gmmCmd.Flag("map-tau", "Relevance factor for MAP adaptation. The input model is the UBM. Usually one iteration is enough. Not supported with --accumulate.").Float()
+	mapVar      = gmmCmd.Flag("map-variances", "Adapt the variances when using MAP adaptation.").Bool()
+	mapWeights  = gmmCmd.Flag("map-weights", "Adapt the weights when using MAP adaptation.").Bool()
+	svFile      = gmmCmd.Flag("supervector", "Write the mean supervector of the trained model to a file.").String()
+	svScaled    = gmmCmd.Flag("supervector-scaled", "Scale the component means using the weights and standard deviations.").Bool()
+	useVB       = gmmCmd.Flag("vb", "Train using variational Bayes. Unneeded components are pruned so --num-components is an upper bound. Reports the ELBO each iteration. The GMM is initialized using k-means so --input-model is not allowed.").Bool()
+	vbAlpha     = gmmCmd.Flag("vb-alpha", "Concentration of the Dirichlet prior of the weights for variational Bayes. Use a small value to prune more components.").Default("0.001").Float()
+	pruneWeight = gmmCmd.Flag("prune-weight", "Remove components whose weight is below this value when using variational Bayes.").Default("0.001").Float()
 
-	hmm           = train.Command("hmm", "Select a hidden Markov model.")
-	useAlignments = hmm.Flag("use-alignments", "Train from alignments.").Bool()
+	hmmCmd        = trainCmd.Command("hmm", "Select a hidden Markov model.")
+	useAlignments = hmmCmd.Flag("use-alignments", "Train from alignments.").Bool()
+	useViterbi    = hmmCmd.Flag("use-viterbi", "Train using the Viterbi best path.").Bool()
+	numWorkers    = hmmCmd.Flag("num-workers", "Number of sequences processed concurrently.").Default("1").Int()
+
+	evalCmd     = app.Command("eval", "Compute the error rate of hypotheses using a results file.")
+	resultsFile = evalCmd.Arg("results", "Results file with reference and hypothesis tokens.").Required().ExistingFile()
+	evalPairs   = evalCmd.Flag("pairs", "Print the aligned reference and hypothesis tokens.").Default("true").Bool()
+	classify    = evalCmd.Flag("classify", "Tokens are class labels. Report classification metrics.").Bool()
+	evalJSON    = evalCmd.Flag("json", "Write the classification report in JSON format.").Bool()
+
+	mergeCmd      = app.Command("merge", "Merge the sufficient statistics of models trained on data shards.")
+	mergeModels   = mergeCmd.Arg("models", "Model files written using train --accumulate.").Required().ExistingFiles()
+	mergeOut      = mergeCmd.Flag("output", "Output model file.").Short('o').Required().String()
+	mergeEstimate = mergeCmd.Flag("estimate", "Estimate the model parameters using the merged statistics.").Default("true").Bool()
+
+	adaptCmd     = app.Command("adapt", "Estimate an MLLR or FMLLR transform of an HMM model using adaptation data.")
+	adaptType    = adaptCmd.Flag("type", "Transform type.").Default(hmm.MLLR).Enum(hmm.MLLR, hmm.FMLLR)
+	adaptClasses = adaptCmd.Flag("num-classes", "Number of regression classes.").Default("1").Int()
+	adaptMinOcc  = adaptCmd.Flag("min-occupancy", "Classes with a smaller occupation count use the global transform.").Default("0").Float()
+	adaptAlign   = adaptCmd.Flag("use-alignments", "Use the alignments in the data file.").Bool()
+	adaptOut     = adaptCmd.Flag("output", "Output transform file.").Short('o').Required().String()
+
+	alignCmd = app.Command("align", "Compute forced alignments using an HMM model.")
+	alignOut = alignCmd.Flag("output", "Output file. Default is stdout.").Short('o').String()
 )
 
 // Properties of gjoa.
@@ -84,13 +122,13 @@ func init() {
 	}
 
 	// Read toml config file from configPath.
+	props = new(Properties)
 	dat, e3 := ioutil.ReadFile(propPath)
 	if e3 == nil {
-		_, e4 := toml.Decode(string(dat), config)
+		_, e4 := toml.Decode(string(dat), props)
 		gjoa.Fatal(e4)
 	} else {
-		props = new(Properties)
-		glog.V(2).Infof("unable to read properties file - ", e3)
+		glog.V(2).Infof("unable to read properties file - %s", e3)
 	}
 	defaultLogDir := filepath.Join(currDir, "log")
 	if len(props.LogDir) > 0 {
@@ -112,63 +150,134 @@ func main() {
 	case config.FullCommand():
 		glog.V(3).Info("start config command")
 
-	case rand.FullCommand():
+	case randCmd.FullCommand():
 		glog.V(3).Info("start rand command")
+		doRand()
+
+	case evalCmd.FullCommand():
+		glog.V(3).Info("start eval command")
+		doEval()
+
+	case mergeCmd.FullCommand():
+		glog.V(3).Info("start merge command")
+		doMerge()
+
+	case adaptCmd.FullCommand():
+		glog.V(3).Info("start adapt command")
+		doAdapt()
+
+	case alignCmd.FullCommand():
+		glog.V(3).Info("start align command")
+		doAlign()
 
-	case "train gaussian":
-		var m model.Trainer
-		*numIter = 1
-		m = gaussian.NewModel(*dim, gaussian.Name(*modelName))
-		doTrain(m)
+	case gaussianCmd.FullCommand():
+		glog.V(3).Info("start train gaussian command")
+		printTrainValues()
+		*numIter = 1 // closed form solution.
+		obs := getObserver(false)
+		doTrain(getGaussian(obs), obs)
 
-	case "train gmm":
-		var m model.Trainer
-		m = gmm.NewModel(*dim, *numGMM, gmm.Name(*modelName))
-		doTrain(m)
+	case gmmCmd.FullCommand():
+		glog.V(3).Info("start train gmm command")
+		printTrainValues()
+		obs := getObserver(false)
+		g := getGMM(obs)
+		doTrain(g, obs)
+		if len(*svFile) > 0 {
+			gjoa.Fatal(writeSupervector(g, *svFile))
+		}
 
-	case "train hmm":
-		var m model.Trainer
-		m = hmm.NewModel(hmmOptions()...)
-		doTrain(m)
+	case hmmCmd.FullCommand():
+		glog.V(3).Info("start train hmm command")
+		printTrainValues()
+		obs := getObserver(true)
+		doTrain(getHMM(), obs)
 
 	default:
-		app.Usage(os.Stderr)
+		app.Usage(os.Args[1:])
 	}
 }
 
-func train(m model.Trainer) {
-	obs := getObserver()
-	for i := 0; i < *numIter; i++ {
-		glog.Infof("iter [%d]", i)
-		m.Clear()
-		m.Update(obs, model.NoWeight)
-		m.Estimate()
+func hmmOptions() []hmm.Option {
+	opt := []hmm.Option{hmm.OAssign(getAssigner())}
+	if *useAlignments {
+		opt = append(opt, hmm.UseAlignments(true))
+	}
+	if *useViterbi {
+		opt = append(opt, hmm.UseViterbi(true))
+	}
+	if *numWorkers > 1 {
+		opt = append(opt, hmm.NumWorkers(*numWorkers))
 	}
-	gjoa.WriteJSONFile(fn, m)
+	return opt
 }
 
-func hmmOptions() []hmm.Option {
-	opt := []hmm.Option{}
-	if *dictFile != nil {
-		var assigner hmm.MapAssigner
-		gjoa.ReadJSON(*dictFile, &assigner)
-		glog.Info("reading name mapping file %s", *dictFile)
-		glog.V(3).Info("name mapping: %s", assigner)
-		opt = append(opt, hmm.OAssign(assigner))
-		*dictFile.Close()
-	} else {
-		var assigner hmm.DirectAssigner
+// Returns a MapAssigner if a dictionary file is provided, otherwise returns a DirectAssigner.
+func getAssigner() hmm.Assigner {
+	if *dictFile == nil {
 		glog.Info("using direct assigner")
-		opt = append(opt, hmm.OAssign(assigner))
+		return hmm.DirectAssigner{}
 	}
-	if *useAlignments {
-		opt = append(opt, hmm.UseAlignemnts(true))
+	defer (*dictFile).Close()
+	var assigner hmm.MapAssigner
+	glog.Infof("reading name mapping file %s", (*dictFile).Name())
+	gjoa.Fatal(json.NewDecoder(*dictFile).Decode(&assigner))
+	glog.V(3).Infof("name mapping: %v", assigner)
+	return assigner
+}
+
+// Reads sequences from the data file and writes them with alignments.
+func doAlign() {
+	if *inputModel == nil {
+		glog.Fatal("need an HMM model to compute alignments - use the --input-model flag")
 	}
-	return opt
+	if *dataFile == nil {
+		glog.Fatal("need a data file to compute alignments - use the --data flag")
+	}
+	m, err := hmm.ReadJSON(*inputModel)
+	gjoa.Fatal(err)
+
+	w := os.Stdout
+	if len(*alignOut) > 0 {
+		w, err = os.Create(*alignOut)
+		gjoa.Fatal(err)
+		defer w.Close()
+	}
+	gjoa.Fatal(m.Set.AlignSeqs(*dataFile, w, getAssigner()))
 }
 
-func getObserver() model.Observer {
-	return nil
+// Scores the results file and writes the error counts by batch id to stdout.
+func doEval() {
+	results, err := gjoa.ReadResults(*resultsFile)
+	gjoa.Fatal(err)
+	if *classify {
+		doClassifyEval(results)
+		return
+	}
+	batches, total := gjoa.ScoreResults(results)
+	for _, s := range batches {
+		fmt.Println(s)
+		if *evalPairs {
+			gjoa.Fatal(s.WritePairs(os.Stdout))
+			fmt.Println()
+		}
+	}
+	fmt.Println(total)
+}
+
+// Writes the classification report. Each ref token is paired with the hyp token
+// in the same position.
+func doClassifyEval(results map[string]*gjoa.Result) {
+	c := eval.NewConfusion()
+	for _, r := range results {
+		gjoa.Fatal(c.AddResult(r))
+	}
+	r := c.Report()
+	if *evalJSON {
+		gjoa.Fatal(r.WriteJSON(os.Stdout))
+		return
+	}
+	gjoa.Fatal(r.WriteTable(os.Stdout))
 }
 
 // Creates dir if it doesn't exist.
@@ -196,12 +305,14 @@ func initGlog() {
 func printAppValues() {
 	glog.Info("app properties:", *props)
 	glog.Info("app version: ", appVersion)
-	glog.Info("app model type: ", *modelType)
 	glog.Info("app log to std err: ", *logToStderr)
 	glog.Info("app log level: ", *vLevel)
 	glog.Info("app log dir: ", *logDir)
 }
 
 func printTrainValues() {
-	//	glog.Info("create xxx: ", *someVar)
+	glog.Info("train num iterations: ", *numIter)
+	glog.Info("train output model: ", *trainOut)
+	glog.Info("train accumulate only: ", *trainAcc)
+	glog.Info("train model name: ", *modelName)
 }
@@ -0,0 +1,38 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/model"
+	"github.com/golang/glog"
+)
+
+// Reads models trained on data shards, adds the sufficient statistics, and
+// writes the merged model. The parameters are estimated unless --estimate=false.
+func doMerge() {
+
+	var m model.Modeler
+	for _, fn := range *mergeModels {
+		glog.Infof("reading model %s", fn)
+		src, err := model.ReadModelFile(fn)
+		gjoa.Fatal(err)
+		if m == nil {
+			m = src
+			continue
+		}
+		merger, ok := m.(model.Merger)
+		if !ok {
+			glog.Fatalf("model type %T doesn't support merging", m)
+		}
+		gjoa.Fatal(merger.Merge(src))
+	}
+	glog.Infof("merged %d models", len(*mergeModels))
+	if *mergeEstimate {
+		gjoa.Fatal(m.Estimate())
+	}
+	gjoa.Fatal(writeModel(m, *mergeOut))
+}
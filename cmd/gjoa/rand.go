@@ -0,0 +1,85 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/model"
+	_ "github.com/akualab/gjoa/model/bayes"   // register decoder
+	_ "github.com/akualab/gjoa/model/mixture" // register decoder
+	"github.com/golang/glog"
+)
+
+// Reads a model of any registered type and writes samples generated by the model.
+func doRand() {
+	m := readInputModel()
+	if m == nil {
+		glog.Fatal("need a model to generate data - use the --input-model flag")
+	}
+	glog.Infof("generating %d samples using model %s of type %T, seed:%d", *randNum, m.Name(), m, *randSeed)
+
+	w := os.Stdout
+	if len(*randOut) > 0 {
+		gjoa.Fatal(os.MkdirAll(filepath.Dir(*randOut), 0755))
+		f, err := os.Create(*randOut)
+		gjoa.Fatal(err)
+		defer f.Close()
+		w = f
+	}
+	r := rand.New(rand.NewSource(int64(*randSeed)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gjoa.Fatal(writeSamples(w, model.SampleChanContext(ctx, m, r, *randNum)))
+}
+
+// Writes samples as a stream of JSON values using the format expected by
+// the --data flag. Vectors are written as JSON arrays and sequences are
+// written as model.Seq objects. Sequence labels and alignments are preserved.
+func writeSamples(w io.Writer, c <-chan model.Obs) error {
+
+	enc := json.NewEncoder(w)
+	var n int
+	for o := range c {
+		var v interface{}
+		switch x := o.Value().(type) {
+		case []float64:
+			v = x
+		case [][]float64:
+			s := model.Seq{
+				Vectors: x,
+				ID:      o.ID(),
+			}
+			if len(s.ID) == 0 {
+				s.ID = fmt.Sprintf("rand-%d", n)
+			}
+			if lab := o.Label().String(); len(lab) > 0 {
+				s.Labels = strings.Split(lab, ",")
+			}
+			if a, ok := o.(model.Aligner); ok {
+				s.Alignments = a.Alignment()
+			}
+			v = s
+		default:
+			return fmt.Errorf("oid:%s, can't write sample value of type %T", o.ID(), x)
+		}
+		err := enc.Encode(v)
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	glog.Infof("wrote %d samples", n)
+	return nil
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/model"
+	"github.com/akualab/gjoa/model/gaussian"
+	"github.com/akualab/gjoa/model/gmm"
+	"github.com/akualab/gjoa/model/hmm"
+	"github.com/golang/glog"
+)
+
+// obsSlice is an observer that keeps all the observations in memory.
+// Training iterates over the data many times so we read the data file once.
+type obsSlice []model.Obs
+
+// ObsChan implements the model.Observer interface.
+func (s obsSlice) ObsChan() (<-chan model.Obs, error) {
+	c := make(chan model.Obs, 1000)
+	go func() {
+		for _, o := range s {
+			c <- o
+		}
+		close(c)
+	}()
+	return c, nil
+}
+
+// Reads training data from r. The data is a stream of JSON values. Each value
+// is either a vector (a JSON array) or a model.Seq object. When seq is true, each
+// observation is a model.FloatObsSequence and vectors are not allowed. When seq is
+// false, sequences are flattened and each observation is a model.FloatObs.
+func readData(r io.Reader, seq bool) ([]model.Obs, error) {
+
+	var data []model.Obs
+	dec := json.NewDecoder(r)
+	for n := 0; ; n++ {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("item:%d, %s", n, err)
+		}
+
+		switch b := bytes.TrimSpace(raw); {
+		case len(b) > 0 && b[0] == '[':
+			if seq {
+				return nil, fmt.Errorf("item:%d, expected a sequence, got a vector", n)
+			}
+			var v []float64
+			err = json.Unmarshal(b, &v)
+			if err != nil {
+				return nil, fmt.Errorf("item:%d, %s", n, err)
+			}
+			data = append(data, model.NewFloatObs(v, ""))
+
+		case len(b) > 0 && b[0] == '{':
+			var s model.Seq
+			err = json.Unmarshal(b, &s)
+			if err != nil {
+				return nil, fmt.Errorf("item:%d, %s", n, err)
+			}
+			if !seq {
+				for _, v := range s.Vectors {
+					data = append(data, model.NewFloatObs(v, ""))
+				}
+				continue
+			}
+			obs := model.NewFloatObsSequence(s.Vectors, model.SimpleLabel(strings.Join(s.Labels, ",")), s.ID)
+			if len(s.Alignments) > 0 {
+				fos := obs.(model.FloatObsSequence)
+				fos.SetAlignment(s.Alignments)
+				obs = fos
+			}
+			data = append(data, obs)
+
+		default:
+			return nil, fmt.Errorf("item:%d, expected a vector or a sequence", n)
+		}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no observations found in data file")
+	}
+	return data, nil
+}
+
+// Reads all the observations from the data file.
+func getObserver(seq bool) obsSlice {
+	if *dataFile == nil {
+		glog.Fatal("need a data file to train a model - use the --data flag")
+	}
+	defer (*dataFile).Close()
+	glog.Infof("reading data file %s", (*dataFile).Name())
+	data, err := readData(*dataFile, seq)
+	gjoa.Fatal(err)
+	glog.Infof("read %d observations", len(data))
+	return data
+}
+
+// Returns the model in the input model file or nil if the flag is not set.
+func readInputModel() model.Modeler {
+	if *inputModel == nil {
+		return nil
+	}
+	defer (*inputModel).Close()
+	glog.Infof("reading input model %s", (*inputModel).Name())
+	m, err := model.ReadModel(*inputModel)
+	gjoa.Fatal(err)
+	return m
+}
+
+// Returns the dimension of the feature vectors using the --dim flag or the first observation.
+func getDim(obs obsSlice) int {
+	if *dim > 0 {
+		return *dim
+	}
+	return len(obs[0].Value().([]float64))
+}
+
+func getGaussian(obs obsSlice) *gaussian.Model {
+	if m := readInputModel(); m != nil {
+		g, ok := m.(*gaussian.Model)
+		if !ok {
+			glog.Fatalf("input model has type %T, expected a Gaussian model", m)
+		}
+		return g
+	}
+	name := *modelName
+	if len(name) == 0 {
+		name = "gaussian"
+	}
+	return gaussian.NewModel(getDim(obs), gaussian.Name(name))
+}
+
+func getGMM(obs obsSlice) *gmm.Model {
+	if *mapTau > 0 && *trainAcc {
+		glog.Fatal("can't use MAP adaptation with the --accumulate flag - the merge command doesn't apply the MAP prior")
+	}
+	if m := readInputModel(); m != nil {
+		g, ok := m.(*gmm.Model)
+		if !ok {
+			glog.Fatalf("input model has type %T, expected a GMM", m)
+		}
+		if *mapTau > 0 {
+			glog.Infof("adapting UBM %s using MAP, relevance factor:%f", g.Name(), *mapTau)
+			opt := []gmm.Option{gmm.AdaptVariances(*mapVar), gmm.AdaptWeights(*mapWeights)}
+			if len(*modelName) > 0 {
+				opt = append(opt, gmm.Name(*modelName))
+			}
+			return gmm.MAPModel(g, *mapTau, opt...)
+		}
+		if *useVB {
+			glog.Fatal("variational Bayes initializes the GMM using k-means - don't use the --input-model flag")
+		}
+		return g
+	}
+	if *mapTau > 0 {
+		glog.Fatal("need a UBM for MAP adaptation - use the --input-model flag")
+	}
+	if *trainAcc {
+		glog.Fatal("need an initial GMM to accumulate statistics - use the --input-model flag")
+	}
+	if *numGMM < 1 {
+		glog.Fatal("need the number of components to create a GMM - use the --num-components flag")
+	}
+	name := *modelName
+	if len(name) == 0 {
+		name = "gmm"
+	}
+	glog.Infof("initializing GMM with %d components using k-means", *numGMM)
+	if *useVB {
+		glog.Infof("training %s using variational Bayes", name)
+		g, err := gmm.VBModel(obs, *numGMM, model.DefaultSeed, gmm.Name(name),
+			gmm.VBAlpha(*vbAlpha), gmm.PruneWeight(*pruneWeight))
+		gjoa.Fatal(err)
+		return g
+	}
+	g, err := gmm.KMeansModel(obs, *numGMM, model.DefaultSeed, gmm.Name(name))
+	gjoa.Fatal(err)
+	return g
+}
+
+func getHMM() *hmm.Model {
+	if *inputModel == nil {
+		glog.Fatal("need an initial HMM model - use the --input-model flag")
+	}
+	defer (*inputModel).Close()
+	glog.Infof("reading input model %s", (*inputModel).Name())
+	opt := hmmOptions()
+	if len(*modelName) > 0 {
+		opt = append(opt, hmm.Name(*modelName))
+	}
+	m, err := hmm.ReadJSON(*inputModel, opt...)
+	gjoa.Fatal(err)
+	return m
+}
+
+// Runs the training iterations and writes the model to the output file.
+// When the --accumulate flag is set, only the sufficient statistics are updated.
+func doTrain(m model.Modeler, obs obsSlice) {
+
+	if *trainAcc {
+		m.Clear()
+		err := m.Update(obs, model.NoWeight)
+		if err != nil {
+			glog.Warningf("update error: %s", err)
+		}
+		glog.Infof("updated sufficient statistics using %d observations", len(obs))
+		gjoa.Fatal(writeModel(m, *trainOut))
+		return
+	}
+	for i := 0; i < *numIter; i++ {
+		m.Clear()
+		err := m.Update(obs, model.NoWeight)
+		if err != nil {
+			glog.Warningf("iter:%d, update error: %s", i, err)
+		}
+		if ll, ok := updateLogProb(m); ok {
+			glog.Infof("iter:%d, log likelihood:%f, avg per obs:%f", i, ll, ll/float64(len(obs)))
+		}
+		gjoa.Fatal(m.Estimate())
+	}
+	gjoa.Fatal(writeModel(m, *trainOut))
+}
+
+// Returns the total log likelihood of the data accumulated by the last update.
+// The likelihood is computed using the parameters before the estimation step.
+// Returns false if the model doesn't accumulate the likelihood. (Variational Bayes
+// reports the ELBO instead.)
+func updateLogProb(m model.Modeler) (float64, bool) {
+	switch v := m.(type) {
+	case *hmm.Model:
+		return v.TotalLogProb(), true
+	case *gmm.Model:
+		return v.Likelihood, !*useVB
+	}
+	return 0, false
+}
+
+// Writes the mean supervector of g as a JSON array.
+func writeSupervector(g *gmm.Model, fn string) error {
+	err := os.MkdirAll(filepath.Dir(fn), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	glog.Infof("writing mean supervector to file %s", fn)
+	return json.NewEncoder(f).Encode(g.MeanSupervector(*svScaled))
+}
+
+func writeModel(m model.Modeler, fn string) error {
+	switch v := m.(type) {
+	case *hmm.Model:
+		return v.WriteJSONFile(fn)
+	case interface {
+		WriteFile(string) error
+	}:
+		return v.WriteFile(fn)
+	}
+	return fmt.Errorf("model type %T can't be written to a file", m)
+}
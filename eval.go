@@ -0,0 +1,221 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gjoa
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// EditOp is an edit operation in the alignment of a reference and a hypothesis.
+type EditOp int
+
+// Edit operations.
+const (
+	Correct EditOp = iota
+	Substitution
+	Insertion
+	Deletion
+)
+
+// String returns a one-letter code for the operation.
+func (op EditOp) String() string {
+	switch op {
+	case Correct:
+		return "C"
+	case Substitution:
+		return "S"
+	case Insertion:
+		return "I"
+	case Deletion:
+		return "D"
+	}
+	return "?"
+}
+
+// EditPair is an aligned pair of tokens. Ref is empty for insertions
+// and Hyp is empty for deletions.
+type EditPair struct {
+	Ref string `json:"ref,omitempty"`
+	Hyp string `json:"hyp,omitempty"`
+	Op  EditOp `json:"op"`
+}
+
+// EditStats has the error counts and the aligned pairs for a result.
+type EditStats struct {
+	BatchID       string     `json:"batchid,omitempty"`
+	NumRef        int        `json:"num_ref"`
+	Correct       int        `json:"correct"`
+	Substitutions int        `json:"substitutions"`
+	Insertions    int        `json:"insertions"`
+	Deletions     int        `json:"deletions"`
+	Pairs         []EditPair `json:"pairs,omitempty"`
+}
+
+// EditDistance aligns the ref and hyp token sequences using the minimum
+// number of edit operations. All operations have a cost of one.
+func EditDistance(ref, hyp []string) *EditStats {
+
+	n, m := len(ref), len(hyp)
+
+	// d[i][j] is the distance between ref[:i] and hyp[:j].
+	d := make([][]int, n+1)
+	for i := range d {
+		d[i] = make([]int, m+1)
+		d[i][0] = i
+	}
+	for j := 0; j <= m; j++ {
+		d[0][j] = j
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= m; j++ {
+			sub := d[i-1][j-1]
+			if ref[i-1] != hyp[j-1] {
+				sub++
+			}
+			d[i][j] = min3(sub, d[i-1][j]+1, d[i][j-1]+1)
+		}
+	}
+
+	// Backtrace. Prefer matches and substitutions over deletions and insertions.
+	s := &EditStats{NumRef: n}
+	pairs := make([]EditPair, 0, n+m)
+	i, j := n, m
+	for i > 0 || j > 0 {
+		switch {
+		case i > 0 && j > 0 && ref[i-1] == hyp[j-1] && d[i][j] == d[i-1][j-1]:
+			pairs = append(pairs, EditPair{Ref: ref[i-1], Hyp: hyp[j-1], Op: Correct})
+			s.Correct++
+			i, j = i-1, j-1
+		case i > 0 && j > 0 && d[i][j] == d[i-1][j-1]+1:
+			pairs = append(pairs, EditPair{Ref: ref[i-1], Hyp: hyp[j-1], Op: Substitution})
+			s.Substitutions++
+			i, j = i-1, j-1
+		case i > 0 && d[i][j] == d[i-1][j]+1:
+			pairs = append(pairs, EditPair{Ref: ref[i-1], Op: Deletion})
+			s.Deletions++
+			i--
+		default:
+			pairs = append(pairs, EditPair{Hyp: hyp[j-1], Op: Insertion})
+			s.Insertions++
+			j--
+		}
+	}
+	for k, l := 0, len(pairs)-1; k < l; k, l = k+1, l-1 {
+		pairs[k], pairs[l] = pairs[l], pairs[k]
+	}
+	s.Pairs = pairs
+	return s
+}
+
+// Errors returns the total number of errors.
+func (s *EditStats) Errors() int {
+	return s.Substitutions + s.Insertions + s.Deletions
+}
+
+// ErrorRate returns the number of errors divided by the number of reference tokens.
+// When there are no reference tokens, returns one if there are errors and zero otherwise.
+func (s *EditStats) ErrorRate() float64 {
+	if s.NumRef == 0 {
+		if s.Errors() == 0 {
+			return 0
+		}
+		return 1
+	}
+	return float64(s.Errors()) / float64(s.NumRef)
+}
+
+// Add adds the counts in x to s. Aligned pairs are not added.
+func (s *EditStats) Add(x *EditStats) {
+	s.NumRef += x.NumRef
+	s.Correct += x.Correct
+	s.Substitutions += x.Substitutions
+	s.Insertions += x.Insertions
+	s.Deletions += x.Deletions
+}
+
+// String returns a summary of the error counts.
+func (s *EditStats) String() string {
+	return fmt.Sprintf("batch:%s, ref:%d, cor:%d, sub:%d, ins:%d, del:%d, err:%.2f%%",
+		s.BatchID, s.NumRef, s.Correct, s.Substitutions, s.Insertions, s.Deletions, 100*s.ErrorRate())
+}
+
+// WritePairs writes the aligned pairs as three lines: the reference tokens, the
+// hypothesis tokens, and the edit operations. Missing tokens are shown as "***".
+func (s *EditStats) WritePairs(w io.Writer) error {
+
+	var ref, hyp, ops []string
+	for _, p := range s.Pairs {
+		r, h, op := p.Ref, p.Hyp, ""
+		switch p.Op {
+		case Insertion:
+			r = "***"
+		case Deletion:
+			h = "***"
+		}
+		if p.Op != Correct {
+			op = p.Op.String()
+		}
+		width := utf8.RuneCountInString(r)
+		if n := utf8.RuneCountInString(h); n > width {
+			width = n
+		}
+		ref = append(ref, pad(r, width))
+		hyp = append(hyp, pad(h, width))
+		ops = append(ops, pad(op, width))
+	}
+	_, err := fmt.Fprintf(w, "REF: %s\nHYP: %s\nOPS: %s\n", joinTrim(ref), joinTrim(hyp), joinTrim(ops))
+	return err
+}
+
+// Score computes the edit distance between Ref and Hyp.
+func (r *Result) Score() *EditStats {
+	s := EditDistance(r.Ref, r.Hyp)
+	s.BatchID = r.BatchID
+	return s
+}
+
+// ScoreResults scores a collection of results. Returns the stats for each
+// result sorted by batch id and the overall stats.
+func ScoreResults(results map[string]*Result) ([]*EditStats, *EditStats) {
+
+	ids := make([]string, 0, len(results))
+	for id := range results {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	total := &EditStats{BatchID: "total"}
+	batches := make([]*EditStats, 0, len(ids))
+	for _, id := range ids {
+		s := results[id].Score()
+		total.Add(s)
+		batches = append(batches, s)
+	}
+	return batches, total
+}
+
+// Pads s with spaces to width runes.
+func pad(s string, width int) string {
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
+
+func joinTrim(s []string) string {
+	return strings.TrimRight(strings.Join(s, " "), " ")
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
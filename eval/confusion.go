@@ -0,0 +1,265 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package eval computes classification metrics.
+
+A confusion matrix is built by adding (reference, hypothesis) label pairs. Labels
+are compared using the IsEqual method of the model.Labeler interface. The report
+has the precision, recall, and F1 score of each class, the macro and micro averages,
+and the accuracy. Reports can be written as JSON or as a text table.
+
+Example:
+
+	c := eval.NewConfusion()
+	for i, ref := range refLabels {
+		c.Add(ref, hypLabels[i])
+	}
+	r := c.Report()
+	r.WriteTable(os.Stdout)
+*/
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/model"
+)
+
+// Confusion is a confusion matrix. Counts[i][j] is the number of
+// observations of class Labels[i] classified as class Labels[j].
+type Confusion struct {
+	Labels []string `json:"labels"`
+	Counts [][]int  `json:"counts"`
+	labels []model.Labeler
+}
+
+// NewConfusion creates an empty confusion matrix. Classes are added
+// as new labels are found.
+func NewConfusion() *Confusion {
+	return &Confusion{}
+}
+
+// Returns the index of the class that matches the label. Adds the class if not found.
+func (c *Confusion) index(lab model.Labeler) int {
+
+	for i, l := range c.labels {
+		if l.IsEqual(lab) {
+			return i
+		}
+	}
+	c.labels = append(c.labels, lab)
+	c.Labels = append(c.Labels, lab.String())
+	for i := range c.Counts {
+		c.Counts[i] = append(c.Counts[i], 0)
+	}
+	c.Counts = append(c.Counts, make([]int, len(c.labels)))
+	return len(c.labels) - 1
+}
+
+// Add adds a (reference, hypothesis) pair to the confusion matrix.
+func (c *Confusion) Add(ref, hyp model.Labeler) {
+	i := c.index(ref)
+	j := c.index(hyp)
+	c.Counts[i][j]++
+}
+
+// AddResult adds the pairs (r.Ref[k], r.Hyp[k]) to the confusion matrix.
+// Returns an error if the number of reference and hypothesis labels don't match.
+func (c *Confusion) AddResult(r *gjoa.Result) error {
+
+	if len(r.Ref) != len(r.Hyp) {
+		return fmt.Errorf("batchid:%s, num ref labels [%d] doesn't match num hyp labels [%d]",
+			r.BatchID, len(r.Ref), len(r.Hyp))
+	}
+	for k := range r.Ref {
+		c.Add(model.SimpleLabel(r.Ref[k]), model.SimpleLabel(r.Hyp[k]))
+	}
+	return nil
+}
+
+// ClassStats has the metrics for one class.
+type ClassStats struct {
+	Label     string  `json:"label"`
+	Support   int     `json:"support"`
+	TP        int     `json:"tp"`
+	FP        int     `json:"fp"`
+	FN        int     `json:"fn"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+}
+
+// Report has the classification metrics computed from a confusion matrix.
+// Macro averages are the unweighted mean of the per-class metrics. Micro averages
+// are computed using the total counts. Classes are sorted by label.
+type Report struct {
+	Total          int          `json:"total"`
+	Correct        int          `json:"correct"`
+	Accuracy       float64      `json:"accuracy"`
+	MacroPrecision float64      `json:"macro_precision"`
+	MacroRecall    float64      `json:"macro_recall"`
+	MacroF1        float64      `json:"macro_f1"`
+	MicroPrecision float64      `json:"micro_precision"`
+	MicroRecall    float64      `json:"micro_recall"`
+	MicroF1        float64      `json:"micro_f1"`
+	Classes        []ClassStats `json:"classes"`
+	Confusion      *Confusion   `json:"confusion"`
+}
+
+// Report computes the classification metrics. The confusion matrix in the
+// report is a copy of c with the classes sorted by label.
+func (c *Confusion) Report() *Report {
+
+	c = c.sorted()
+	r := &Report{Confusion: c}
+	n := len(c.Labels)
+	var tp, fp, fn int
+	for i := 0; i < n; i++ {
+		cs := ClassStats{Label: c.Labels[i]}
+		for j := 0; j < n; j++ {
+			r.Total += c.Counts[i][j]
+			cs.Support += c.Counts[i][j]
+			if i == j {
+				cs.TP = c.Counts[i][i]
+				continue
+			}
+			cs.FN += c.Counts[i][j]
+			cs.FP += c.Counts[j][i]
+		}
+		cs.Precision = ratio(cs.TP, cs.TP+cs.FP)
+		cs.Recall = ratio(cs.TP, cs.TP+cs.FN)
+		cs.F1 = f1(cs.Precision, cs.Recall)
+		r.MacroPrecision += cs.Precision
+		r.MacroRecall += cs.Recall
+		r.MacroF1 += cs.F1
+		tp, fp, fn = tp+cs.TP, fp+cs.FP, fn+cs.FN
+		r.Classes = append(r.Classes, cs)
+	}
+	if n > 0 {
+		r.MacroPrecision /= float64(n)
+		r.MacroRecall /= float64(n)
+		r.MacroF1 /= float64(n)
+	}
+	r.Correct = tp
+	r.Accuracy = ratio(tp, r.Total)
+	r.MicroPrecision = ratio(tp, tp+fp)
+	r.MicroRecall = ratio(tp, tp+fn)
+	r.MicroF1 = f1(r.MicroPrecision, r.MicroRecall)
+	return r
+}
+
+// WriteJSON writes the report to w in JSON format.
+func (r *Report) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// WriteTable writes the report to w as a text table followed by the confusion matrix.
+func (r *Report) WriteTable(w io.Writer) error {
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "class\tprecision\trecall\tf1\tsupport\t")
+	for _, cs := range r.Classes {
+		fmt.Fprintf(tw, "%s\t%.4f\t%.4f\t%.4f\t%d\t\n", cs.Label, cs.Precision, cs.Recall, cs.F1, cs.Support)
+	}
+	fmt.Fprintln(tw, "\t\t\t\t\t")
+	fmt.Fprintf(tw, "macro avg\t%.4f\t%.4f\t%.4f\t%d\t\n", r.MacroPrecision, r.MacroRecall, r.MacroF1, r.Total)
+	fmt.Fprintf(tw, "micro avg\t%.4f\t%.4f\t%.4f\t%d\t\n", r.MicroPrecision, r.MicroRecall, r.MicroF1, r.Total)
+	fmt.Fprintf(tw, "accuracy\t\t\t%.4f\t%d\t\n", r.Accuracy, r.Total)
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	if r.Confusion == nil {
+		return nil
+	}
+	_, err = fmt.Fprintln(w)
+	if err != nil {
+		return err
+	}
+	return r.Confusion.WriteTable(w)
+}
+
+// WriteTable writes the confusion matrix to w as a text table.
+// Rows are reference classes and columns are hypothesis classes.
+// Classes are sorted by label.
+func (c *Confusion) WriteTable(w io.Writer) error {
+
+	c = c.sorted()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "ref\\hyp\t%s\t\n", strings.Join(c.Labels, "\t"))
+	for i, row := range c.Counts {
+		fmt.Fprintf(tw, "%s\t", c.Labels[i])
+		for _, v := range row {
+			fmt.Fprintf(tw, "%d\t", v)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// Returns a copy of the confusion matrix with the classes sorted by label.
+func (c *Confusion) sorted() *Confusion {
+
+	n := len(c.Labels)
+	idx := byLabel{labels: c.Labels, idx: make([]int, n)}
+	for i := range idx.idx {
+		idx.idx[i] = i
+	}
+	sort.Stable(idx)
+	s := &Confusion{
+		Labels: make([]string, n),
+		Counts: make([][]int, n),
+	}
+	if len(c.labels) == n {
+		s.labels = make([]model.Labeler, n)
+	}
+	for a, i := range idx.idx {
+		s.Labels[a] = c.Labels[i]
+		if s.labels != nil {
+			s.labels[a] = c.labels[i]
+		}
+		s.Counts[a] = make([]int, n)
+		for b, j := range idx.idx {
+			s.Counts[a][b] = c.Counts[i][j]
+		}
+	}
+	return s
+}
+
+// Sorts class indices by label.
+type byLabel struct {
+	labels []string
+	idx    []int
+}
+
+func (s byLabel) Len() int           { return len(s.idx) }
+func (s byLabel) Swap(i, j int)      { s.idx[i], s.idx[j] = s.idx[j], s.idx[i] }
+func (s byLabel) Less(i, j int) bool { return s.labels[s.idx[i]] < s.labels[s.idx[j]] }
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+func f1(p, r float64) float64 {
+	if p+r == 0 {
+		return 0
+	}
+	return 2 * p * r / (p + r)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/model"
+)
+
+const epsilon = 0.00001
+
+func makeConfusion(t *testing.T) *Confusion {
+
+	c := NewConfusion()
+	err := c.AddResult(&gjoa.Result{
+		BatchID: "b0",
+		Ref:     []string{"a", "a", "a", "b", "b", "c"},
+		Hyp:     []string{"a", "a", "b", "b", "c", "c"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Add(model.SimpleLabel("c"), model.SimpleLabel("a"))
+	return c
+}
+
+func TestReport(t *testing.T) {
+
+	c := makeConfusion(t)
+	r := c.Report()
+
+	if r.Total != 7 || r.Correct != 4 {
+		t.Fatalf("wrong totals, total:%d, correct:%d", r.Total, r.Correct)
+	}
+	gjoa.CompareFloats(t, 4.0/7.0, r.Accuracy, "wrong accuracy", epsilon)
+
+	// a: tp=2, fp=1, fn=1
+	// b: tp=1, fp=1, fn=1
+	// c: tp=1, fp=1, fn=1
+	expected := []ClassStats{
+		{Label: "a", Support: 3, TP: 2, FP: 1, FN: 1, Precision: 2.0 / 3.0, Recall: 2.0 / 3.0, F1: 2.0 / 3.0},
+		{Label: "b", Support: 2, TP: 1, FP: 1, FN: 1, Precision: 0.5, Recall: 0.5, F1: 0.5},
+		{Label: "c", Support: 2, TP: 1, FP: 1, FN: 1, Precision: 0.5, Recall: 0.5, F1: 0.5},
+	}
+	for i, e := range expected {
+		cs := r.Classes[i]
+		if cs.Label != e.Label || cs.Support != e.Support || cs.TP != e.TP || cs.FP != e.FP || cs.FN != e.FN {
+			t.Fatalf("wrong class stats. Expected: %+v, Got: %+v", e, cs)
+		}
+		gjoa.CompareFloats(t, e.Precision, cs.Precision, "wrong precision", epsilon)
+		gjoa.CompareFloats(t, e.Recall, cs.Recall, "wrong recall", epsilon)
+		gjoa.CompareFloats(t, e.F1, cs.F1, "wrong f1", epsilon)
+	}
+	gjoa.CompareFloats(t, (2.0/3.0+1)/3, r.MacroPrecision, "wrong macro precision", epsilon)
+	gjoa.CompareFloats(t, (2.0/3.0+1)/3, r.MacroF1, "wrong macro f1", epsilon)
+	gjoa.CompareFloats(t, 4.0/7.0, r.MicroPrecision, "wrong micro precision", epsilon)
+	gjoa.CompareFloats(t, 4.0/7.0, r.MicroRecall, "wrong micro recall", epsilon)
+}
+
+func TestWrite(t *testing.T) {
+
+	r := makeConfusion(t).Report()
+
+	var b bytes.Buffer
+	err := r.WriteJSON(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r2 Report
+	err = json.Unmarshal(b.Bytes(), &r2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r2.Total != r.Total || len(r2.Classes) != 3 || r2.Confusion.Counts[2][0] != 1 {
+		t.Fatalf("wrong report after json round trip: %s", b.String())
+	}
+
+	b.Reset()
+	err = r.WriteTable(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"precision", "macro avg", "micro avg", "accuracy", "ref\\hyp"} {
+		if !strings.Contains(b.String(), s) {
+			t.Fatalf("missing [%s] in table:\n%s", s, b.String())
+		}
+	}
+	t.Logf("\n%s", b.String())
+}
+
+func TestSortedConfusion(t *testing.T) {
+
+	c := NewConfusion()
+	c.Add(model.SimpleLabel("c"), model.SimpleLabel("a"))
+	c.Add(model.SimpleLabel("a"), model.SimpleLabel("b"))
+	c.Add(model.SimpleLabel("b"), model.SimpleLabel("b"))
+	c.Add(model.SimpleLabel("c"), model.SimpleLabel("c"))
+
+	r := c.Report()
+	for i, lab := range []string{"a", "b", "c"} {
+		if r.Classes[i].Label != lab || r.Confusion.Labels[i] != lab {
+			t.Fatalf("classes not sorted by label, classes:%v, matrix labels:%v", r.Classes, r.Confusion.Labels)
+		}
+	}
+	expected := [][]int{{0, 1, 0}, {0, 1, 0}, {1, 0, 1}}
+	for i, row := range expected {
+		for j, v := range row {
+			if r.Confusion.Counts[i][j] != v {
+				t.Fatalf("wrong count at (%d,%d). Expected: [%d], Got: [%d]", i, j, v, r.Confusion.Counts[i][j])
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	err := c.WriteTable(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(b.String(), "\n")
+	if f := strings.Fields(lines[0]); strings.Join(f[1:], " ") != "a b c" {
+		t.Fatalf("columns not sorted by label:\n%s", b.String())
+	}
+	if f := strings.Fields(lines[3]); strings.Join(f, " ") != "c 1 0 1" {
+		t.Fatalf("rows not sorted by label:\n%s", b.String())
+	}
+}
+
+func TestAddResultMismatch(t *testing.T) {
+
+	c := NewConfusion()
+	err := c.AddResult(&gjoa.Result{BatchID: "x", Ref: []string{"a"}, Hyp: []string{"a", "b"}})
+	if err == nil {
+		t.Fatalf("expected error when num labels don't match")
+	}
+}
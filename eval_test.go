@@ -0,0 +1,90 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gjoa
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+
+	ref := strings.Fields("a b c d e f")
+	hyp := strings.Fields("a x c e f g")
+	s := EditDistance(ref, hyp)
+
+	if s.NumRef != 6 || s.Correct != 4 || s.Substitutions != 1 || s.Deletions != 1 || s.Insertions != 1 {
+		t.Fatalf("wrong counts: %s", s)
+	}
+	expected := []EditOp{Correct, Substitution, Correct, Deletion, Correct, Correct, Insertion}
+	if len(s.Pairs) != len(expected) {
+		t.Fatalf("wrong num pairs. Expected: [%d], Got: [%d]", len(expected), len(s.Pairs))
+	}
+	for i, op := range expected {
+		if s.Pairs[i].Op != op {
+			t.Fatalf("pair %d: wrong op. Expected: [%s], Got: [%s]", i, op, s.Pairs[i].Op)
+		}
+	}
+	CompareFloats(t, 0.5, s.ErrorRate(), "wrong error rate", 0.00001)
+
+	var b bytes.Buffer
+	err := s.WritePairs(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "REF: a b c d   e f ***\nHYP: a x c *** e f g\nOPS:   S   D       I\n"
+	if b.String() != exp {
+		t.Fatalf("wrong pairs. Expected:\n%sGot:\n%s", exp, b.String())
+	}
+
+	// Columns are aligned using the number of runes.
+	s = EditDistance(strings.Fields("año b"), strings.Fields("ano b"))
+	b.Reset()
+	err = s.WritePairs(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp = "REF: año b\nHYP: ano b\nOPS: S\n"
+	if b.String() != exp {
+		t.Fatalf("wrong pairs. Expected:\n%sGot:\n%s", exp, b.String())
+	}
+}
+
+func TestEditDistanceEmpty(t *testing.T) {
+
+	s := EditDistance(nil, nil)
+	if s.Errors() != 0 || s.ErrorRate() != 0 {
+		t.Fatalf("wrong stats: %s", s)
+	}
+	s = EditDistance(nil, []string{"a", "b"})
+	if s.Insertions != 2 || s.ErrorRate() != 1 {
+		t.Fatalf("wrong stats: %s", s)
+	}
+	s = EditDistance([]string{"a", "b"}, nil)
+	if s.Deletions != 2 || s.ErrorRate() != 1 {
+		t.Fatalf("wrong stats: %s", s)
+	}
+}
+
+func TestScoreResults(t *testing.T) {
+
+	results := map[string]*Result{
+		"b": {BatchID: "b", Ref: []string{"x", "y"}, Hyp: []string{"x", "y"}},
+		"a": {BatchID: "a", Ref: []string{"x", "y"}, Hyp: []string{"x", "z", "w"}},
+	}
+	batches, total := ScoreResults(results)
+	if len(batches) != 2 || batches[0].BatchID != "a" || batches[1].BatchID != "b" {
+		t.Fatalf("batches not sorted by id: %v", batches)
+	}
+	if batches[0].Errors() != 2 || batches[1].Errors() != 0 {
+		t.Fatalf("wrong errors: %s, %s", batches[0], batches[1])
+	}
+	if total.NumRef != 4 || total.Errors() != 2 {
+		t.Fatalf("wrong total: %s", total)
+	}
+	CompareFloats(t, 0.5, total.ErrorRate(), "wrong total error rate", 0.00001)
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package floatx
+
+import "math"
+
+// Lgamma returns the natural logarithm of the absolute value of the Gamma function.
+func Lgamma(x float64) float64 {
+	v, _ := math.Lgamma(x)
+	return v
+}
+
+// Digamma returns the derivative of Lgamma using the recurrence and the
+// asymptotic expansion.
+func Digamma(x float64) float64 {
+	var v float64
+	for ; x < 6; x++ {
+		v -= 1 / x
+	}
+	f := 1 / (x * x)
+	return v + math.Log(x) - 0.5/x - f*(1.0/12-f*(1.0/120-f*(1.0/252-f*(1.0/240-f/132))))
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package floatx
+
+import (
+	"math"
+	"testing"
+
+	"github.com/akualab/gjoa"
+)
+
+func TestLgamma(t *testing.T) {
+
+	gjoa.CompareFloats(t, math.Log(6), Lgamma(4), "wrong lgamma", 1e-12)
+	gjoa.CompareFloats(t, math.Log(math.Pi)/2, Lgamma(0.5), "wrong lgamma", 1e-12)
+}
+
+func TestDigamma(t *testing.T) {
+
+	const gamma = 0.5772156649015329
+	gjoa.CompareFloats(t, -gamma, Digamma(1), "wrong digamma", 1e-9)
+	gjoa.CompareFloats(t, -gamma-2*math.Ln2, Digamma(0.5), "wrong digamma", 1e-9)
+	gjoa.CompareFloats(t, math.Log(100)-1.0/200, Digamma(100), "wrong digamma", 1e-5)
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package floatx
+
+import (
+	"fmt"
+	"math"
+)
+
+// Dense linear algebra for the small matrices used by the models. Matrices
+// are row-major [][]float64 slices.
+
+const (
+	maxJacobiSweeps   = 100
+	jacobiTolerance   = 1e-12
+	singularTolerance = 1e-12
+)
+
+// Cholesky computes the lower triangular matrix L such that a = L L'.
+// Returns an error if a is not positive definite.
+func Cholesky(a [][]float64) ([][]float64, error) {
+
+	n := len(a)
+	l := MakeFloat2D(n, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j <= i; j++ {
+			sum := a[i][j]
+			for k := 0; k < j; k++ {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				if sum <= 0 {
+					return nil, fmt.Errorf("floatx: matrix is not positive definite, pivot [%d] is %e", i, sum)
+				}
+				l[i][i] = math.Sqrt(sum)
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	return l, nil
+}
+
+// ForwardSubst solves L y = b where L is lower triangular. The result is written to y.
+// Can be called with y = b.
+func ForwardSubst(l [][]float64, b, y []float64) {
+
+	for i := range b {
+		sum := b[i]
+		for k := 0; k < i; k++ {
+			sum -= l[i][k] * y[k]
+		}
+		y[i] = sum / l[i][i]
+	}
+}
+
+// BackSubstT solves L' x = y where L is lower triangular. The result is written to x.
+// Can be called with x = y.
+func BackSubstT(l [][]float64, y, x []float64) {
+
+	for i := len(y) - 1; i >= 0; i-- {
+		sum := y[i]
+		for k := i + 1; k < len(y); k++ {
+			sum -= l[k][i] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+}
+
+// InvertSPD computes the inverse of the symmetric positive definite matrix a
+// using the Cholesky factorization. Also returns the log of the determinant.
+// Returns an error if a is not positive definite.
+func InvertSPD(a [][]float64) ([][]float64, float64, error) {
+
+	l, err := Cholesky(a)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := len(a)
+	var logDet float64
+	for i := 0; i < n; i++ {
+		logDet += 2 * math.Log(l[i][i])
+	}
+
+	// Solve L L' inv = I column by column.
+	inv := MakeFloat2D(n, n)
+	col := make([]float64, n)
+	for c := 0; c < n; c++ {
+		Clear(col)
+		col[c] = 1
+		ForwardSubst(l, col, col)
+		BackSubstT(l, col, col)
+		for i := range col {
+			inv[i][c] = col[i]
+		}
+	}
+	return inv, logDet, nil
+}
+
+// Invert computes the inverse of the square matrix a using Gauss-Jordan
+// elimination with partial pivoting. Also returns the log of the absolute
+// value of the determinant. Returns an error if a is singular.
+func Invert(a [][]float64) ([][]float64, float64, error) {
+
+	n := len(a)
+	m := MakeFloat2D(n, 2*n)
+	var scale float64
+	for i := range a {
+		copy(m[i], a[i])
+		m[i][n+i] = 1
+		for _, v := range a[i] {
+			scale = math.Max(scale, math.Abs(v))
+		}
+	}
+	var logDet float64
+	for j := 0; j < n; j++ {
+		p := j
+		for i := j + 1; i < n; i++ {
+			if math.Abs(m[i][j]) > math.Abs(m[p][j]) {
+				p = i
+			}
+		}
+		if math.Abs(m[p][j]) <= singularTolerance*scale {
+			return nil, 0, fmt.Errorf("floatx: matrix is singular, pivot [%d] is %e", j, m[p][j])
+		}
+		m[j], m[p] = m[p], m[j]
+		pivot := m[j][j]
+		logDet += math.Log(math.Abs(pivot))
+		for k := range m[j] {
+			m[j][k] /= pivot
+		}
+		for i := 0; i < n; i++ {
+			if i == j || m[i][j] == 0 {
+				continue
+			}
+			f := m[i][j]
+			for k := range m[i] {
+				m[i][k] -= f * m[j][k]
+			}
+		}
+	}
+	inv := MakeFloat2D(n, n)
+	for i := range inv {
+		copy(inv[i], m[i][n:])
+	}
+	return inv, logDet, nil
+}
+
+// MulVec computes a x. The result is written to y.
+func MulVec(a [][]float64, x, y []float64) {
+
+	for i, row := range a {
+		var v float64
+		for j, aij := range row {
+			v += aij * x[j]
+		}
+		y[i] = v
+	}
+}
+
+// SymEigen computes the eigenvalues and eigenvectors of the symmetric matrix a
+// using the cyclic Jacobi method. The eigenvectors are the columns of vecs.
+func SymEigen(a [][]float64) (vals []float64, vecs [][]float64) {
+
+	n := len(a)
+	m := CopyFloat2D(a)
+	vecs = MakeFloat2D(n, n)
+	for i := 0; i < n; i++ {
+		vecs[i][i] = 1
+	}
+
+	for sweep := 0; sweep < maxJacobiSweeps; sweep++ {
+		var off, norm float64
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if i != j {
+					off += m[i][j] * m[i][j]
+				}
+				norm += m[i][j] * m[i][j]
+			}
+		}
+		if off <= jacobiTolerance*jacobiTolerance*norm {
+			break
+		}
+		for p := 0; p < n-1; p++ {
+			for q := p + 1; q < n; q++ {
+				if m[p][q] == 0 {
+					continue
+				}
+				// Compute the rotation that zeroes m[p][q].
+				theta := (m[q][q] - m[p][p]) / (2 * m[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					mkp, mkq := m[k][p], m[k][q]
+					m[k][p] = c*mkp - s*mkq
+					m[k][q] = s*mkp + c*mkq
+				}
+				for k := 0; k < n; k++ {
+					mpk, mqk := m[p][k], m[q][k]
+					m[p][k] = c*mpk - s*mqk
+					m[q][k] = s*mpk + c*mqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := vecs[k][p], vecs[k][q]
+					vecs[k][p] = c*vkp - s*vkq
+					vecs[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	vals = make([]float64, n)
+	for i := range vals {
+		vals[i] = m[i][i]
+	}
+	return
+}
+
+// FloorEigen sets the eigenvalues of the symmetric matrix a that are less than
+// min to min. The matrix is modified in place. Returns the number of eigenvalues
+// that were floored.
+func FloorEigen(a [][]float64, min float64) int {
+
+	vals, vecs := SymEigen(a)
+	count := 0
+	for i, v := range vals {
+		if v < min {
+			vals[i] = min
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+
+	// Reconstruct a = V diag(vals) V'.
+	n := len(a)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			var sum float64
+			for k := 0; k < n; k++ {
+				sum += vecs[i][k] * vals[k] * vecs[j][k]
+			}
+			a[i][j] = sum
+		}
+	}
+	return count
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package floatx
+
+import (
+	"math"
+	"testing"
+
+	"github.com/akualab/gjoa"
+)
+
+var testMatrix = [][]float64{
+	{4, 2, 0.6},
+	{2, 5, 1},
+	{0.6, 1, 3},
+}
+
+func TestCholesky(t *testing.T) {
+
+	l, err := Cholesky(testMatrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check L L' = A.
+	n := len(testMatrix)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			var v float64
+			for k := 0; k < n; k++ {
+				v += l[i][k] * l[j][k]
+			}
+			if !gjoa.Comparef64(testMatrix[i][j], v, 0.00001) {
+				t.Fatalf("wrong value at (%d,%d). Expected: [%f], Got: [%f]", i, j, testMatrix[i][j], v)
+			}
+		}
+	}
+
+	// Solve L y = b and L' x = b.
+	b := []float64{1, 2, 3}
+	y := make([]float64, n)
+	ForwardSubst(l, b, y)
+	x := make([]float64, n)
+	BackSubstT(l, b, x)
+	for i := 0; i < n; i++ {
+		var v, u float64
+		for k := 0; k <= i; k++ {
+			v += l[i][k] * y[k]
+		}
+		for k := i; k < n; k++ {
+			u += l[k][i] * x[k]
+		}
+		if !gjoa.Comparef64(b[i], v, 0.00001) || !gjoa.Comparef64(b[i], u, 0.00001) {
+			t.Fatalf("wrong solution at %d. Expected: [%f], Got: [%f] and [%f]", i, b[i], v, u)
+		}
+	}
+
+	_, err = Cholesky([][]float64{{1, 2}, {2, 1}})
+	if err == nil {
+		t.Fatalf("expected error for matrix that is not positive definite")
+	}
+}
+
+// Checks that a inv = I.
+func checkInverse(t *testing.T, a, inv [][]float64) {
+	for i := range a {
+		row := make([]float64, len(a))
+		for j := range row {
+			for k := range a {
+				row[j] += a[i][k] * inv[k][j]
+			}
+		}
+		id := make([]float64, len(a))
+		id[i] = 1
+		gjoa.CompareSliceFloat(t, id, row, "wrong inverse", 1e-12)
+	}
+}
+
+func TestInvertSPD(t *testing.T) {
+
+	inv, logDet, err := InvertSPD(testMatrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkInverse(t, testMatrix, inv)
+	det := 4*(5*3-1*1) - 2*(2*3-1*0.6) + 0.6*(2*1-5*0.6)
+	gjoa.CompareFloats(t, math.Log(det), logDet, "wrong log determinant", 1e-12)
+	if _, _, err := InvertSPD(MakeFloat2D(2, 2)); err == nil {
+		t.Fatalf("expected error, matrix is singular")
+	}
+}
+
+func TestInvert(t *testing.T) {
+
+	a := [][]float64{{0, 2, 1}, {1, -1, 0}, {3, 0.5, 2}}
+	inv, logDet, err := Invert(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkInverse(t, a, inv)
+	det := 0*(-1*2-0*0.5) - 2*(1*2-0*3) + 1*(1*0.5-(-1)*3)
+	gjoa.CompareFloats(t, math.Log(math.Abs(det)), logDet, "wrong log determinant", 1e-12)
+
+	y := make([]float64, 3)
+	MulVec(a, []float64{1, 2, 3}, y)
+	gjoa.CompareSliceFloat(t, []float64{7, -1, 10}, y, "wrong product", 1e-12)
+
+	if _, _, err := Invert([][]float64{{1, 2}, {2, 4}}); err == nil {
+		t.Fatalf("expected error, matrix is singular")
+	}
+}
+
+func TestSymEigen(t *testing.T) {
+
+	vals, vecs := SymEigen(testMatrix)
+	n := len(testMatrix)
+
+	// Check A v = lambda v.
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			var v float64
+			for j := 0; j < n; j++ {
+				v += testMatrix[i][j] * vecs[j][k]
+			}
+			if math.Abs(v-vals[k]*vecs[i][k]) > 0.00001 {
+				t.Fatalf("eigenvector %d is wrong, row %d: %f != %f", k, i, v, vals[k]*vecs[i][k])
+			}
+		}
+	}
+
+	// Trace is the sum of eigenvalues.
+	var trace, sum float64
+	for i := 0; i < n; i++ {
+		trace += testMatrix[i][i]
+		sum += vals[i]
+	}
+	if !gjoa.Comparef64(trace, sum, 0.00001) {
+		t.Fatalf("sum of eigenvalues is %f, expected %f", sum, trace)
+	}
+}
+
+func TestFloorEigen(t *testing.T) {
+
+	a := [][]float64{{1, 1}, {1, 1}} // eigenvalues are 0 and 2.
+	n := FloorEigen(a, 0.5)
+	if n != 1 {
+		t.Fatalf("expected one floored eigenvalue, got %d", n)
+	}
+	vals, _ := SymEigen(a)
+	min, max := math.Min(vals[0], vals[1]), math.Max(vals[0], vals[1])
+	if !gjoa.Comparef64(0.5, min, 0.00001) || !gjoa.Comparef64(2, max, 0.00001) {
+		t.Fatalf("wrong eigenvalues after flooring: %v", vals)
+	}
+}
@@ -40,6 +40,41 @@ type ANode struct {
 	Children []*ANode `json:"-"`
 }
 
+// ATree is the JSON representation of an alignment tree. Unlike ANode, the
+// children are serialized. Seq uses it to read and write alignment trees.
+type ATree struct {
+	// Start index (inclusive)
+	Start int `json:"s"`
+	// End index (exclusive)
+	End int `json:"e"`
+	// Name of unit being aligned.
+	Name string `json:"n"`
+	// Value is an arbitrary object associated to an interval.
+	Value interface{} `json:"v,omitempty"`
+	// Child alignments one level down.
+	Children []*ATree `json:"c,omitempty"`
+}
+
+// NewATree returns the tree rooted at a.
+func NewATree(a *ANode) *ATree {
+
+	t := &ATree{Start: a.Start, End: a.End, Name: a.Name, Value: a.Value}
+	for _, child := range a.Children {
+		t.Children = append(t.Children, NewATree(child))
+	}
+	return t
+}
+
+// ANode returns the root ANode of the tree. Leaves have no children.
+func (t *ATree) ANode() *ANode {
+
+	a := &ANode{Start: t.Start, End: t.End, Name: t.Name, Value: t.Value}
+	for _, child := range t.Children {
+		a.Children = append(a.Children, child.ANode())
+	}
+	return a
+}
+
 // NewANode creates a new ANode.
 func NewANode(start, end int, name string, value interface{}) *ANode {
 	return &ANode{Start: start, End: end, Name: name, Value: value, Children: []*ANode{}}
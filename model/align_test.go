@@ -6,6 +6,7 @@
 package model
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
@@ -103,6 +104,41 @@ func TestWriteRead(t *testing.T) {
 	}
 }
 
+func TestWriteReadTree(t *testing.T) {
+
+	root := makeTree()
+	b, err := json.Marshal(NewATree(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(b))
+
+	var tree ATree
+	err = json.Unmarshal(b, &tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root2 := tree.ANode()
+	if !root2.IsValid() {
+		t.Fatal("alignment tree is invalid")
+	}
+	al, al2 := root.Alignment(), root2.Alignment()
+	if len(al) != len(al2) {
+		t.Fatalf("num levels don't match - expected %d, got %d", len(al), len(al2))
+	}
+	for level := range al {
+		if len(al[level]) != len(al2[level]) {
+			t.Fatalf("length for level [%d] does not match - expected %d, got %d", level, len(al[level]), len(al2[level]))
+		}
+		for i, a := range al[level] {
+			b := al2[level][i]
+			if a.Start != b.Start || a.End != b.End || a.Name != b.Name {
+				t.Fatalf("nodes don't match - expected %s, got %s", a, b)
+			}
+		}
+	}
+}
+
 func TestAlignLabels(t *testing.T) {
 
 	labels := []string{"a", "a", "b", "c", "c", "c", "c", "c", "c", "d", "d", "d", "d", "e"}
@@ -129,3 +165,20 @@ func TestAlignLabels(t *testing.T) {
 		}
 	}
 }
+
+// ANode children are not serialized.
+func TestANodeJSON(t *testing.T) {
+
+	b, err := json.Marshal(makeTree())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]interface{}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v["c"]; ok {
+		t.Fatalf("children must not be serialized: %s", b)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package bayes implements a Bayes classifier using one model per class.
+
+The posterior probability of class c given observation o is
+
+	                p(o|c) p(c)
+	p(c|o) = -------------------------
+	          sum_k{p(o|k) p(k)}
+
+where p(o|c) is computed using the LogProb method of the class model and p(c)
+is the class prior. The label of the observation is removed before scoring so
+that the reference label can't change the class scores. Predict returns the
+label of the class with the highest posterior probability.
+
+The class models can be of any type, for example, Gaussians, GMMs, or HMMs
+for sequence classification. To train the classifier, use labeled observations.
+Each observation updates the model whose class label matches the observation
+label. Estimate computes the class priors using the number of observations
+of each class and estimates the class models.
+*/
+package bayes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/akualab/gjoa/floatx"
+	"github.com/akualab/gjoa/model"
+	"github.com/golang/glog"
+	"github.com/gonum/floats"
+)
+
+// Model is a Bayes classifier.
+type Model struct {
+	Type      string          `json:"type"`
+	ModelName string          `json:"name"`
+	ModelDim  int             `json:"dim"`
+	Labels    []string        `json:"labels"`
+	LogPriors []float64       `json:"log_priors"`
+	Counts    []float64       `json:"counts,omitempty"`
+	Models    []model.Modeler `json:"models"`
+	index     map[string]int
+	priors    []float64
+}
+
+func init() {
+	model.Register(reflect.TypeOf(Model{}).String(), func(b []byte) (model.Modeler, error) {
+		return Read(bytes.NewReader(b))
+	})
+}
+
+// Option type is used to pass options to NewModel().
+type Option func(*Model)
+
+// NewModel creates a new Bayes classifier. Use the Class option to add
+// the class models. The default class priors are uniform.
+func NewModel(options ...Option) *Model {
+
+	m := &Model{
+		ModelName: "Bayes",
+	}
+	m.Type = reflect.TypeOf(*m).String()
+
+	// Set options.
+	for _, option := range options {
+		option(m)
+	}
+	n := len(m.Models)
+	if n == 0 {
+		glog.Fatalf("need class models to create a classifier - use the Class option")
+	}
+	m.ModelDim = m.Models[0].Dim()
+	m.index = make(map[string]int)
+	for i, label := range m.Labels {
+		if _, ok := m.index[label]; ok {
+			glog.Fatalf("duplicate class label [%s]", label)
+		}
+		m.index[label] = i
+	}
+	if len(m.Counts) == 0 {
+		m.Counts = make([]float64, n)
+	}
+
+	// Initialize priors.
+	switch {
+	case len(m.LogPriors) > 0 && len(m.priors) > 0:
+		glog.Fatal("options not allowed: provide only one of LogPriors or Priors")
+
+	case len(m.LogPriors) == 0 && len(m.priors) == 0:
+		m.LogPriors = make([]float64, n)
+		floatx.Apply(floatx.SetValueFunc(-math.Log(float64(n))), m.LogPriors, nil)
+
+	case len(m.priors) > 0:
+		m.LogPriors = make([]float64, len(m.priors))
+		floatx.Log(m.LogPriors, m.priors)
+	}
+	if len(m.LogPriors) != n {
+		glog.Fatalf("num priors [%d] doesn't match num classes [%d]", len(m.LogPriors), n)
+	}
+	m.priors = make([]float64, n)
+	floatx.Exp(m.priors, m.LogPriors)
+	return m
+}
+
+// Computes the log joint probability of the observation and each class
+// and writes the values to probs. Returns the log prob of the observation.
+func (m *Model) logProbInternal(o model.Obs, probs []float64) float64 {
+
+	o = unlabeled(o)
+	for i, c := range m.Models {
+		probs[i] = c.LogProb(o) + m.LogPriors[i]
+	}
+	return floats.LogSumExp(probs)
+}
+
+// Returns a copy of the observation without the label. Observations of
+// other types are returned unchanged.
+func unlabeled(o model.Obs) model.Obs {
+
+	switch v := o.(type) {
+	case model.FloatObs:
+		return model.NewFloatObs(v.Value().([]float64), "")
+	case model.FloatObsSequence:
+		return model.NewFloatObsSequence(v.Value().([][]float64), "", v.ID())
+	case *model.FloatObsSequence:
+		return model.NewFloatObsSequence(v.Value().([][]float64), "", v.ID())
+	}
+	return o
+}
+
+// LogProb returns the log probability of the observation marginalized over the classes.
+func (m *Model) LogProb(o model.Obs) float64 {
+	return m.logProbInternal(o, make([]float64, len(m.Models)))
+}
+
+// Posteriors returns the posterior probability of each class given the observation.
+// The order of the classes is the same as in the Labels field.
+func (m *Model) Posteriors(o model.Obs) []float64 {
+
+	probs := make([]float64, len(m.Models))
+	logProb := m.logProbInternal(o, probs)
+	floatx.Apply(floatx.AddScalarFunc(-logProb), probs, nil)
+	floatx.Exp(probs, probs)
+	return probs
+}
+
+// Classify returns the label of the class with the highest posterior probability.
+func (m *Model) Classify(o model.Obs) model.Labeler {
+
+	probs := make([]float64, len(m.Models))
+	m.logProbInternal(o, probs)
+	return model.SimpleLabel(m.Labels[floats.MaxIdx(probs)])
+}
+
+// Predict returns the label of the class with the highest posterior
+// probability for each observation.
+func (m *Model) Predict(x model.Observer) ([]model.Labeler, error) {
+
+	c, err := x.ObsChan()
+	if err != nil {
+		return nil, err
+	}
+	var labels []model.Labeler
+	for o := range c {
+		labels = append(labels, m.Classify(o))
+	}
+	if err := model.StreamErr(x); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// UpdateOne updates the model of the class that matches the observation label.
+// Observations whose label doesn't match a class are ignored.
+func (m *Model) UpdateOne(o model.Obs, w float64) {
+
+	k, ok := m.index[o.Label().String()]
+	if !ok {
+		glog.Warningf("oid:%s, skipping observation, no class for label [%s]", o.ID(), o.Label())
+		return
+	}
+	m.Models[k].UpdateOne(o, w)
+	m.Counts[k] += w
+}
+
+// Update updates sufficient statistics using labeled observations.
+func (m *Model) Update(x model.Observer, w func(model.Obs) float64) error {
+	c, e := x.ObsChan()
+	if e != nil {
+		return e
+	}
+	for v := range c {
+		m.UpdateOne(v, w(v))
+	}
+	return model.StreamErr(x)
+}
+
+// Estimate computes the class priors and estimates the class models.
+func (m *Model) Estimate() error {
+
+	total := floats.Sum(m.Counts)
+	if total == 0 {
+		return fmt.Errorf("name:%s, can't estimate classifier using zero samples", m.ModelName)
+	}
+	floatx.Apply(floatx.ScaleFunc(1.0/total), m.Counts, m.priors)
+	floatx.Log(m.LogPriors, m.priors)
+	for i, c := range m.Models {
+		err := c.Estimate()
+		if err != nil {
+			return fmt.Errorf("name:%s, class:%s, %s", m.ModelName, m.Labels[i], err)
+		}
+	}
+	return nil
+}
+
+// Clear resets sufficient statistics.
+func (m *Model) Clear() {
+	for _, c := range m.Models {
+		c.Clear()
+	}
+	floatx.Apply(floatx.SetValueFunc(0), m.Counts, nil)
+}
+
+// Sample returns a sample drawn from a class model selected using the class priors.
+// Vector samples are labeled with the class label.
+func (m *Model) Sample(r *rand.Rand) model.Obs {
+	k := model.RandIntFromDist(m.priors, r)
+	o := m.Models[k].Sample(r)
+	if v, ok := o.Value().([]float64); ok {
+		return model.NewFloatObs(v, model.SimpleLabel(m.Labels[k]))
+	}
+	return o
+}
+
+// SampleChan returns a channel with samples generated by the classifier.
+func (m *Model) SampleChan(r *rand.Rand, size int) <-chan model.Obs {
+
+	return model.SampleChanContext(context.Background(), m, r, size)
+}
+
+// Dim is the dimensionality of the observation vector.
+func (m *Model) Dim() int { return m.ModelDim }
+
+// Name returns the name of the model.
+func (m *Model) Name() string { return m.ModelName }
+
+// Options
+
+// Name is an option to set the model name.
+func Name(name string) Option {
+	return func(m *Model) { m.ModelName = name }
+}
+
+// Class adds a class model with the given label.
+func Class(label string, c model.Modeler) Option {
+	return func(m *Model) {
+		m.Labels = append(m.Labels, label)
+		m.Models = append(m.Models, c)
+	}
+}
+
+// Priors sets the class priors in the order the classes were added.
+func Priors(p []float64) Option {
+	return func(m *Model) { m.priors = p }
+}
+
+// LogPriors sets the class priors using log(p) as the argument.
+func LogPriors(logp []float64) Option {
+	return func(m *Model) { m.LogPriors = logp }
+}
+
+// IO
+
+// Read unmarshals json data from an io.Reader into a model struct.
+// Class models are created using the model decoders registered in package model.
+func Read(r io.Reader) (*Model, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode class models using their type.
+	var v struct {
+		Model
+		Models []json.RawMessage `json:"models"`
+	}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.Labels) != len(v.Models) {
+		return nil, fmt.Errorf("name:%s, num labels [%d] doesn't match num models [%d]",
+			v.ModelName, len(v.Labels), len(v.Models))
+	}
+	options := []Option{Name(v.ModelName), LogPriors(v.LogPriors)}
+	for i, raw := range v.Models {
+		c, err := model.Decode(raw)
+		if err != nil {
+			return nil, fmt.Errorf("name:%s, class:%s, %s", v.ModelName, v.Labels[i], err)
+		}
+		options = append(options, Class(v.Labels[i], c))
+	}
+	m := NewModel(options...)
+	if len(v.Counts) == len(m.Counts) {
+		copy(m.Counts, v.Counts)
+	}
+	return m, nil
+}
+
+// ReadFile unmarshals json data from a file into a model struct.
+func ReadFile(fn string) (*Model, error) {
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	glog.Infof("Reading model from file %s.", fn)
+	return Read(f)
+}
+
+// Write writes the model to an io.Writer.
+func (m *Model) Write(w io.Writer) error {
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, e := w.Write(b)
+	return e
+}
+
+// WriteFile writes the model to file.
+func (m *Model) WriteFile(fn string) error {
+
+	e := os.MkdirAll(filepath.Dir(fn), 0755)
+	if e != nil {
+		return e
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ee := m.Write(f)
+	if ee != nil {
+		return ee
+	}
+
+	glog.Infof("Wrote model \"%s\" to file %s.", m.Name(), fn)
+	return nil
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bayes
+
+import (
+	"bytes"
+	"flag"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/model"
+	"github.com/akualab/gjoa/model/gaussian"
+	"github.com/akualab/gjoa/model/gmm"
+	"github.com/akualab/gjoa/model/hmm"
+)
+
+const epsilon = 0.05
+
+func init() {
+	flag.Set("logtostderr", "true")
+	flag.Set("v", "0")
+}
+
+func makeClassifier() *Model {
+
+	a := gaussian.NewModel(2, gaussian.Name("a"), gaussian.Mean([]float64{0, 0}), gaussian.StdDev([]float64{1, 1}))
+	b := gaussian.NewModel(2, gaussian.Name("b"), gaussian.Mean([]float64{3, 3}), gaussian.StdDev([]float64{1, 2}))
+	return NewModel(Name("clf"), Class("a", a), Class("b", b), Priors([]float64{0.7, 0.3}))
+}
+
+func TestPosteriors(t *testing.T) {
+
+	m := makeClassifier()
+	for _, v := range [][]float64{{0, 0}, {1.5, 1.5}, {3, 4}} {
+		obs := model.F64ToObs(v, "")
+		post := m.Posteriors(obs)
+		if !gjoa.Comparef64(1, post[0]+post[1], 0.00001) {
+			t.Fatalf("posteriors don't add up to one: %v", post)
+		}
+		// Check Bayes rule.
+		pa := m.Models[0].LogProb(obs) + m.LogPriors[0]
+		pb := m.Models[1].LogProb(obs) + m.LogPriors[1]
+		gjoa.CompareFloats(t, 1/(1+math.Exp(pb-pa)), post[0], "wrong posterior", 0.00001)
+	}
+}
+
+func TestTrainPredict(t *testing.T) {
+
+	m0 := makeClassifier()
+	r := rand.New(rand.NewSource(33))
+	n := 10000
+	data := make([][]float64, n)
+	labels := make([]model.SimpleLabel, n)
+	for i := range data {
+		o := m0.Sample(r)
+		data[i] = o.Value().([]float64)
+		labels[i] = o.Label().(model.SimpleLabel)
+	}
+	fo, err := model.NewFloatObserver(data, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := gaussian.NewModel(2, gaussian.Name("a"))
+	b := gaussian.NewModel(2, gaussian.Name("b"))
+	m := NewModel(Class("a", a), Class("b", b))
+	err = m.Update(fo, model.NoWeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Estimate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gjoa.CompareSliceFloat(t, []float64{0.7, 0.3}, m.priors, "wrong priors", epsilon)
+	gjoa.CompareSliceFloat(t, []float64{0, 0}, a.Mean, "wrong mean", epsilon)
+	gjoa.CompareSliceFloat(t, []float64{3, 3}, b.Mean, "wrong mean", epsilon)
+
+	pred, err := m.Predict(fo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errors int
+	for i, p := range pred {
+		if !p.IsEqual(labels[i]) {
+			errors++
+		}
+	}
+	t.Logf("error rate: %f", float64(errors)/float64(n))
+	if float64(errors)/float64(n) > 0.1 {
+		t.Fatalf("error rate is too high: %d errors", errors)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+
+	g := gmm.NewModel(2, 2, gmm.Name("g"))
+	m := makeClassifier()
+	m = NewModel(Name("clf"), Class("a", m.Models[0]), Class("b", m.Models[1]), Class("c", g),
+		Priors([]float64{0.5, 0.3, 0.2}))
+
+	var b bytes.Buffer
+	err := m.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := model.ReadModel(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, ok := x.(*Model)
+	if !ok {
+		t.Fatalf("wrong model type: %T", x)
+	}
+	if m1.Name() != "clf" || len(m1.Labels) != 3 || m1.Labels[2] != "c" {
+		t.Fatalf("wrong model: %+v", m1)
+	}
+	if _, ok := m1.Models[2].(*gmm.Model); !ok {
+		t.Fatalf("class c has wrong type: %T", m1.Models[2])
+	}
+	gjoa.CompareSliceFloat(t, m.LogPriors, m1.LogPriors, "wrong priors", 0.00001)
+	obs := model.F64ToObs([]float64{2, 3}, "")
+	gjoa.CompareSliceFloat(t, m.Posteriors(obs), m1.Posteriors(obs), "wrong posteriors", 0.00001)
+}
+
+// Returns an HMM with a single left-to-right net named name.
+func makeHMM(t *testing.T, name string, mean float64) *hmm.Model {
+
+	ms, err := hmm.NewSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := []model.Modeler{nil}
+	for i := 0; i < 3; i++ {
+		mi := mean + float64(i)
+		b = append(b, gaussian.NewModel(1, gaussian.Mean([]float64{mi}), gaussian.StdDev([]float64{1})))
+	}
+	b = append(b, nil)
+	_, err = ms.NewNet(name, hmm.MakeLeftToRight(5, 0.6, 0), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hmm.NewModel(hmm.OSet(ms), hmm.Name(name), hmm.OAssign(hmm.DirectAssigner{}))
+}
+
+func TestHMMClasses(t *testing.T) {
+
+	a, b := makeHMM(t, "a", 0), makeHMM(t, "b", 2)
+	m := NewModel(Class("a", a), Class("b", b))
+
+	// All the sequences have the same reference label. The label must not
+	// change the class scores.
+	r := rand.New(rand.NewSource(33))
+	var data []model.Obs
+	var expected []string
+	for i := 0; i < 50; i++ {
+		c, name := a, "a"
+		if i%2 == 1 {
+			c, name = b, "b"
+		}
+		o := c.Sample(r)
+		data = append(data, model.NewFloatObsSequence(o.Value().([][]float64), "a", o.ID()))
+		expected = append(expected, name)
+	}
+	labels, err := m.Predict(obsSlice(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errors int
+	for i, lab := range labels {
+		if lab.String() != expected[i] {
+			errors++
+		}
+	}
+	if errors > 5 {
+		t.Fatalf("too many errors, expected at most 5, got %d", errors)
+	}
+	post := m.Posteriors(data[1])
+	if post[1] <= post[0] {
+		t.Fatalf("wrong posteriors, expected class b, got %v", post)
+	}
+}
+
+type obsSlice []model.Obs
+
+func (s obsSlice) ObsChan() (<-chan model.Obs, error) {
+	c := make(chan model.Obs, len(s))
+	for _, o := range s {
+		c <- o
+	}
+	close(c)
+	return c, nil
+}
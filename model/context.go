@@ -0,0 +1,167 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+)
+
+// ContextObserver is an Observer whose stream can be cancelled. When the context
+// is done, the producer stops and the channel is closed.
+type ContextObserver interface {
+	Observer
+
+	// Returns channel of observations.
+	// The sequence ends when the channel closes or when ctx is done.
+	ObsChanContext(ctx context.Context) (<-chan Obs, error)
+}
+
+// ObsChanContext returns a channel of observations from x that is closed when ctx
+// is done. If x implements the ContextObserver interface, the producer stops
+// when ctx is done. Otherwise, the producer can't be stopped and the remaining
+// observations are drained from x in the background so the producer can finish.
+func ObsChanContext(ctx context.Context, x Observer) (<-chan Obs, error) {
+	return forward(ctx, x, nil)
+}
+
+// forward sends the observations in x to the returned channel until the stream
+// ends or ctx is done. Calls cut if ctx is done before the stream ends.
+func forward(ctx context.Context, x Observer, cut func()) (<-chan Obs, error) {
+
+	// The producer context is cancelled when forwarding stops.
+	pctx, cancel := context.WithCancel(context.Background())
+	var in <-chan Obs
+	var err error
+	if cx, ok := x.(ContextObserver); ok {
+		in, err = cx.ObsChanContext(pctx)
+	} else {
+		in, err = x.ObsChan()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	out := make(chan Obs)
+	stop := func() {
+		if cut != nil {
+			cut()
+		}
+		close(out)
+		cancel()
+		for range in {
+		}
+	}
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case o, ok := <-in:
+				if !ok {
+					close(out)
+					return
+				}
+				if ctx.Err() != nil {
+					stop()
+					return
+				}
+				select {
+				case out <- o:
+				case <-ctx.Done():
+					stop()
+					return
+				}
+			case <-ctx.Done():
+				// The stream may have ended before ctx was done.
+				select {
+				case _, ok := <-in:
+					if !ok {
+						close(out)
+						return
+					}
+				default:
+				}
+				stop()
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// ctxObserver is an observer whose stream ends when the context is done.
+type ctxObserver struct {
+	ctx context.Context
+	x   Observer
+	mu  sync.Mutex
+	cut bool // true if the last stream was cut short by ctx.
+}
+
+// WithContext returns an observer that streams the observations in x until ctx is done.
+// Its Err method returns the context error if the stream was cut short. Use it to cancel
+// a Trainer.Update or a Predictor.Predict call:
+//
+//	err := m.Update(model.WithContext(ctx, x), model.NoWeight)
+func WithContext(ctx context.Context, x Observer) ErrObserver {
+	return &ctxObserver{ctx: ctx, x: x}
+}
+
+// ObsChan implements the Observer interface.
+func (o *ctxObserver) ObsChan() (<-chan Obs, error) {
+	o.setCut(false)
+	return forward(o.ctx, o.x, func() { o.setCut(true) })
+}
+
+// Err returns the context error if the stream was cut short because the context
+// is done. Otherwise, returns the error that ended the underlying stream, if any.
+func (o *ctxObserver) Err() error {
+	o.mu.Lock()
+	cut := o.cut
+	o.mu.Unlock()
+	if cut {
+		return o.ctx.Err()
+	}
+	return StreamErr(o.x)
+}
+
+func (o *ctxObserver) setCut(cut bool) {
+	o.mu.Lock()
+	o.cut = cut
+	o.mu.Unlock()
+}
+
+// UpdateContext updates the sufficient statistics of t using the observations in x.
+// Returns the context error if ctx is done before the stream ends.
+func UpdateContext(ctx context.Context, t Trainer, x Observer, w func(Obs) float64) error {
+
+	cx := WithContext(ctx, x)
+	err := t.Update(cx, w)
+	if err == nil {
+		err = cx.Err()
+	}
+	return err
+}
+
+// SampleChanContext returns a channel with "size" samples generated by s.
+// The channel is closed after the last sample or when ctx is done. The models
+// use it to implement SampleChan. Use a context to stop the producer when the
+// channel is not consumed to the end.
+func SampleChanContext(ctx context.Context, s Sampler, r *rand.Rand, size int) <-chan Obs {
+
+	c := make(chan Obs, 1000)
+	go func() {
+		defer close(c)
+		for i := 0; i < size; i++ {
+			select {
+			case c <- s.Sample(r):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// Observers must support cancellation.
+var (
+	_ ContextObserver = (*SeqObserver)(nil)
+	_ ContextObserver = FloatObserver{}
+)
+
+// sliceObserver doesn't implement the ContextObserver interface.
+type sliceObserver []Obs
+
+func (s sliceObserver) ObsChan() (<-chan Obs, error) {
+	c := make(chan Obs)
+	go func() {
+		for _, o := range s {
+			c <- o
+		}
+		close(c)
+	}()
+	return c, nil
+}
+
+// countTrainer counts the observations passed to UpdateOne.
+type countTrainer struct {
+	n      int
+	cancel func()
+}
+
+func (m *countTrainer) Update(x Observer, w func(Obs) float64) error {
+	c, err := x.ObsChan()
+	if err != nil {
+		return err
+	}
+	for o := range c {
+		m.UpdateOne(o, w(o))
+	}
+	return StreamErr(x)
+}
+func (m *countTrainer) UpdateOne(o Obs, w float64) {
+	m.n++
+	if m.n == 10 && m.cancel != nil {
+		m.cancel()
+	}
+}
+func (m *countTrainer) Estimate() error { return nil }
+func (m *countTrainer) Clear()          { m.n = 0 }
+
+// Consumes the channel and returns the number of values. Fails if the channel
+// is not closed before the timeout.
+func consume(t *testing.T, c <-chan Obs) int {
+	n := 0
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-c:
+			if !ok {
+				return n
+			}
+			n++
+		case <-timeout:
+			t.Fatalf("channel was not closed after cancel")
+		}
+	}
+}
+
+func makeFloatObserver(n int) *FloatObserver {
+	r := rand.New(rand.NewSource(33))
+	fo, _ := NewFloatObserver(randFloats(r, n, 2), make([]SimpleLabel, n))
+	return fo
+}
+
+func makeSliceObserver(n int) sliceObserver {
+	fo := makeFloatObserver(n)
+	var s sliceObserver
+	for i := 0; i < n; i++ {
+		s = append(s, NewFloatObs(fo.Values[i], ""))
+	}
+	return s
+}
+
+func TestObsChanContext(t *testing.T) {
+
+	n := 100000
+	fo := makeFloatObserver(n)
+	s := makeSliceObserver(n)
+
+	for _, x := range []Observer{fo, s} {
+		ctx, cancel := context.WithCancel(context.Background())
+		c, err := ObsChanContext(ctx, x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-c
+		cancel()
+		if m := consume(t, c); m >= n-1 {
+			t.Fatalf("stream was not cancelled, got %d values", m)
+		}
+	}
+
+	// Full stream.
+	c, err := ObsChanContext(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := consume(t, c); m != n {
+		t.Fatalf("wrong num values, expected %d, got %d", n, m)
+	}
+}
+
+func TestSeqObserverCancel(t *testing.T) {
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	r := rand.New(rand.NewSource(33))
+	n := 5000
+	for i := 0; i < n; i++ {
+		enc.Encode(Seq{Vectors: randFloats(r, 3, 2), ID: "x"})
+	}
+	obs, err := NewSeqObserver(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := obs.ObsChanContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-c
+	cancel()
+	if m := consume(t, c); m >= n-1 {
+		t.Fatalf("stream was not cancelled, got %d values", m)
+	}
+	if obs.Err() != context.Canceled {
+		t.Fatalf("expected context error, got %v", obs.Err())
+	}
+}
+
+func TestUpdateContext(t *testing.T) {
+
+	n := 100000
+	fo := makeFloatObserver(n)
+
+	m := &countTrainer{}
+	err := UpdateContext(context.Background(), m, fo, NoWeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.n != n {
+		t.Fatalf("wrong count, expected %d, got %d", n, m.n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m = &countTrainer{cancel: cancel}
+	err = UpdateContext(ctx, m, fo, NoWeight)
+	if err != context.Canceled {
+		t.Fatalf("expected context error, got %v", err)
+	}
+	if m.n >= n {
+		t.Fatalf("update was not cancelled, count is %d", m.n)
+	}
+
+	// Done context.
+	m = &countTrainer{}
+	err = UpdateContext(ctx, m, fo, NoWeight)
+	if err != context.Canceled {
+		t.Fatalf("expected context error, got %v", err)
+	}
+}
+
+func TestWithContextErr(t *testing.T) {
+
+	n := 1000
+	for _, x := range []Observer{makeFloatObserver(n), makeSliceObserver(n)} {
+
+		// Stream ends before cancel.
+		ctx, cancel := context.WithCancel(context.Background())
+		cx := WithContext(ctx, x)
+		c, err := cx.ObsChan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := consume(t, c); m != n {
+			t.Fatalf("wrong num values, expected %d, got %d", n, m)
+		}
+		cancel()
+		if cx.Err() != nil {
+			t.Fatalf("stream was not cut short, expected no error, got %v", cx.Err())
+		}
+
+		// Stream is cut short.
+		ctx, cancel = context.WithCancel(context.Background())
+		cx = WithContext(ctx, x)
+		c, err = cx.ObsChan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-c
+		cancel()
+		if m := consume(t, c); m >= n-1 {
+			t.Fatalf("stream was not cancelled, got %d values", m)
+		}
+		if cx.Err() != context.Canceled {
+			t.Fatalf("expected context error, got %v", cx.Err())
+		}
+	}
+}
+
+func TestSampleChanContext(t *testing.T) {
+
+	m := &constModel{}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := SampleChanContext(ctx, m, rand.New(rand.NewSource(33)), 1000000)
+	<-c
+	cancel()
+	if n := consume(t, c); n >= 1000000-1 {
+		t.Fatalf("sampling was not cancelled, got %d samples", n)
+	}
+
+	c = SampleChanContext(context.Background(), m, rand.New(rand.NewSource(33)), 10)
+	if n := consume(t, c); n != 10 {
+		t.Fatalf("wrong num samples, expected 10, got %d", n)
+	}
+}
@@ -7,7 +7,9 @@ package gaussian
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
@@ -30,23 +32,34 @@ const (
 )
 
 // Model is a multivariate Gaussian distribution.
+// When Diag is false, the model uses a full covariance matrix.
 type Model struct {
-	Type        string    `json:"type"`
-	ModelName   string    `json:"name,omitempty"`
-	ModelDim    int       `json:"dim"`
-	NSamples    float64   `json:"nsamples"`
-	Diag        bool      `json:"diag"`
-	Sumx        []float64 `json:"sumx,omitempty"`
-	Sumxsq      []float64 `json:"sumx_sq,omitempty"`
-	Mean        []float64 `json:"mean"`
-	StdDev      []float64 `json:"sd"`
+	Type        string      `json:"type"`
+	ModelName   string      `json:"name,omitempty"`
+	ModelDim    int         `json:"dim"`
+	NSamples    float64     `json:"nsamples"`
+	Diag        bool        `json:"diag"`
+	Sumx        []float64   `json:"sumx,omitempty"`
+	Sumxsq      []float64   `json:"sumx_sq,omitempty"`
+	Sumxx       [][]float64 `json:"sumxx,omitempty"` // Sum of outer products. Full covariance only.
+	Mean        []float64   `json:"mean"`
+	StdDev      []float64   `json:"sd"`
+	Cov         [][]float64 `json:"cov,omitempty"` // Full covariance only.
+	Prior       *Prior      `json:"prior,omitempty"`
 	variance    []float64
 	varianceInv []float64
+	chol        [][]float64 // Cholesky factor of Cov.
 	tmpArray    []float64
 	const1      float64 // -(N/2)log(2PI) Depends only on ModelDim.
 	const2      float64 // const1 - sum(log sigma_i) Also depends on variance.
 }
 
+func init() {
+	model.Register(reflect.TypeOf(Model{}).String(), func(b []byte) (model.Modeler, error) {
+		return Read(bytes.NewReader(b))
+	})
+}
+
 // Option type is used to pass options to NewModel().
 type Option func(*Model)
 
@@ -82,13 +95,28 @@ func NewModel(dim int, options ...Option) *Model {
 	}
 
 	floatx.Sq(g.variance, g.StdDev)
+	g.const1 = -float64(g.ModelDim) * math.Log(2.0*math.Pi) / 2.0
 
-	// Initializes variance, varianceInv, and StdDev.
-	g.setVariance(g.variance)
+	if g.Diag {
+		// Initializes variance, varianceInv, and StdDev.
+		g.setVariance(g.variance)
+		g.setConst()
+		return g
+	}
 
-	floatx.Log(g.tmpArray, g.variance)
-	g.const1 = -float64(g.ModelDim) * math.Log(2.0*math.Pi) / 2.0
-	g.const2 = g.const1 - floats.Sum(g.tmpArray)/2.0
+	// Full covariance. Use the variance to initialize the covariance matrix if not provided.
+	if len(g.Sumxx) == 0 {
+		g.Sumxx = floatx.MakeFloat2D(dim, dim)
+	}
+	if len(g.Cov) == 0 {
+		g.Cov = floatx.MakeFloat2D(dim, dim)
+		for i, v := range g.variance {
+			g.Cov[i][i] = v
+		}
+	}
+	if err := g.setCov(g.Cov); err != nil {
+		glog.Fatalf("failed to create gaussian model, name:%s, error: %s", g.ModelName, err)
+	}
 	return g
 }
 
@@ -101,22 +129,43 @@ func (g *Model) Update(x model.Observer, w func(model.Obs) float64) error {
 	for v := range c {
 		g.UpdateOne(v, w(v))
 	}
-	return nil
+	return model.StreamErr(x)
 }
 
-// Predict returns a hypothesis given the observation.
+// Predict is not supported by a single Gaussian. Use a classifier to predict
+// labels using a set of models. See package bayes.
 func (g *Model) Predict(x model.Observer) ([]model.Labeler, error) {
-
-	glog.Fatal("Predict method not implemented.")
-	return nil, nil
+	return nil, fmt.Errorf("name:%s, predict is not supported by a Gaussian model - use a classifier", g.ModelName)
 }
 
 // Sample returns a Gaussian sample.
 func (g *Model) Sample(r *rand.Rand) model.Obs {
+	if !g.Diag {
+		return model.NewFloatObs(g.sampleFull(r), model.SimpleLabel(""))
+	}
 	obs := model.RandNormalVector(r, g.Mean, g.StdDev)
 	return model.NewFloatObs(obs, model.SimpleLabel(""))
 }
 
+// Returns mean + L z where L is the Cholesky factor of the covariance
+// matrix and z is a vector of independent standard normal samples.
+func (g *Model) sampleFull(r *rand.Rand) []float64 {
+
+	z := make([]float64, g.ModelDim)
+	for i := range z {
+		z[i] = r.NormFloat64()
+	}
+	obs := make([]float64, g.ModelDim)
+	for i := range obs {
+		v := g.Mean[i]
+		for k := 0; k <= i; k++ {
+			v += g.chol[i][k] * z[k]
+		}
+		obs[i] = v
+	}
+	return obs
+}
+
 // SampleChan returns a channel with "size" samples drawn from the model.
 // The sequence ends when the channel closes.
 func (g *Model) SampleChan(r *rand.Rand, size int) <-chan model.Obs {
@@ -127,14 +176,7 @@ func (g *Model) SampleChan(r *rand.Rand, size int) <-chan model.Obs {
 	if len(g.StdDev) == 0 {
 		glog.Fatal("Parameter StdDev is missing.")
 	}
-	c := make(chan model.Obs, 1000)
-	go func() {
-		for i := 0; i < size; i++ {
-			c <- g.Sample(r)
-		}
-		close(c)
-	}()
-	return c
+	return model.SampleChanContext(context.Background(), g, r, size)
 }
 
 // LogProb returns log probability for observation.
@@ -145,6 +187,9 @@ func (g *Model) LogProb(obs model.Obs) float64 {
 
 func (g *Model) logProb(obs []float64) (v float64) {
 
+	if !g.Diag {
+		return g.logProbFull(obs)
+	}
 	for i, x := range obs {
 		s := g.Mean[i] - x
 		v += s * s * g.varianceInv[i] / 2.0
@@ -153,6 +198,19 @@ func (g *Model) logProb(obs []float64) (v float64) {
 	return
 }
 
+// Computes the log prob using the Cholesky factor L of the covariance matrix.
+// (x-mean)' inv(Cov) (x-mean) = |y|^2 where L y = x-mean.
+// The difference vector is allocated on each call instead of using a scratch
+// buffer in the model so that LogProb is safe for concurrent use. (HMM training
+// scores the sequences concurrently, see hmm.NumWorkers.)
+func (g *Model) logProbFull(obs []float64) float64 {
+
+	d := make([]float64, g.ModelDim)
+	floats.SubTo(d, obs, g.Mean)
+	floatx.ForwardSubst(g.chol, d, d)
+	return g.const2 - floats.Dot(d, d)/2.0
+}
+
 func (g *Model) prob(obs []float64) float64 {
 
 	return math.Exp(g.logProb(obs))
@@ -170,12 +228,21 @@ func (g *Model) UpdateOne(o model.Obs, w float64) {
 	floatx.Sq(g.tmpArray, obs)
 	floats.Scale(w, g.tmpArray)
 	floats.Add(g.Sumxsq, g.tmpArray)
+	if !g.Diag {
+		for i, xi := range obs {
+			floats.AddScaled(g.Sumxx[i], w*xi, obs)
+		}
+	}
 	g.NSamples += w
 }
 
 // Estimate computes model parameters using sufficient statistics.
+// When a conjugate prior is set, returns the MAP estimate.
 func (g *Model) Estimate() error {
 
+	if g.Prior != nil {
+		return g.estimateMAP()
+	}
 	if g.NSamples > minNumSamples {
 
 		/* Estimate the mean. */
@@ -191,18 +258,37 @@ func (g *Model) Estimate() error {
 		floatx.Apply(floatx.ScaleFunc(1.0/g.NSamples), g.Sumxsq, tmp)
 		floats.SubTo(g.variance, tmp, g.tmpArray)
 		floatx.Apply(floatx.Floorv(smallVar), g.variance, nil)
+
+		/* Estimate the covariance. cov = 1/n sumxx - mean mean' */
+		if !g.Diag {
+			for i := range g.Cov {
+				for j := range g.Cov[i] {
+					g.Cov[i][j] = g.Sumxx[i][j]/g.NSamples - g.Mean[i]*g.Mean[j]
+				}
+			}
+		}
 	} else {
 
 		/* Not enough training sample. */
 		glog.Warningf("not enough training samples, name [%s], num samples [%e]", g.ModelName, g.NSamples)
 		floatx.Apply(floatx.SetValueFunc(smallVar), g.variance, nil)
 		floatx.Apply(floatx.SetValueFunc(0), g.Mean, nil)
+		if !g.Diag {
+			floatx.Clear2D(g.Cov)
+			for i, v := range g.variance {
+				g.Cov[i][i] = v
+			}
+		}
+	}
+	if !g.Diag {
+		err := g.setCov(g.Cov)
+		glog.V(6).Infof("gaussian reest, name:%s, mean:%v, cov:%v", g.ModelName, g.Mean, g.Cov)
+		return err
 	}
 	g.setVariance(g.variance) // to update varInv and stddev.
 
 	/* Update log Gaussian constant. */
-	floatx.Log(g.tmpArray, g.variance)
-	g.const2 = g.const1 - floats.Sum(g.tmpArray)/2.0
+	g.setConst()
 
 	glog.V(6).Infof("gaussian reest, name:%s, mean:%v, sd:%v", g.ModelName, g.Mean, g.StdDev)
 	return nil
@@ -213,15 +299,77 @@ func (g *Model) Clear() {
 
 	floatx.Apply(floatx.SetValueFunc(0), g.Sumx, nil)
 	floatx.Apply(floatx.SetValueFunc(0), g.Sumxsq, nil)
+	floatx.Clear2D(g.Sumxx)
 	g.NSamples = 0
 }
 
+// Merge adds the sufficient statistics of src to g. Both models must be
+// Gaussian models with the same dimension and covariance type.
+func (g *Model) Merge(src model.Modeler) error {
+
+	s, ok := src.(*Model)
+	if !ok {
+		return fmt.Errorf("name:%s, can't merge model of type %T", g.ModelName, src)
+	}
+	if s.ModelDim != g.ModelDim || s.Diag != g.Diag {
+		return fmt.Errorf("name:%s, can't merge model %s, dim:%d/%d, diag:%t/%t",
+			g.ModelName, s.ModelName, g.ModelDim, s.ModelDim, g.Diag, s.Diag)
+	}
+	floats.Add(g.Sumx, s.Sumx)
+	floats.Add(g.Sumxsq, s.Sumxsq)
+	if !g.Diag {
+		for i := range g.Sumxx {
+			floats.Add(g.Sumxx[i], s.Sumxx[i])
+		}
+	}
+	g.NSamples += s.NSamples
+	return nil
+}
+
 func (g *Model) setVariance(variance []float64) {
 	copy(g.variance, variance)
 	floatx.Apply(floatx.Inv, g.variance, g.varianceInv)
 	g.StdDev = g.standardDeviation()
 }
 
+// Sets the covariance matrix. Eigenvalues less than smallVar are floored to
+// guarantee that the matrix is positive definite. Updates the Cholesky factor,
+// the variance, the standard deviation, and the log Gaussian constant.
+func (g *Model) setCov(cov [][]float64) error {
+
+	if n := floatx.FloorEigen(cov, smallVar); n > 0 {
+		glog.V(4).Infof("floored %d covariance eigenvalues, name:%s", n, g.ModelName)
+	}
+	chol, err := floatx.Cholesky(cov)
+	if err != nil {
+		return fmt.Errorf("name:%s, %s", g.ModelName, err)
+	}
+	g.Cov = cov
+	g.chol = chol
+	for i := range g.variance {
+		g.variance[i] = cov[i][i]
+	}
+	g.setVariance(g.variance)
+	g.setConst()
+	return nil
+}
+
+// Sets const2 using the determinant of the covariance matrix.
+func (g *Model) setConst() {
+
+	if !g.Diag {
+		// log|Cov| = 2 sum(log L_ii)
+		var logDet float64
+		for i := range g.chol {
+			logDet += 2 * math.Log(g.chol[i][i])
+		}
+		g.const2 = g.const1 - logDet/2.0
+		return
+	}
+	floatx.Log(g.tmpArray, g.variance)
+	g.const2 = g.const1 - floats.Sum(g.tmpArray)/2.0
+}
+
 func (g *Model) standardDeviation() (sd []float64) {
 
 	sd = make([]float64, g.ModelDim)
@@ -254,6 +402,21 @@ func StdDev(sd []float64) Option {
 	return func(g *Model) { g.StdDev = sd }
 }
 
+// Diag is an option function. Set to false to use a full covariance matrix.
+// Default is true.
+func Diag(flag bool) Option {
+	return func(g *Model) { g.Diag = flag }
+}
+
+// Cov is an option function. Use it to set a full covariance
+// matrix when creating a new Gaussian model. Sets Diag to false.
+func Cov(cov [][]float64) Option {
+	return func(g *Model) {
+		g.Cov = cov
+		g.Diag = false
+	}
+}
+
 // SetName sets a name for the model.
 func (g *Model) setName(name string) {
 	g.ModelName = name
@@ -275,14 +438,42 @@ func Clone(src *Model) Option {
 
 		ng.Sumx = g.Sumx
 		ng.Sumxsq = g.Sumxsq
+		ng.Sumxx = g.Sumxx
 
 		ng.Mean = g.Mean
 		ng.StdDev = g.StdDev
+		ng.Cov = g.Cov
+		ng.Prior = g.Prior
 	}
 }
 
 // IO
 
+// UnmarshalJSON implements the json.Unmarshaler interface. Models written
+// before full covariance support may not have the diag field. They are
+// diagonal unless the covariance matrix is present.
+func (g *Model) UnmarshalJSON(b []byte) error {
+
+	type plain Model // no UnmarshalJSON method
+	err := json.Unmarshal(b, (*plain)(g))
+	if err != nil {
+		return err
+	}
+	var v struct {
+		Diag *bool `json:"diag"`
+	}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+	if v.Diag != nil {
+		g.Diag = *v.Diag
+	} else {
+		g.Diag = len(g.Cov) == 0
+	}
+	return nil
+}
+
 // Read unmarshals json data from an io.Reader into a model struct.
 func Read(r io.Reader) (*Model, error) {
 
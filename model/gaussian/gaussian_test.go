@@ -6,16 +6,38 @@
 package gaussian
 
 import (
+	"bytes"
+	"errors"
+	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/floatx"
 	"github.com/akualab/gjoa/model"
 )
 
 const tolerance = 0.03 // |x-y| / (1+|avg(x,y)|)
 
+// errObserver streams the observations and fails at the end of the stream.
+type errObserver struct {
+	obs []model.Obs
+	err error
+}
+
+func (x *errObserver) ObsChan() (<-chan model.Obs, error) {
+	c := make(chan model.Obs, len(x.obs))
+	for _, o := range x.obs {
+		c <- o
+	}
+	close(c)
+	return c, nil
+}
+
+func (x *errObserver) Err() error { return x.err }
+
 // Tests
 
 func TestGaussian(t *testing.T) {
@@ -179,6 +201,131 @@ func TestCloneGaussian(t *testing.T) {
 	}
 }
 
+func TestFullCov(t *testing.T) {
+
+	mean := []float64{0.5, 1}
+	cov := [][]float64{{2, 0.8}, {0.8, 1}}
+	g := NewModel(2, Name("full"), Mean(mean), Cov(cov))
+	obs := []float64{1, 0}
+
+	// Bivariate normal density.
+	s1, s2 := math.Sqrt(cov[0][0]), math.Sqrt(cov[1][1])
+	rho := cov[0][1] / (s1 * s2)
+	z1, z2 := (obs[0]-mean[0])/s1, (obs[1]-mean[1])/s2
+	q := (z1*z1 - 2*rho*z1*z2 + z2*z2) / (1 - rho*rho)
+	expected := -math.Log(2*math.Pi*s1*s2*math.Sqrt(1-rho*rho)) - q/2
+
+	p := g.logProb(obs)
+	if !gjoa.Comparef64(expected, p, 0.00001) {
+		t.Errorf("Wrong LogProb. Expected: [%f], Got: [%f]", expected, p)
+	}
+
+	// A full covariance model with a diagonal matrix must match the diagonal model.
+	gd := NewModel(2, Mean(mean), StdDev([]float64{s1, s2}))
+	gf := NewModel(2, Mean(mean), StdDev([]float64{s1, s2}), Diag(false))
+	if !gjoa.Comparef64(gd.logProb(obs), gf.logProb(obs), 0.00001) {
+		t.Errorf("Wrong LogProb. Expected: [%f], Got: [%f]", gd.logProb(obs), gf.logProb(obs))
+	}
+}
+
+func TestTrainFullCov(t *testing.T) {
+
+	dim := 3
+	mean := []float64{0.1, 0.2, 1}
+	cov := [][]float64{
+		{1, 0.6, 0.2},
+		{0.6, 0.5, 0.1},
+		{0.2, 0.1, 0.3},
+	}
+	g0 := NewModel(dim, Mean(mean), Cov(cov))
+	g := NewModel(dim, Name("test training"), Diag(false))
+
+	r := rand.New(rand.NewSource(33))
+	for i := 0; i < 200000; i++ {
+		g.UpdateOne(g0.Sample(r), 1.0)
+	}
+	fatalIf(t, g.Estimate())
+	t.Logf("Mean: \n%+v", g.Mean)
+	t.Logf("Cov: \n%+v", g.Cov)
+
+	gjoa.CompareSliceFloat(t, mean, g.Mean, "Wrong Mean", tolerance)
+	for i := range cov {
+		gjoa.CompareSliceFloat(t, cov[i], g.Cov[i], "Wrong Cov", tolerance)
+	}
+	gjoa.CompareSliceFloat(t, g0.StdDev, g.StdDev, "Wrong SD", tolerance)
+}
+
+func TestFloorCov(t *testing.T) {
+
+	// Perfectly correlated data. The covariance matrix is singular.
+	g := NewModel(2, Diag(false))
+	r := rand.New(rand.NewSource(33))
+	for i := 0; i < 1000; i++ {
+		x := r.NormFloat64()
+		g.UpdateOne(model.F64ToObs([]float64{x, 2 * x}, ""), 1.0)
+	}
+	fatalIf(t, g.Estimate())
+	vals, _ := floatx.SymEigen(g.Cov)
+	for _, v := range vals {
+		if v < smallVar*(1-0.00001) {
+			t.Fatalf("eigenvalue %e is less than the floor %e", v, smallVar)
+		}
+	}
+	if p := g.logProb([]float64{1, 2}); math.IsNaN(p) || math.IsInf(p, 0) {
+		t.Fatalf("log prob is %f", p)
+	}
+}
+
+// Models written before full covariance support have no diag field.
+func TestReadDiagDefault(t *testing.T) {
+
+	data := `{"type":"gaussian.Model","name":"old","dim":2,"nsamples":0,"mean":[1,2],"sd":[0.5,2]}`
+	g, err := Read(bytes.NewBufferString(data))
+	fatalIf(t, err)
+	if !g.Diag {
+		t.Fatalf("expected diagonal model")
+	}
+	g0 := NewModel(2, Mean([]float64{1, 2}), StdDev([]float64{0.5, 2}))
+	obs := []float64{0, 1}
+	gjoa.CompareFloats(t, g0.logProb(obs), g.logProb(obs), "wrong log prob", 1e-12)
+
+	data = `{"type":"gaussian.Model","name":"old","dim":2,"nsamples":0,"mean":[1,2],"sd":[1,1],"cov":[[1,0.5],[0.5,1]]}`
+	g, err = Read(bytes.NewBufferString(data))
+	fatalIf(t, err)
+	if g.Diag {
+		t.Fatalf("expected full covariance model")
+	}
+}
+
+func TestWriteReadFullCov(t *testing.T) {
+
+	cov := [][]float64{{2, 0.8}, {0.8, 1}}
+	g := NewModel(2, Name("testing"), Mean([]float64{0.5, 1}), Cov(cov))
+
+	var b bytes.Buffer
+	fatalIf(t, g.Write(&b))
+	g1, e := Read(&b)
+	fatalIf(t, e)
+
+	if g1.Diag {
+		t.Fatalf("expected full covariance model")
+	}
+	CompareGaussians(t, g, g1, tolerance)
+	for i := range cov {
+		gjoa.CompareSliceFloat(t, g.Cov[i], g1.Cov[i], "Wrong Cov", 0.00001)
+	}
+	obs := []float64{1, 0}
+	if !gjoa.Comparef64(g.logProb(obs), g1.logProb(obs), 0.00001) {
+		t.Errorf("Wrong LogProb. Expected: [%f], Got: [%f]", g.logProb(obs), g1.logProb(obs))
+	}
+}
+
+func fatalIf(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Train without using sampler.
 func BenchmarkTrain(b *testing.B) {
 
@@ -235,3 +382,72 @@ func CompareGaussians(t *testing.T, g1 *Model, g2 *Model, tolerance float64) {
 	gjoa.CompareSliceFloat(t, g1.Mean, g2.Mean, "Wrong Mean", tolerance)
 	gjoa.CompareSliceFloat(t, g1.StdDev, g2.StdDev, "Wrong SD", tolerance)
 }
+
+func TestUpdateStreamError(t *testing.T) {
+
+	g := NewModel(2)
+	x := &errObserver{
+		obs: []model.Obs{model.F64ToObs([]float64{1, 2}, ""), model.F64ToObs([]float64{3, 4}, "")},
+		err: errors.New("stream truncated"),
+	}
+	if err := g.Update(x, model.NoWeight); err != x.err {
+		t.Fatalf("wrong error. Expected: [%v], Got: [%v]", x.err, err)
+	}
+	x.err = nil
+	if err := g.Update(x, model.NoWeight); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Merging the statistics of two data shards must give the same
+// estimate as training with all the data.
+func TestMerge(t *testing.T) {
+
+	mean := []float64{0.1, 0.2, 1}
+	cov := [][]float64{
+		{1, 0.6, 0.2},
+		{0.6, 0.5, 0.1},
+		{0.2, 0.1, 0.3},
+	}
+	g0 := NewModel(3, Mean(mean), Cov(cov))
+	for _, diag := range []bool{true, false} {
+		g := NewModel(3, Diag(diag))
+		g1 := NewModel(3, Diag(diag))
+		g2 := NewModel(3, Diag(diag))
+		r := rand.New(rand.NewSource(33))
+		for i := 0; i < 1000; i++ {
+			o := g0.Sample(r)
+			g.UpdateOne(o, 1.0)
+			if i%3 == 0 {
+				g1.UpdateOne(o, 1.0)
+			} else {
+				g2.UpdateOne(o, 1.0)
+			}
+		}
+
+		// Merge the statistics read from a file.
+		fn := filepath.Join(os.TempDir(), "gaussian-merge.json")
+		fatalIf(t, g2.WriteFile(fn))
+		g3, err := ReadFile(fn)
+		fatalIf(t, err)
+		fatalIf(t, g1.Merge(g3))
+		fatalIf(t, g.Estimate())
+		fatalIf(t, g1.Estimate())
+
+		if g.NSamples != g1.NSamples {
+			t.Fatalf("diag:%t, wrong num samples, expected %f, got %f", diag, g.NSamples, g1.NSamples)
+		}
+		gjoa.CompareSliceFloat(t, g.Mean, g1.Mean, "Wrong Mean", 1e-9)
+		gjoa.CompareSliceFloat(t, g.StdDev, g1.StdDev, "Wrong SD", 1e-9)
+		for i := range g.Cov {
+			gjoa.CompareSliceFloat(t, g.Cov[i], g1.Cov[i], "Wrong Cov", 1e-9)
+		}
+	}
+
+	if err := NewModel(3).Merge(NewModel(2)); err == nil {
+		t.Fatalf("expected error when merging models with different dims")
+	}
+	if err := NewModel(3).Merge(NewModel(3, Diag(false))); err == nil {
+		t.Fatalf("expected error when merging models with different covariance types")
+	}
+}
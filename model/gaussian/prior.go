@@ -0,0 +1,146 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gaussian
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/akualab/gjoa/floatx"
+	"github.com/akualab/gjoa/model"
+	"github.com/golang/glog"
+)
+
+/*
+  Conjugate prior for a Gaussian with diagonal covariance. Each dimension has an
+  independent Normal-Inverse-Gamma prior parameterized as in Gelman et al.,
+  "Bayesian Data Analysis", Section 3.3:
+
+   var  ~ Inv-Chi2(nu0, var0)
+   mean ~ N(mean0, var / kappa0)
+
+  where kappa0 and nu0 are the pseudo-counts of the mean and the variance. In the
+  usual Inverse-Gamma notation, alpha0 = nu0/2 and beta0 = nu0 var0 / 2. Given n
+  samples with sample mean xbar, the posterior has the same form with:
+
+   kappa = kappa0 + n
+   nu    = nu0 + n
+   mean  = (kappa0 mean0 + sum(x)) / kappa
+   nu var = nu0 var0 + sum((x-xbar)^2) + kappa0 n / kappa (xbar-mean0)^2
+
+  The MAP estimate is the joint mode of the posterior: (mean, nu var / (nu + 3)).
+  The posterior predictive is a Student-t distribution with nu degrees of freedom,
+  location mean, and squared scale var (kappa + 1) / kappa.
+
+*/
+
+// Prior is a Normal-Inverse-Gamma conjugate prior for the mean and variance
+// of a Gaussian with diagonal covariance.
+type Prior struct {
+	Mean      []float64 `json:"mean"`
+	Variance  []float64 `json:"variance"`
+	MeanCount float64   `json:"mean_count"` // kappa0
+	VarCount  float64   `json:"var_count"`  // nu0
+}
+
+// ConjugatePrior is an option to set a Normal-Inverse-Gamma prior. Estimate
+// returns the MAP estimate instead of the maximum likelihood estimate. The
+// pseudo-counts are the weight of the prior mean and variance measured in
+// number of samples. Use a positive meanCount. The prior is only supported
+// for diagonal covariance.
+func ConjugatePrior(mean, variance []float64, meanCount, varCount float64) Option {
+	return func(g *Model) {
+		g.Prior = &Prior{
+			Mean:      mean,
+			Variance:  variance,
+			MeanCount: meanCount,
+			VarCount:  varCount,
+		}
+	}
+}
+
+func (p *Prior) check(g *Model) error {
+
+	if !g.Diag {
+		return fmt.Errorf("name:%s, conjugate prior requires a diagonal covariance", g.ModelName)
+	}
+	if len(p.Mean) != g.ModelDim || len(p.Variance) != g.ModelDim {
+		return fmt.Errorf("name:%s, prior dim is [%d/%d], model dim is [%d]", g.ModelName, len(p.Mean), len(p.Variance), g.ModelDim)
+	}
+	if p.MeanCount <= 0 || p.VarCount < 0 {
+		return fmt.Errorf("name:%s, prior pseudo-counts must be positive, mean:%f, variance:%f", g.ModelName, p.MeanCount, p.VarCount)
+	}
+	for i, v := range p.Variance {
+		if v <= 0 {
+			return fmt.Errorf("name:%s, prior variance must be positive, got [%f] for element [%d]", g.ModelName, v, i)
+		}
+	}
+	return nil
+}
+
+// Computes the posterior parameters using the sufficient statistics of g.
+// Returns the posterior mean, nu var, kappa, and nu.
+func (p *Prior) posterior(g *Model) ([]float64, []float64, float64, float64) {
+
+	n := g.NSamples
+	kappa := p.MeanCount + n
+	nu := p.VarCount + n
+	mean := make([]float64, g.ModelDim)
+	nuVar := make([]float64, g.ModelDim)
+	for i, m0 := range p.Mean {
+		mean[i] = (p.MeanCount*m0 + g.Sumx[i]) / kappa
+		nuVar[i] = p.VarCount * p.Variance[i]
+		if n > 0 {
+			xbar := g.Sumx[i] / n
+			d := xbar - m0
+			nuVar[i] += math.Max(g.Sumxsq[i]-n*xbar*xbar, 0) + p.MeanCount*n/kappa*d*d
+		}
+	}
+	return mean, nuVar, kappa, nu
+}
+
+// Sets the mean and variance to the mode of the posterior.
+func (g *Model) estimateMAP() error {
+
+	if err := g.Prior.check(g); err != nil {
+		return err
+	}
+	mean, nuVar, _, nu := g.Prior.posterior(g)
+	copy(g.Mean, mean)
+	for i, v := range nuVar {
+		g.variance[i] = math.Max(v/(nu+3), smallVar)
+	}
+	g.setVariance(g.variance)
+	g.setConst()
+	glog.V(6).Infof("gaussian map reest, name:%s, mean:%v, sd:%v", g.ModelName, g.Mean, g.StdDev)
+	return nil
+}
+
+// PredictiveLogProb returns the log of the posterior predictive probability of
+// the observation using the prior and the sufficient statistics accumulated
+// since the last call to Clear. Returns an error if the model has no prior.
+func (g *Model) PredictiveLogProb(o model.Obs) (float64, error) {
+
+	if g.Prior == nil {
+		return 0, fmt.Errorf("name:%s, posterior predictive requires a conjugate prior", g.ModelName)
+	}
+	if err := g.Prior.check(g); err != nil {
+		return 0, err
+	}
+	mean, nuVar, kappa, nu := g.Prior.posterior(g)
+	if nu <= 0 {
+		return 0, fmt.Errorf("name:%s, posterior predictive requires a positive variance pseudo-count or data", g.ModelName)
+	}
+	x := o.Value().([]float64)
+	c := floatx.Lgamma((nu+1)/2) - floatx.Lgamma(nu/2) - math.Log(nu*math.Pi)/2
+	var v float64
+	for i, xi := range x {
+		s2 := math.Max(nuVar[i]/nu*(kappa+1)/kappa, smallVar)
+		d := xi - mean[i]
+		v += c - math.Log(s2)/2 - (nu+1)/2*math.Log1p(d*d/(nu*s2))
+	}
+	return v, nil
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gaussian
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/floatx"
+	"github.com/akualab/gjoa/model"
+)
+
+var (
+	priorMean = []float64{1, -2}
+	priorVar  = []float64{4, 0.25}
+)
+
+// Returns a model with a prior trained using n samples.
+func trainPrior(n int, meanCount, varCount float64) *Model {
+	g0 := NewModel(2, Mean([]float64{3, -1}), StdDev([]float64{1, 2}))
+	r := rand.New(rand.NewSource(defaultSeed))
+	g := NewModel(2, Name("prior"), ConjugatePrior(priorMean, priorVar, meanCount, varCount))
+	for i := 0; i < n; i++ {
+		g.UpdateOne(g0.Sample(r), 1.0)
+	}
+	return g
+}
+
+func TestPriorMAP(t *testing.T) {
+
+	kappa0, nu0 := 5.0, 8.0
+	g := trainPrior(10, kappa0, nu0)
+
+	// Expected parameters using the sufficient statistics.
+	n := g.NSamples
+	mean := make([]float64, 2)
+	variance := make([]float64, 2)
+	for i := range mean {
+		xbar := g.Sumx[i] / n
+		mean[i] = (kappa0*priorMean[i] + n*xbar) / (kappa0 + n)
+		s := g.Sumxsq[i] - n*xbar*xbar
+		d := xbar - priorMean[i]
+		variance[i] = (nu0*priorVar[i] + s + kappa0*n/(kappa0+n)*d*d) / (nu0 + n + 3)
+	}
+	fatalIf(t, g.Estimate())
+	gjoa.CompareSliceFloat(t, mean, g.Mean, "wrong map mean", 1e-9)
+	gjoa.CompareSliceFloat(t, variance, g.variance, "wrong map variance", 1e-9)
+
+	// No data, use the prior mode.
+	g = trainPrior(0, kappa0, nu0)
+	fatalIf(t, g.Estimate())
+	gjoa.CompareSliceFloat(t, priorMean, g.Mean, "wrong map mean", 1e-9)
+	for i, v := range priorVar {
+		gjoa.CompareFloats(t, v*nu0/(nu0+3), g.variance[i], "wrong map variance", 1e-9)
+	}
+
+	// With a lot of data, the estimate is close to the ML estimate.
+	g = trainPrior(20000, kappa0, nu0)
+	fatalIf(t, g.Estimate())
+	gjoa.CompareSliceFloat(t, []float64{3, -1}, g.Mean, "wrong map mean", 0.02)
+	gjoa.CompareSliceFloat(t, []float64{1, 2}, g.StdDev, "wrong map sd", 0.02)
+}
+
+func TestPriorCollapsedVariance(t *testing.T) {
+
+	// A single sample collapses the ML variance to the floor.
+	o := model.F64ToObs([]float64{2, 2}, "")
+	g := NewModel(2)
+	g.UpdateOne(o, 1.0)
+	fatalIf(t, g.Estimate())
+	gjoa.CompareSliceFloat(t, []float64{smallSD, smallSD}, g.StdDev, "wrong ml sd", 1e-9)
+
+	// The prior keeps the variance close to the prior variance.
+	g = NewModel(2, ConjugatePrior(priorMean, priorVar, 1, 10))
+	g.UpdateOne(o, 1.0)
+	fatalIf(t, g.Estimate())
+	for i, v := range g.variance {
+		if v < priorVar[i]/2 {
+			t.Fatalf("variance collapsed, element:%d, expected about %f, got %f", i, priorVar[i], v)
+		}
+	}
+}
+
+func TestPredictiveLogProb(t *testing.T) {
+
+	// One dimension, no data. Compare with the Student-t density.
+	g := NewModel(1, ConjugatePrior([]float64{1}, []float64{2}, 3, 4))
+	x := 2.5
+	lp, err := g.PredictiveLogProb(model.F64ToObs([]float64{x}, ""))
+	fatalIf(t, err)
+	nu, s2 := 4.0, 2.0*4.0/3.0
+	d := (x - 1) * (x - 1) / (nu * s2)
+	expected := floatx.Lgamma((nu+1)/2) - floatx.Lgamma(nu/2) - math.Log(nu*math.Pi*s2)/2 - (nu+1)/2*math.Log(1+d)
+	gjoa.CompareFloats(t, expected, lp, "wrong predictive log prob", 1e-9)
+
+	// The predictive density integrates to one.
+	g = trainPrior(5, 2, 3)
+	var sum float64
+	step := 0.1
+	for x0 := -30.0; x0 < 30; x0 += step {
+		for x1 := -30.0; x1 < 30; x1 += step {
+			lp, err := g.PredictiveLogProb(model.F64ToObs([]float64{x0, x1}, ""))
+			fatalIf(t, err)
+			sum += math.Exp(lp) * step * step
+		}
+	}
+	gjoa.CompareFloats(t, 1, sum, "predictive density doesn't integrate to one", 0.01)
+
+	// With a lot of data, the predictive is close to the Gaussian.
+	g = trainPrior(20000, 2, 3)
+	fatalIf(t, g.Estimate())
+	o := model.F64ToObs([]float64{2, 0}, "")
+	lp, err = g.PredictiveLogProb(o)
+	fatalIf(t, err)
+	gjoa.CompareFloats(t, g.LogProb(o), lp, "wrong predictive log prob", 0.01)
+}
+
+func TestPriorErrors(t *testing.T) {
+
+	o := model.F64ToObs([]float64{1, 1}, "")
+	g := NewModel(2)
+	if _, err := g.PredictiveLogProb(o); err == nil {
+		t.Fatalf("expected error, model has no prior")
+	}
+	g = NewModel(2, ConjugatePrior([]float64{1}, []float64{1}, 1, 1))
+	if err := g.Estimate(); err == nil {
+		t.Fatalf("expected error, prior dim doesn't match")
+	}
+	g = NewModel(2, ConjugatePrior(priorMean, priorVar, 0, 1))
+	if err := g.Estimate(); err == nil {
+		t.Fatalf("expected error, mean pseudo-count is zero")
+	}
+	g = NewModel(2, Cov([][]float64{{1, 0}, {0, 1}}), ConjugatePrior(priorMean, priorVar, 1, 1))
+	if err := g.Estimate(); err == nil {
+		t.Fatalf("expected error, full covariance is not supported")
+	}
+}
+
+func TestWriteReadPrior(t *testing.T) {
+
+	g := trainPrior(10, 5, 8)
+	fatalIf(t, g.Estimate())
+
+	var b bytes.Buffer
+	fatalIf(t, g.Write(&b))
+	g1, err := Read(&b)
+	fatalIf(t, err)
+	if g1.Prior == nil {
+		t.Fatalf("prior is missing")
+	}
+	CompareGaussians(t, g, g1, 1e-12)
+	o := model.F64ToObs([]float64{2, 0}, "")
+	lp, err := g.PredictiveLogProb(o)
+	fatalIf(t, err)
+	lp1, err := g1.PredictiveLogProb(o)
+	fatalIf(t, err)
+	gjoa.CompareFloats(t, lp, lp1, "wrong predictive log prob", 1e-12)
+}
@@ -6,6 +6,8 @@
 package gmm
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -19,10 +21,16 @@ import (
 	"github.com/akualab/gjoa/floatx"
 	"github.com/akualab/gjoa/model"
 	"github.com/akualab/gjoa/model/gaussian"
+	"github.com/akualab/gjoa/model/kmeans"
 	"github.com/golang/glog"
 	"github.com/gonum/floats"
 )
 
+const (
+	smallSD  = 0.01
+	smallVar = smallSD * smallSD
+)
+
 // Model is a mixture of Gaussian distributions.
 type Model struct {
 	Type         string            `json:"type"`
@@ -38,6 +46,20 @@ type Model struct {
 	Components   []*gaussian.Model `json:"components,omitempty"`
 	Iteration    int               `json:"iteration"`
 	tmpProbs     []float64
+	maxApprox    bool
+	prior        *Model // MAP estimation.
+	tau          float64
+	adaptVar     bool
+	adaptWeights bool
+	vb           *vbState // Variational Bayes.
+	pruneWeight  float64  // Variational Bayes pruning threshold.
+	vbAlpha      float64  // Overrides the Dirichlet concentration of the vb prior.
+}
+
+func init() {
+	model.Register(reflect.TypeOf(Model{}).String(), func(b []byte) (model.Modeler, error) {
+		return Read(bytes.NewReader(b))
+	})
 }
 
 // Option type is used to pass options to NewModel().
@@ -52,6 +74,7 @@ func NewModel(dim, numComponents int, options ...Option) *Model {
 		NComponents: numComponents,
 		Diag:        true,
 		tmpProbs:    make([]float64, numComponents),
+		pruneWeight: defaultVBPruneWeight,
 	}
 	gmm.Type = reflect.TypeOf(*gmm).String()
 
@@ -100,28 +123,24 @@ func NewModel(dim, numComponents int, options ...Option) *Model {
 
 // Computes log prob for mixture.// SIDE EFFECT => returns logProb of
 // Gaussian comp + logWeight in matrix pointed by func arg probs.
+// Returns the log of the sum of the component probabilities or the
+// max component log prob when the MaxApprox option is set.
 func (gmm *Model) logProbInternal(obs, probs []float64) float64 {
 
-	var max = -math.MaxFloat64
+	if probs == nil {
+		probs = make([]float64, gmm.NComponents)
+	}
 
 	/* Compute log probabilities for this observation. */
+	o := model.F64ToObs(obs, "")
 	for i, c := range gmm.Components {
-		o := model.F64ToObs(obs, "")
-		v1 := c.LogProb(o)
-		v2 := gmm.LogWeights[i]
-		v := v1 + v2
-
-		if probs != nil {
-			probs[i] = v
-		}
-		if v > max {
-			max = v
-		}
+		probs[i] = c.LogProb(o) + gmm.LogWeights[i]
 	}
 
-	// To simplify computation, use the max prob in the denominator instead
-	// of the sum.
-	return max
+	if gmm.maxApprox {
+		return floats.Max(probs)
+	}
+	return floats.LogSumExp(probs)
 }
 
 // LogProb returns log probability for observation.
@@ -136,32 +155,57 @@ func (gmm *Model) prob(obs []float64) float64 {
 	return math.Exp(gmm.LogProb(model.F64ToObs(obs, "")))
 }
 
-// Predict returns a hypothesis given the observation.
+// Predict returns the name of the component with the highest posterior
+// probability for each observation. To predict class labels using a set
+// of models, use a classifier. See package bayes.
 func (gmm *Model) Predict(x model.Observer) ([]model.Labeler, error) {
 
-	glog.Fatal("Predict method not implemented.")
-	return nil, nil
+	c, err := x.ObsChan()
+	if err != nil {
+		return nil, err
+	}
+	var labels []model.Labeler
+	probs := make([]float64, gmm.NComponents)
+	for o := range c {
+		gmm.logProbInternal(o.Value().([]float64), probs)
+		k := floats.MaxIdx(probs)
+		labels = append(labels, model.SimpleLabel(gmm.Components[k].Name()))
+	}
+	if err := model.StreamErr(x); err != nil {
+		return nil, err
+	}
+	return labels, nil
 }
 
 /*
-  The posterior prob for each mixture component. We approximate the sum using max.
+  The posterior prob for each mixture component.
+
+                    p(o|c(i)) p(c(i))
+   p(c(i)|o) = ---------------------------
+                sum_j{p(o|c(j)) p(c(j))}
 
-                 p(o|c(i)) p(c(i))
-   p(c(i)|o) ~ ---------------------
-                max{p(o|c(i)) p(c(i))}
+  When the MaxApprox option is set, we approximate the sum using max.
+  The posteriors don't add up to one in this case.
 
 */
 
-// Estimate computes model parameters using sufficient statistics.
+// UpdateOne updates sufficient statistics using one weighted observation.
 func (gmm *Model) UpdateOne(o model.Obs, w float64) {
 
+	if gmm.vb != nil && gmm.vb.ready(gmm) {
+		gmm.updateOneVB(o, w)
+		return
+	}
 	obs, _, _ := model.ObsToF64(o)
-	maxProb := gmm.logProbInternal(obs, gmm.tmpProbs)
-	gmm.Likelihood += maxProb
-	floatx.Apply(floatx.AddScalarFunc(-maxProb+math.Log(w)), gmm.tmpProbs, nil)
+	logProb := gmm.logProbInternal(obs, gmm.tmpProbs)
+	gmm.Likelihood += logProb
+	floatx.Apply(floatx.AddScalarFunc(-logProb+math.Log(w)), gmm.tmpProbs, nil)
 
 	// Compute posterior probabilities.
 	floatx.Exp(gmm.tmpProbs, gmm.tmpProbs)
+	if gmm.vb != nil {
+		gmm.vb.addEntropy(gmm.tmpProbs, w)
+	}
 
 	// Update posterior sum, needed to compute mixture weights.
 	floats.Add(gmm.PosteriorSum, gmm.tmpProbs)
@@ -184,12 +228,21 @@ func (gmm *Model) Update(x model.Observer, w func(model.Obs) float64) error {
 	for v := range c {
 		gmm.UpdateOne(v, w(v))
 	}
-	return nil
+	return model.StreamErr(x)
 }
 
 // Estimate computes model parameters using sufficient statistics.
+// When the MAP option is set, uses MAP adaptation instead of maximum likelihood.
+// When the VariationalBayes option is set, uses variational Bayes.
 func (gmm *Model) Estimate() error {
 
+	if gmm.prior != nil {
+		return gmm.estimateMAP()
+	}
+	if gmm.vb != nil {
+		return gmm.estimateVB()
+	}
+
 	// Estimate mixture weights.
 	floatx.Apply(floatx.ScaleFunc(1.0/gmm.NSamples), gmm.PosteriorSum, gmm.Weights)
 	floatx.Log(gmm.LogWeights, gmm.Weights)
@@ -215,6 +268,47 @@ func (gmm *Model) Clear() {
 	floatx.Apply(floatx.SetValueFunc(0), gmm.PosteriorSum, nil)
 	gmm.NSamples = 0
 	gmm.Likelihood = 0
+	if gmm.vb != nil {
+		gmm.vb.entropy = 0
+	}
+}
+
+// Merge adds the sufficient statistics of src to gmm, including the
+// statistics of the components. Both models must be GMMs with the same
+// dimension and number of components. The component statistics are
+// only meaningful if both models had the same parameters when the
+// statistics were computed.
+func (gmm *Model) Merge(src model.Modeler) error {
+
+	s, ok := src.(*Model)
+	if !ok {
+		return fmt.Errorf("name:%s, can't merge model of type %T", gmm.ModelName, src)
+	}
+	if s.ModelDim != gmm.ModelDim || s.NComponents != gmm.NComponents {
+		return fmt.Errorf("name:%s, can't merge model %s, dim:%d/%d, num components:%d/%d",
+			gmm.ModelName, s.ModelName, gmm.ModelDim, s.ModelDim, gmm.NComponents, s.NComponents)
+	}
+	// Validate the components before modifying the accumulators.
+	for i, c := range gmm.Components {
+		sc := s.Components[i]
+		if sc.ModelDim != c.ModelDim || sc.Diag != c.Diag {
+			return fmt.Errorf("name:%s, can't merge component %d, dim:%d/%d, diag:%t/%t",
+				gmm.ModelName, i, c.ModelDim, sc.ModelDim, c.Diag, sc.Diag)
+		}
+	}
+	for i, c := range gmm.Components {
+		err := c.Merge(s.Components[i])
+		if err != nil {
+			return err
+		}
+	}
+	floats.Add(gmm.PosteriorSum, s.PosteriorSum)
+	gmm.NSamples += s.NSamples
+	gmm.Likelihood += s.Likelihood
+	if gmm.vb != nil && s.vb != nil {
+		gmm.vb.entropy += s.vb.entropy
+	}
+	return nil
 }
 
 // Sample returns a GMM sample.
@@ -231,14 +325,7 @@ func (gmm *Model) SampleChan(r *rand.Rand, size int) <-chan model.Obs {
 	if len(gmm.Weights) == 0 {
 		glog.Fatal("Parameter Weights is missing.")
 	}
-	c := make(chan model.Obs, 1000)
-	go func() {
-		for i := 0; i < size; i++ {
-			c <- gmm.Sample(r)
-		}
-		close(c)
-	}()
-	return c
+	return model.SampleChanContext(context.Background(), gmm, r, size)
 }
 
 // Returns a random vector using the mean and sd vectors.
@@ -277,6 +364,82 @@ func RandomModel(mean, sd []float64, numComponents int,
 	return gmm
 }
 
+// KMeansModel creates a Gaussian mixture model initialized from data. The mean,
+// variance, and weight of each component are estimated by clustering the vectors in
+// the observer using the k-means algorithm with k-means++ seeding.
+// Use this function to initialize the GMM before training.
+func KMeansModel(x model.Observer, numComponents int, seed int64, options ...Option) (*Model, error) {
+
+	km := kmeans.NewModel(numComponents, kmeans.Seed(seed))
+	err := km.Cluster(x)
+	if err != nil {
+		return nil, err
+	}
+	dim := km.Dim()
+	gmm := NewModel(dim, numComponents, options...)
+	for i := range gmm.Components {
+		sd := make([]float64, dim)
+		floatx.Apply(floatx.Floorv(smallVar), km.Variances[i], sd)
+		floatx.Sqrt(sd, sd)
+		gmm.Components[i] = gaussian.NewModel(dim, gaussian.Name(gmm.Components[i].Name()),
+			gaussian.Mean(km.Means[i]), gaussian.StdDev(sd))
+	}
+	copy(gmm.Weights, km.Weights)
+	floatx.Log(gmm.LogWeights, gmm.Weights)
+	glog.Infof("init gmm using kmeans, iterations:%d, distortion:%f, weights:%v", km.Iterations, km.Distortion, gmm.Weights)
+	return gmm, nil
+}
+
+// Split increases the number of components to numComponents by repeatedly
+// splitting the component with the largest weight. The mean of the selected
+// component is perturbed by +/- epsilon times the standard deviation and the
+// weight is divided equally between the two new components. For example, use
+// 2*NComponents to double the number of components. All components are renamed
+// using the model name and the sufficient statistics are cleared.
+func (gmm *Model) Split(numComponents int, epsilon float64) error {
+
+	if numComponents <= gmm.NComponents {
+		return fmt.Errorf("name:%s, num components must be greater than %d, got %d", gmm.ModelName, gmm.NComponents, numComponents)
+	}
+	for len(gmm.Components) < numComponents {
+		k := floats.MaxIdx(gmm.Weights)
+		c1, c2 := splitGaussian(gmm.Components[k], epsilon)
+		gmm.Components[k] = c1
+		gmm.Components = append(gmm.Components, c2)
+		gmm.Weights[k] /= 2
+		gmm.Weights = append(gmm.Weights, gmm.Weights[k])
+		glog.V(2).Infof("split component, name:%s, component:%d, weight:%f", gmm.ModelName, k, gmm.Weights[k])
+	}
+	gmm.NComponents = numComponents
+	gmm.LogWeights = make([]float64, numComponents)
+	floatx.Log(gmm.LogWeights, gmm.Weights)
+	gmm.PosteriorSum = make([]float64, numComponents)
+	gmm.tmpProbs = make([]float64, numComponents)
+	for i, c := range gmm.Components {
+		c.ModelName = componentName(gmm.ModelName, i, numComponents)
+	}
+	gmm.Clear()
+	return nil
+}
+
+// Returns two Gaussians with the same variance as g and means
+// perturbed by +/- epsilon times the standard deviation.
+func splitGaussian(g *gaussian.Model, epsilon float64) (*gaussian.Model, *gaussian.Model) {
+
+	split := func(sign float64) *gaussian.Model {
+		mean := make([]float64, g.ModelDim)
+		floats.AddScaledTo(mean, g.Mean, sign*epsilon, g.StdDev)
+		sd := make([]float64, g.ModelDim)
+		copy(sd, g.StdDev)
+		options := []gaussian.Option{gaussian.Name(g.ModelName), gaussian.Mean(mean), gaussian.StdDev(sd)}
+		if !g.Diag {
+			options = append(options, gaussian.Cov(floatx.CopyFloat2D(g.Cov)))
+		}
+		return gaussian.NewModel(g.ModelDim, options...)
+	}
+	return split(1), split(-1)
+}
+
 func componentName(name string, n, numComponents int) string {
 
 	max := numComponents - 1
@@ -408,6 +571,13 @@ func LogWeights(logw []float64) Option {
 	return func(gmm *Model) { gmm.LogWeights = logw }
 }
 
+// MaxApprox option. When true, the mixture log probability is approximated
+// using the max component score instead of the sum. Faster, but LogProb is
+// only an approximation and the posteriors used for training don't add up to one.
+func MaxApprox(flag bool) Option {
+	return func(gmm *Model) { gmm.maxApprox = flag }
+}
+
 // Clone create a clone of src.
 func Clone(src *Model) Option {
 	return func(m *Model) {
@@ -415,6 +585,7 @@ func Clone(src *Model) Option {
 		m.Diag = src.Diag
 		m.PosteriorSum = src.PosteriorSum
 		m.Iteration = src.Iteration
+		m.maxApprox = src.maxApprox
 	}
 }
 
@@ -435,6 +606,10 @@ func Read(r io.Reader) (*Model, error) {
 	if e != nil {
 		return nil, e
 	}
+	// Initialize the components.
+	for i, c := range m.Components {
+		m.Components[i] = gaussian.NewModel(c.ModelDim, gaussian.Clone(c))
+	}
 	m = NewModel(m.ModelDim, m.NComponents, Clone(m), LogWeights(m.LogWeights),
 		Components(m.Components), Name(m.ModelName))
 	return m, nil
@@ -6,7 +6,9 @@
 package gmm
 
 import (
+	"bytes"
 	"flag"
+	"math"
 	"math/rand"
 	"os"
 	"testing"
@@ -14,9 +16,13 @@ import (
 	"github.com/akualab/gjoa"
 	"github.com/akualab/gjoa/model"
 	"github.com/akualab/gjoa/model/gaussian"
+	"github.com/gonum/floats"
 )
 
-const epsilon = 0.004
+const (
+	epsilon     = 0.004
+	smallNumber = 0.000001
+)
 
 func init() {
 	flag.Set("logtostderr", "true")
@@ -63,16 +69,10 @@ func TestTrainGMM(t *testing.T) {
 
 		r := rand.New(rand.NewSource(seed))
 		for i := 0; i < numObs; i++ {
-			rv, err := model.RandNormalVector(mean0, std0, r)
-			if err != nil {
-				t.Fatal(err)
-			}
-			g.UpdateOne(model.F64ToObs(rv), 1.0)
-			rv, err = model.RandNormalVector(mean1, std1, r)
-			if err != nil {
-				t.Fatal(err)
-			}
-			g.UpdateOne(model.F64ToObs(rv), 1.0)
+			rv := model.RandNormalVector(r, mean0, std0)
+			g.UpdateOne(model.F64ToObs(rv, ""), 1.0)
+			rv = model.RandNormalVector(r, mean1, std1)
+			g.UpdateOne(model.F64ToObs(rv, ""), 1.0)
 		}
 		g.Estimate()
 		t.Logf("Gaussian Model for training set:")
@@ -96,16 +96,10 @@ func TestTrainGMM(t *testing.T) {
 
 		// Update GMM stats.
 		for i := 0; i < numObs; i++ {
-			rv, err := model.RandNormalVector(mean0, std0, r)
-			if err != nil {
-				t.Fatal(err)
-			}
-			gmm.UpdateOne(model.F64ToObs(rv), 1.0)
-			rv, err = model.RandNormalVector(mean1, std1, r)
-			if err != nil {
-				t.Fatal(err)
-			}
-			gmm.UpdateOne(model.F64ToObs(rv), 1.0)
+			rv := model.RandNormalVector(r, mean0, std0)
+			gmm.UpdateOne(model.F64ToObs(rv, ""), 1.0)
+			rv = model.RandNormalVector(r, mean1, std1)
+			gmm.UpdateOne(model.F64ToObs(rv, ""), 1.0)
 		}
 
 		// Estimates GMM params.
@@ -190,6 +184,7 @@ func TestTrainGMM2(t *testing.T) {
 	mean01 := []float64{2.5, 3}
 	sd01 := []float64{0.70710678118, 0.70710678118}
 	gmm = RandomModel(mean01, sd01, numComp, "mygmm", 99)
+	r := rand.New(rand.NewSource(33))
 
 	for iter := 0; iter < numIter; iter++ {
 		t.Logf("Starting GMM training iteration %d.", iter)
@@ -199,9 +194,9 @@ func TestTrainGMM2(t *testing.T) {
 
 		for i := 0; i < numObs; i++ {
 			// random from gmm0
-			//			rv := gmm0.Sample().(model.FloatObs)
+			//			rv := gmm0.Sample(r).(model.FloatObs)
 			//			gmm.UpdateOne(rv.Value().([]float64), 1.0)
-			rv := gmm0.Sample()
+			rv := gmm0.Sample(r)
 			gmm.UpdateOne(rv, 1.0)
 
 		}
@@ -240,12 +235,13 @@ func TestWriteReadGMM(t *testing.T) {
 	mean01 := []float64{2.5, 3}
 	sd01 := []float64{0.70710678118, 0.70710678118}
 	gmm = RandomModel(mean01, sd01, numComp, "mygmm", 99)
+	r := rand.New(rand.NewSource(33))
 	for iter := 0; iter < numIter; iter++ {
 		t.Logf("Starting GMM training iteration %d.", iter)
 		gmm.Clear()
 
 		for i := 0; i < numObs; i++ {
-			rv := gmm0.Sample()
+			rv := gmm0.Sample(r)
 			gmm.UpdateOne(rv, 1.0)
 		}
 		gmm.Estimate()
@@ -283,3 +279,271 @@ func CompareGaussians(t *testing.T, g1 *gaussian.Model, g2 *gaussian.Model, epsi
 	gjoa.CompareSliceFloat(t, g1.Mean, g2.Mean, "Wrong Mean", epsilon)
 	gjoa.CompareSliceFloat(t, g1.StdDev, g2.StdDev, "Wrong SD", epsilon)
 }
+
+func TestLogProb(t *testing.T) {
+
+	gmm := MakeGMM(t)
+	obs := model.F64ToObs([]float64{2.5, 3}, "")
+	var expected float64
+	for i, c := range gmm.Components {
+		expected += gmm.Weights[i] * math.Exp(c.LogProb(obs))
+	}
+	expected = math.Log(expected)
+	actual := gmm.LogProb(obs)
+	if !gjoa.Comparef64(expected, actual, epsilon) {
+		t.Fatalf("Wrong log prob. Expected: [%f], Got: [%f]", expected, actual)
+	}
+
+	// The max approximation is a lower bound.
+	approx := NewModel(2, 2, Components(gmm.Components), Weights(gmm.Weights), MaxApprox(true))
+	if approx.LogProb(obs) >= actual {
+		t.Fatalf("max approximation [%f] must be less than log prob [%f]", approx.LogProb(obs), actual)
+	}
+}
+
+func TestPosteriors(t *testing.T) {
+
+	gmm := MakeGMM(t)
+	r := rand.New(rand.NewSource(33))
+	gmm.Clear()
+	for i := 0; i < 1000; i++ {
+		gmm.UpdateOne(gmm.Sample(r), 2.0)
+	}
+	if !gjoa.Comparef64(gmm.NSamples, floats.Sum(gmm.PosteriorSum), epsilon) {
+		t.Fatalf("Posteriors don't add up to the num samples. Expected: [%f], Got: [%f]",
+			gmm.NSamples, floats.Sum(gmm.PosteriorSum))
+	}
+}
+
+func TestPredict(t *testing.T) {
+
+	gmm := MakeGMM(t)
+	obs := model.F64ToObs([]float64{2.5, 3}, "")
+	post := make([]float64, gmm.NComponents)
+	gmm.logProbInternal(obs.Value().([]float64), post)
+	fo, err := model.NewFloatObserver([][]float64{{2.5, 3}}, make([]model.SimpleLabel, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels, err := gmm.Predict(fo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := gmm.Components[floats.MaxIdx(post)].Name()
+	if len(labels) != 1 || labels[0].String() != expected {
+		t.Fatalf("wrong prediction. Expected: [%s], Got: %v", expected, labels)
+	}
+}
+
+// Train using data generated by a mixture of overlapping components.
+func TestTrainOverlapping(t *testing.T) {
+
+	numIter := 40
+	numObs := 20000
+	g0 := gaussian.NewModel(1, gaussian.Mean([]float64{0}), gaussian.StdDev([]float64{1}))
+	g1 := gaussian.NewModel(1, gaussian.Mean([]float64{3}), gaussian.StdDev([]float64{1}))
+	gmm0 := NewModel(1, 2, Components([]*gaussian.Model{g0, g1}), Weights([]float64{0.3, 0.7}))
+
+	r := rand.New(rand.NewSource(33))
+	data := make([]model.Obs, numObs)
+	for i := range data {
+		data[i] = gmm0.Sample(r)
+	}
+
+	c0 := gaussian.NewModel(1, gaussian.Mean([]float64{-1}), gaussian.StdDev([]float64{1.5}))
+	c1 := gaussian.NewModel(1, gaussian.Mean([]float64{4}), gaussian.StdDev([]float64{1.5}))
+	gmm := NewModel(1, 2, Components([]*gaussian.Model{c0, c1}))
+	prev := math.Inf(-1)
+	for iter := 0; iter < numIter; iter++ {
+		gmm.Clear()
+		for _, o := range data {
+			gmm.UpdateOne(o, 1.0)
+		}
+		// EM never decreases the likelihood.
+		if gmm.Likelihood < prev-smallNumber {
+			t.Fatalf("iter:%d, likelihood decreased from %f to %f", iter, prev, gmm.Likelihood)
+		}
+		prev = gmm.Likelihood
+		gmm.Estimate()
+		t.Logf("iter:%d, likelihood:%f, weights:%v", iter, gmm.Likelihood, gmm.Weights)
+	}
+
+	const eps = 0.1
+	CompareGaussians(t, g0, gmm.Components[0], eps)
+	CompareGaussians(t, g1, gmm.Components[1], eps)
+	gjoa.CompareSliceFloat(t, gmm0.Weights, gmm.Weights, "Wrong weights", eps)
+}
+
+func TestKMeansModel(t *testing.T) {
+
+	gmm0 := MakeGMM(t)
+	r := rand.New(rand.NewSource(33))
+	numObs := 20000
+	values := make([][]float64, numObs)
+	for i := range values {
+		values[i] = gmm0.Sample(r).Value().([]float64)
+	}
+	fo, err := model.NewFloatObserver(values, make([]model.SimpleLabel, numObs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gmm, err := KMeansModel(fo, 2, 99, Name("kmeans"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gmm.Components[1].Name() != "kmeans-1" {
+		t.Fatalf("wrong component name: %s", gmm.Components[1].Name())
+	}
+
+	// Initial params must be close to the true params.
+	const eps = 0.1
+	k0, k1 := 0, 1
+	if gmm.Components[0].Mean[0] > gmm.Components[1].Mean[0] {
+		k0, k1 = 1, 0
+	}
+	CompareGaussians(t, gmm0.Components[0], gmm.Components[k0], eps)
+	CompareGaussians(t, gmm0.Components[1], gmm.Components[k1], 0.2)
+	gjoa.CompareSliceFloat(t, gmm0.Weights, []float64{gmm.Weights[k0], gmm.Weights[k1]}, "wrong weights", eps)
+}
+
+func TestSplit(t *testing.T) {
+
+	g := gaussian.NewModel(2, gaussian.Mean([]float64{1, 2}), gaussian.StdDev([]float64{0.5, 2}))
+	gmm := NewModel(2, 1, Name("mix"), Components([]*gaussian.Model{g}))
+	err := gmm.Split(3, 0.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gmm.NComponents != 3 || len(gmm.Components) != 3 || len(gmm.PosteriorSum) != 3 {
+		t.Fatalf("expected 3 components, got %d", gmm.NComponents)
+	}
+	for i, c := range gmm.Components {
+		if c.Name() != componentName("mix", i, 3) {
+			t.Fatalf("wrong component name: %s", c.Name())
+		}
+		gjoa.CompareSliceFloat(t, g.StdDev, c.StdDev, "wrong sd", epsilon)
+	}
+	gjoa.CompareSliceFloat(t, []float64{0.25, 0.5, 0.25}, gmm.Weights, "wrong weights", epsilon)
+	// Component 0 is split twice.
+	gjoa.CompareSliceFloat(t, []float64{1.2, 2.8}, gmm.Components[0].Mean, "wrong mean", epsilon)
+	gjoa.CompareSliceFloat(t, []float64{0.9, 1.6}, gmm.Components[1].Mean, "wrong mean", epsilon)
+	gjoa.CompareSliceFloat(t, []float64{1.0, 2.0}, gmm.Components[2].Mean, "wrong mean", epsilon)
+
+	if err := gmm.Split(3, 0.2); err == nil {
+		t.Fatalf("expected error when num components doesn't increase")
+	}
+}
+
+// Grow a GMM from one to two components and train with EM.
+func TestMixUp(t *testing.T) {
+
+	gmm0 := MakeGMM(t)
+	r := rand.New(rand.NewSource(33))
+	data := make([]model.Obs, 20000)
+	for i := range data {
+		data[i] = gmm0.Sample(r)
+	}
+
+	gmm := NewModel(2, 1, Name("mixup"))
+	train := func(numIter int) {
+		for iter := 0; iter < numIter; iter++ {
+			gmm.Clear()
+			for _, o := range data {
+				gmm.UpdateOne(o, 1.0)
+			}
+			gmm.Estimate()
+		}
+	}
+	train(1)
+	if err := gmm.Split(2, 0.2); err != nil {
+		t.Fatal(err)
+	}
+	train(20)
+
+	k0, k1 := 0, 1
+	if gmm.Components[0].Mean[0] > gmm.Components[1].Mean[0] {
+		k0, k1 = 1, 0
+	}
+	const eps = 0.05
+	CompareGaussians(t, gmm0.Components[0], gmm.Components[k0], eps)
+	CompareGaussians(t, gmm0.Components[1], gmm.Components[k1], eps)
+	gjoa.CompareSliceFloat(t, gmm0.Weights, []float64{gmm.Weights[k0], gmm.Weights[k1]}, "wrong weights", eps)
+}
+
+// Distributed EM. In each iteration, two copies of the model update the
+// statistics using a data shard. The reducer merges the statistics and
+// estimates the parameters. The result must match training with all the data.
+func TestMerge(t *testing.T) {
+
+	gmm0 := MakeGMM(t)
+	r := rand.New(rand.NewSource(33))
+	data := make([]model.Obs, 5000)
+	for i := range data {
+		data[i] = gmm0.Sample(r)
+	}
+	shards := [][]model.Obs{data[:2000], data[2000:]}
+
+	// Copies the model using its JSON representation.
+	copyGMM := func(m *Model) *Model {
+		var b bytes.Buffer
+		fatalIf(t, m.Write(&b))
+		c, err := Read(&b)
+		fatalIf(t, err)
+		return c
+	}
+
+	gmm := RandomModel([]float64{2.5, 3}, []float64{0.7, 0.7}, 2, "serial", 99)
+	reducer := copyGMM(gmm)
+	for iter := 0; iter < 5; iter++ {
+		gmm.Clear()
+		for _, o := range data {
+			gmm.UpdateOne(o, 1.0)
+		}
+		fatalIf(t, gmm.Estimate())
+
+		var parts []*Model
+		for _, shard := range shards {
+			m := copyGMM(reducer)
+			m.Clear()
+			for _, o := range shard {
+				m.UpdateOne(o, 1.0)
+			}
+			parts = append(parts, copyGMM(m))
+		}
+		reducer.Clear()
+		for _, m := range parts {
+			fatalIf(t, reducer.Merge(m))
+		}
+		fatalIf(t, reducer.Estimate())
+	}
+
+	if !gjoa.Comparef64(gmm.NSamples, reducer.NSamples, 1e-9) {
+		t.Fatalf("wrong num samples, expected %f, got %f", gmm.NSamples, reducer.NSamples)
+	}
+	gjoa.CompareSliceFloat(t, gmm.Weights, reducer.Weights, "wrong weights", 1e-6)
+	for i, c := range gmm.Components {
+		CompareGaussians(t, c, reducer.Components[i], 1e-6)
+	}
+
+	if err := gmm.Merge(NewModel(2, 3)); err == nil {
+		t.Fatalf("expected error when merging models with different num components")
+	}
+	if err := gmm.Merge(gmm.Components[0]); err == nil {
+		t.Fatalf("expected error when merging models with different types")
+	}
+
+	// The last component doesn't match. The model must not change.
+	bad := copyGMM(gmm)
+	bad.Components[1] = gaussian.NewModel(2, gaussian.Diag(false))
+	sumx := append([]float64(nil), gmm.Components[0].Sumx...)
+	if err := gmm.Merge(bad); err == nil {
+		t.Fatalf("expected error when merging components with different covariance types")
+	}
+	gjoa.CompareSliceFloat(t, sumx, gmm.Components[0].Sumx, "accumulators changed after failed merge", 1e-12)
+}
+
+func fatalIf(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gmm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/akualab/gjoa/floatx"
+	"github.com/akualab/gjoa/model/gaussian"
+	"github.com/golang/glog"
+	"github.com/gonum/floats"
+)
+
+/*
+  MAP adaptation using a relevance factor. See:
+
+  D. A. Reynolds, T. F. Quatieri, and R. B. Dunn, "Speaker verification using
+  adapted Gaussian mixture models," Digital Signal Processing, 2000.
+
+  For component k with posterior count n(k), the adaptation coefficient is:
+
+   alpha(k) = n(k) / (n(k) + tau)
+
+  The adapted parameters interpolate the statistics of the adaptation data
+  and the parameters of the prior model (UBM):
+
+   mean(k)   = alpha(k) E[x] + (1 - alpha(k)) mean_ubm(k)
+   var(k)    = alpha(k) E[x^2] + (1 - alpha(k)) (var_ubm(k) + mean_ubm(k)^2) - mean(k)^2
+   weight(k) = gamma [alpha(k) n(k) / N + (1 - alpha(k)) weight_ubm(k)]
+
+  where gamma normalizes the weights to add up to one. Components with no
+  data keep the UBM parameters.
+
+*/
+
+// MAP is an option to estimate the parameters using maximum a posteriori
+// adaptation. The prior is the universal background model ubm and tau is the
+// relevance factor. The larger tau, the more data is needed to move the
+// parameters away from the prior. Only the means are adapted unless the
+// AdaptVariances or AdaptWeights options are set. The model must have the same
+// dimension and number of components as ubm. Use MAPModel to create a model
+// initialized with the parameters of the UBM.
+func MAP(ubm *Model, tau float64) Option {
+	return func(gmm *Model) {
+		gmm.prior = ubm
+		gmm.tau = tau
+	}
+}
+
+// AdaptVariances is an option to adapt the variances when using MAP estimation.
+// Default is false.
+func AdaptVariances(flag bool) Option {
+	return func(gmm *Model) { gmm.adaptVar = flag }
+}
+
+// AdaptWeights is an option to adapt the mixture weights when using MAP estimation.
+// Default is false.
+func AdaptWeights(flag bool) Option {
+	return func(gmm *Model) { gmm.adaptWeights = flag }
+}
+
+// MAPModel creates a copy of ubm that uses ubm as the prior for MAP estimation.
+// Call Update with the adaptation data and Estimate to get the adapted model.
+// The options are applied after the MAP option.
+func MAPModel(ubm *Model, tau float64, options ...Option) *Model {
+
+	cs := make([]*gaussian.Model, ubm.NComponents)
+	for i, c := range ubm.Components {
+		cs[i] = copyGaussian(c)
+	}
+	w := make([]float64, ubm.NComponents)
+	copy(w, ubm.Weights)
+	opts := []Option{Name(ubm.ModelName), Components(cs), Weights(w), MAP(ubm, tau)}
+	gmm := NewModel(ubm.ModelDim, ubm.NComponents, append(opts, options...)...)
+	gmm.Diag = ubm.Diag
+	gmm.maxApprox = ubm.maxApprox
+	return gmm
+}
+
+// Estimates the parameters using MAP adaptation.
+func (gmm *Model) estimateMAP() error {
+
+	ubm := gmm.prior
+	if ubm.ModelDim != gmm.ModelDim || ubm.NComponents != gmm.NComponents {
+		return fmt.Errorf("name:%s, prior model %s doesn't match, dim:%d/%d, num components:%d/%d",
+			gmm.ModelName, ubm.ModelName, gmm.ModelDim, ubm.ModelDim, gmm.NComponents, ubm.NComponents)
+	}
+	if gmm.tau <= 0 {
+		return fmt.Errorf("name:%s, relevance factor must be positive, got %f", gmm.ModelName, gmm.tau)
+	}
+
+	alpha := make([]float64, gmm.NComponents)
+	for k, c := range gmm.Components {
+		n := gmm.PosteriorSum[k]
+		alpha[k] = n / (n + gmm.tau)
+		p := ubm.Components[k]
+		if c.Diag != p.Diag {
+			return fmt.Errorf("name:%s, component:%s, covariance type doesn't match prior", gmm.ModelName, c.Name())
+		}
+
+		// alpha E[x] = sumx / (n + tau)
+		mean := make([]float64, gmm.ModelDim)
+		for i, m := range p.Mean {
+			mean[i] = c.Sumx[i]/(n+gmm.tau) + (1-alpha[k])*m
+		}
+
+		options := []gaussian.Option{gaussian.Clone(c), gaussian.Mean(mean)}
+		switch {
+		case !gmm.adaptVar:
+			sd := make([]float64, gmm.ModelDim)
+			copy(sd, p.StdDev)
+			options = append(options, gaussian.StdDev(sd))
+			if !p.Diag {
+				options = append(options, gaussian.Cov(floatx.CopyFloat2D(p.Cov)))
+			}
+		case p.Diag:
+			sd := make([]float64, gmm.ModelDim)
+			for i, m := range p.Mean {
+				v := c.Sumxsq[i]/(n+gmm.tau) + (1-alpha[k])*(p.StdDev[i]*p.StdDev[i]+m*m) - mean[i]*mean[i]
+				sd[i] = math.Sqrt(math.Max(v, smallVar))
+			}
+			options = append(options, gaussian.StdDev(sd))
+		default:
+			cov := floatx.MakeFloat2D(gmm.ModelDim, gmm.ModelDim)
+			for i := range cov {
+				for j := range cov[i] {
+					cov[i][j] = c.Sumxx[i][j]/(n+gmm.tau) + (1-alpha[k])*(p.Cov[i][j]+p.Mean[i]*p.Mean[j]) - mean[i]*mean[j]
+				}
+			}
+			options = append(options, gaussian.Cov(cov))
+		}
+		gmm.Components[k] = gaussian.NewModel(gmm.ModelDim, options...)
+		glog.V(4).Infof("map adaptation, name:%s, component:%s, count:%f, alpha:%f", gmm.ModelName, c.Name(), n, alpha[k])
+	}
+
+	if gmm.adaptWeights && gmm.NSamples > 0 {
+		for k := range gmm.Weights {
+			gmm.Weights[k] = alpha[k]*gmm.PosteriorSum[k]/gmm.NSamples + (1-alpha[k])*ubm.Weights[k]
+		}
+		floats.Scale(1/floats.Sum(gmm.Weights), gmm.Weights)
+	} else {
+		copy(gmm.Weights, ubm.Weights)
+	}
+	floatx.Log(gmm.LogWeights, gmm.Weights)
+	gmm.Iteration++
+	return nil
+}
+
+// MeanSupervector returns the concatenated means of the components. When
+// scaled is true, the mean of component k is scaled by sqrt(weight(k)) / sd(k)
+// using the weights and diagonal standard deviations of the model. For models
+// adapted from the same UBM (adapting only the means), half the squared euclidean
+// distance between scaled supervectors is an upper bound of the KL divergence.
+func (gmm *Model) MeanSupervector(scaled bool) []float64 {
+
+	sv := make([]float64, 0, gmm.NComponents*gmm.ModelDim)
+	for k, c := range gmm.Components {
+		if !scaled {
+			sv = append(sv, c.Mean...)
+			continue
+		}
+		w := math.Sqrt(gmm.Weights[k])
+		for i, m := range c.Mean {
+			sv = append(sv, w*m/c.StdDev[i])
+		}
+	}
+	return sv
+}
+
+// Returns a copy of g without the sufficient statistics.
+func copyGaussian(g *gaussian.Model) *gaussian.Model {
+
+	mean := make([]float64, g.ModelDim)
+	copy(mean, g.Mean)
+	sd := make([]float64, g.ModelDim)
+	copy(sd, g.StdDev)
+	options := []gaussian.Option{gaussian.Name(g.ModelName), gaussian.Mean(mean), gaussian.StdDev(sd)}
+	if !g.Diag {
+		options = append(options, gaussian.Cov(floatx.CopyFloat2D(g.Cov)))
+	}
+	return gaussian.NewModel(g.ModelDim, options...)
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gmm
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/model"
+	"github.com/akualab/gjoa/model/gaussian"
+)
+
+// Adaptation data is drawn from a Gaussian close to the first UBM component.
+func adaptData(n int) []model.Obs {
+	g := gaussian.NewModel(2, gaussian.Mean([]float64{1.2, 2.2}), gaussian.StdDev([]float64{0.3, 0.1}))
+	r := rand.New(rand.NewSource(33))
+	data := make([]model.Obs, n)
+	for i := range data {
+		data[i] = g.Sample(r)
+	}
+	return data
+}
+
+func TestMAP(t *testing.T) {
+
+	ubm := MakeGMM(t)
+	ubmMean := append([]float64{}, ubm.Components[0].Mean...)
+	tau := 16.0
+	gmm := MAPModel(ubm, tau, Name("adapted"))
+	for _, o := range adaptData(50) {
+		gmm.UpdateOne(o, 1.0)
+	}
+
+	// Expected parameters using the sufficient statistics.
+	n := gmm.PosteriorSum[0]
+	c := gmm.Components[0]
+	mean := make([]float64, 2)
+	for i := range mean {
+		mean[i] = (c.Sumx[i] + tau*ubmMean[i]) / (n + tau)
+	}
+	fatalIf(t, gmm.Estimate())
+
+	gjoa.CompareSliceFloat(t, mean, gmm.Components[0].Mean, "wrong adapted mean", 1e-9)
+	gjoa.CompareSliceFloat(t, ubm.Components[0].StdDev, gmm.Components[0].StdDev, "variance must not change", 1e-9)
+	gjoa.CompareSliceFloat(t, ubm.Weights, gmm.Weights, "weights must not change", 1e-9)
+	// Second component has almost no data.
+	gjoa.CompareSliceFloat(t, ubm.Components[1].Mean, gmm.Components[1].Mean, "wrong adapted mean", 0.01)
+	// UBM must not change.
+	gjoa.CompareSliceFloat(t, ubmMean, ubm.Components[0].Mean, "ubm was modified", 1e-12)
+	if gmm.Name() != "adapted" {
+		t.Fatalf("wrong name, expected adapted, got %s", gmm.Name())
+	}
+
+	// With a lot of data, the adapted mean is close to the ML estimate.
+	gmm = MAPModel(ubm, tau)
+	for _, o := range adaptData(20000) {
+		gmm.UpdateOne(o, 1.0)
+	}
+	fatalIf(t, gmm.Estimate())
+	gjoa.CompareSliceFloat(t, []float64{1.2, 2.2}, gmm.Components[0].Mean, "wrong adapted mean", 0.02)
+}
+
+func TestMAPVariancesWeights(t *testing.T) {
+
+	ubm := MakeGMM(t)
+	tau := 10.0
+	gmm := MAPModel(ubm, tau, AdaptVariances(true), AdaptWeights(true))
+	for _, o := range adaptData(100) {
+		gmm.UpdateOne(o, 1.0)
+	}
+
+	// Expected parameters using the sufficient statistics.
+	sd := make([][]float64, 2)
+	w := make([]float64, 2)
+	var sum float64
+	for k, c := range gmm.Components {
+		n := gmm.PosteriorSum[k]
+		alpha := n / (n + tau)
+		p := ubm.Components[k]
+		sd[k] = make([]float64, 2)
+		for i, m := range p.Mean {
+			mean := (c.Sumx[i] + tau*m) / (n + tau)
+			v := c.Sumxsq[i]/(n+tau) + (1-alpha)*(p.StdDev[i]*p.StdDev[i]+m*m) - mean*mean
+			sd[k][i] = math.Sqrt(v)
+		}
+		w[k] = alpha*n/gmm.NSamples + (1-alpha)*ubm.Weights[k]
+		sum += w[k]
+	}
+	fatalIf(t, gmm.Estimate())
+
+	for k, c := range gmm.Components {
+		gjoa.CompareSliceFloat(t, sd[k], c.StdDev, "wrong adapted sd", 1e-9)
+		gjoa.CompareFloats(t, w[k]/sum, gmm.Weights[k], "wrong adapted weight", 1e-9)
+	}
+	// The first component gets most of the data.
+	if gmm.Weights[0] <= ubm.Weights[0] {
+		t.Fatalf("weight of first component must increase, got %f", gmm.Weights[0])
+	}
+	if gmm.Components[0].StdDev[1] >= ubm.Components[0].StdDev[1] {
+		t.Fatalf("sd of first component must decrease, got %f", gmm.Components[0].StdDev[1])
+	}
+}
+
+func TestMAPFullCov(t *testing.T) {
+
+	cov := [][]float64{{0.5, 0.1}, {0.1, 0.2}}
+	g := gaussian.NewModel(2, gaussian.Mean([]float64{1, 2}), gaussian.Cov(cov))
+	ubm := NewModel(2, 1, Components([]*gaussian.Model{g}))
+
+	// Estimate a full covariance using a lot of data.
+	r := rand.New(rand.NewSource(33))
+	g0 := gaussian.NewModel(2, gaussian.Mean([]float64{1.5, 2.5}), gaussian.Cov([][]float64{{0.3, -0.1}, {-0.1, 0.4}}))
+	gmm := MAPModel(ubm, 1, AdaptVariances(true))
+	for i := 0; i < 20000; i++ {
+		gmm.UpdateOne(g0.Sample(r), 1.0)
+	}
+	fatalIf(t, gmm.Estimate())
+	gjoa.CompareSliceFloat(t, g0.Mean, gmm.Components[0].Mean, "wrong adapted mean", 0.02)
+	for i := range g0.Cov {
+		gjoa.CompareSliceFloat(t, g0.Cov[i], gmm.Components[0].Cov[i], "wrong adapted cov", 0.02)
+	}
+}
+
+func TestMAPErrors(t *testing.T) {
+
+	ubm := MakeGMM(t)
+	gmm := NewModel(2, 3, MAP(ubm, 16))
+	if err := gmm.Estimate(); err == nil {
+		t.Fatalf("expected error when num components doesn't match prior")
+	}
+	gmm = MAPModel(ubm, 0)
+	if err := gmm.Estimate(); err == nil {
+		t.Fatalf("expected error when relevance factor is zero")
+	}
+}
+
+func TestMeanSupervector(t *testing.T) {
+
+	gmm := MakeGMM(t)
+	sv := gmm.MeanSupervector(false)
+	gjoa.CompareSliceFloat(t, []float64{1, 2, 4, 4}, sv, "wrong supervector", 1e-12)
+
+	sv = gmm.MeanSupervector(true)
+	w0, w1 := math.Sqrt(0.6), math.Sqrt(0.4)
+	expected := []float64{w0 * 1 / 0.3, w0 * 2 / 0.3, w1 * 4, w1 * 4}
+	gjoa.CompareSliceFloat(t, expected, sv, "wrong scaled supervector", 1e-9)
+}
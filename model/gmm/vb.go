@@ -0,0 +1,545 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gmm
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/akualab/gjoa/floatx"
+	"github.com/akualab/gjoa/model"
+	"github.com/akualab/gjoa/model/gaussian"
+	"github.com/golang/glog"
+	"github.com/gonum/floats"
+)
+
+const (
+	defaultVBAlpha       = 0.001
+	defaultVBPruneWeight = 0.001
+)
+
+/*
+  Variational Bayesian GMM. See:
+
+  C. M. Bishop, "Pattern Recognition and Machine Learning," Springer, 2006.
+  Section 10.2.
+
+  The weights have a symmetric Dirichlet prior with concentration alpha0. Each
+  component has a Normal-Wishart prior on the mean and precision matrix L:
+
+   L    ~ Wishart(W0, nu0)
+   mean ~ N(m0, inv(beta0 L))
+
+  The posterior q(weights) q(mean, L) has the same form. Given the counts n(k),
+  the weighted means xbar(k), and the weighted scatter matrices n(k) S(k) computed
+  using the responsibilities of the E-step:
+
+   alpha(k)  = alpha0 + n(k)
+   beta(k)   = beta0 + n(k)
+   nu(k)     = nu0 + n(k)
+   m(k)      = (beta0 m0 + n(k) xbar(k)) / beta(k)
+   inv(W(k)) = inv(W0) + n(k) S(k) + beta0 n(k) / beta(k) (xbar(k) - m0)(xbar(k) - m0)'
+
+  The responsibilities use the expected log weights and log likelihoods:
+
+   log rho(n,k) = E[log w(k)] + E[log|L(k)|]/2 - D/2 log(2 pi)
+                  - D/(2 beta(k)) - nu(k)/2 (x(n) - m(k))' W(k) (x(n) - m(k))
+
+  The evidence lower bound (ELBO) is computed after each M-step using
+  Bishop (10.70-10.77). It never decreases unless components are pruned. With a
+  small alpha0, the posterior weights of the components that are not needed go
+  to zero. Components whose expected weight is below a threshold are removed.
+
+  The model parameters are the posterior expectations: the weights are
+  alpha(k) / sum(alpha), the means are m(k), and the covariance matrices are
+  inv(W(k)) / nu(k). For diagonal components, the Wishart distribution is replaced
+  by independent Gamma distributions for each dimension. (A one-dimensional Wishart.)
+
+*/
+
+// VBPrior holds the parameters of the priors used for variational Bayes training.
+type VBPrior struct {
+	// Concentration of the symmetric Dirichlet prior of the weights. Use a
+	// small value to prune components.
+	Alpha float64
+	// Pseudo-count of the prior mean.
+	Beta float64
+	// Degrees of freedom of the Wishart prior. Must be greater than dim-1
+	// for full covariance components and greater than zero for diagonal
+	// components.
+	Nu float64
+	// Prior mean.
+	Mean []float64
+	// Expected covariance matrix under the prior: inv(W0) = Nu Cov. Diagonal
+	// components use the diagonal.
+	Cov [][]float64
+}
+
+// VariationalBayes is an option to train the model using variational Bayes
+// with the priors in p. Use VBModel to create a model using priors estimated
+// from the data. The first E-step uses the initial model parameters.
+func VariationalBayes(p *VBPrior) Option {
+	return func(gmm *Model) {
+		gmm.vb = &vbState{prior: p}
+	}
+}
+
+// PruneWeight is an option to set the weight threshold used to remove
+// components when using variational Bayes. Use zero to keep all the components.
+// Default is 0.001.
+func PruneWeight(w float64) Option {
+	return func(gmm *Model) {
+		gmm.pruneWeight = w
+	}
+}
+
+// VBAlpha is an option to set the concentration of the Dirichlet prior of the
+// weights when using variational Bayes. Overrides the value in the prior. Use it
+// with VBModel to change the default concentration.
+func VBAlpha(alpha float64) Option {
+	return func(gmm *Model) {
+		gmm.vbAlpha = alpha
+	}
+}
+
+// DefaultVBPrior returns priors estimated using the observations in x. The
+// prior mean is the mean of the data, the concentration is 0.001, the mean
+// pseudo-count is one, and the degrees of freedom are dim. The prior covariance
+// is the covariance of the data divided by dim so that inv(W0) is the covariance
+// of the data. The weight of the prior covariance is small.
+func DefaultVBPrior(x model.Observer) (*VBPrior, error) {
+
+	c, err := x.ObsChan()
+	if err != nil {
+		return nil, err
+	}
+	var g *gaussian.Model
+	for o := range c {
+		if g == nil {
+			g = gaussian.NewModel(len(o.Value().([]float64)), gaussian.Diag(false))
+		}
+		g.UpdateOne(o, 1.0)
+	}
+	if err := model.StreamErr(x); err != nil {
+		return nil, err
+	}
+	if g == nil {
+		return nil, fmt.Errorf("no observations found to estimate prior")
+	}
+	err = g.Estimate()
+	if err != nil {
+		return nil, err
+	}
+	nu := float64(g.ModelDim)
+	cov := floatx.MakeFloat2D(g.ModelDim, g.ModelDim)
+	for i := range cov {
+		floats.AddScaled(cov[i], 1/nu, g.Cov[i])
+	}
+	return &VBPrior{
+		Alpha: defaultVBAlpha,
+		Beta:  1,
+		Nu:    nu,
+		Mean:  g.Mean,
+		Cov:   cov,
+	}, nil
+}
+
+// VBModel creates a model for variational Bayes training. The priors are
+// estimated using DefaultVBPrior and the model is initialized using KMeansModel.
+// The observations are read once and kept in memory, so x can be a stream that
+// can't be read twice. Use a generous number of components. The components that
+// are not needed are removed during training. The options are applied after the
+// VariationalBayes option.
+func VBModel(x model.Observer, numComponents int, seed int64, options ...Option) (*Model, error) {
+
+	data, err := readVectors(x)
+	if err != nil {
+		return nil, err
+	}
+	mem, err := model.NewFloatObserver(data, make([]model.SimpleLabel, len(data)))
+	if err != nil {
+		return nil, err
+	}
+	p, err := DefaultVBPrior(mem)
+	if err != nil {
+		return nil, err
+	}
+	return KMeansModel(mem, numComponents, seed, append([]Option{VariationalBayes(p)}, options...)...)
+}
+
+// Reads all the vectors from the observer. Stops the producer if an
+// observation is not of type []float64.
+func readVectors(x model.Observer) ([][]float64, error) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := model.ObsChanContext(ctx, x)
+	if err != nil {
+		return nil, err
+	}
+	var data [][]float64
+	for o := range c {
+		v, ok := o.Value().([]float64)
+		if !ok {
+			return nil, fmt.Errorf("oid:%s, observation value must be of type []float64", o.ID())
+		}
+		data = append(data, v)
+	}
+	if err := model.StreamErr(x); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ELBO returns the evidence lower bound computed by the last call to Estimate
+// when using variational Bayes. Returns zero otherwise.
+func (gmm *Model) ELBO() float64 {
+	if gmm.vb == nil {
+		return 0
+	}
+	return gmm.vb.elbo
+}
+
+// Prune removes the components whose weight is less than minWeight and
+// renormalizes the weights. At least one component is kept. Returns the
+// number of components removed. The sufficient statistics are not modified.
+func (gmm *Model) Prune(minWeight float64) int {
+
+	keep := make([]int, 0, gmm.NComponents)
+	for k, w := range gmm.Weights {
+		if w >= minWeight {
+			keep = append(keep, k)
+		}
+	}
+	if len(keep) == 0 {
+		keep = append(keep, floats.MaxIdx(gmm.Weights))
+	}
+	n := gmm.NComponents - len(keep)
+	if n == 0 {
+		return 0
+	}
+	cs := make([]*gaussian.Model, len(keep))
+	w := make([]float64, len(keep))
+	ps := make([]float64, len(keep))
+	var vcs []*vbComponent
+	for i, k := range keep {
+		cs[i] = gmm.Components[k]
+		w[i] = gmm.Weights[k]
+		ps[i] = gmm.PosteriorSum[k]
+		if gmm.vb != nil && len(gmm.vb.comps) == gmm.NComponents {
+			vcs = append(vcs, gmm.vb.comps[k])
+		}
+	}
+	for k, c := range gmm.Components {
+		if w := gmm.Weights[k]; w < minWeight {
+			glog.V(2).Infof("prune component, name:%s, component:%s, weight:%e", gmm.ModelName, c.Name(), w)
+		}
+	}
+	floats.Scale(1/floats.Sum(w), w)
+	gmm.Components = cs
+	gmm.Weights = w
+	gmm.LogWeights = make([]float64, len(w))
+	floatx.Log(gmm.LogWeights, gmm.Weights)
+	gmm.PosteriorSum = ps
+	gmm.NComponents = len(cs)
+	gmm.tmpProbs = make([]float64, gmm.NComponents)
+	if gmm.vb != nil {
+		gmm.vb.comps = vcs
+	}
+	return n
+}
+
+// vbState holds the variational posterior and the training statistics.
+type vbState struct {
+	prior   *VBPrior
+	comps   []*vbComponent
+	entropy float64 // sum r log r
+	elbo    float64
+}
+
+// vbComponent holds the posterior parameters of a component. For diagonal
+// components, only the diagonal of winv and w is used.
+type vbComponent struct {
+	diag    bool
+	alpha   float64
+	beta    float64
+	nu      float64
+	m       []float64
+	winv    [][]float64
+	w       [][]float64
+	logDetW float64
+	logRho  float64 // constant part of log rho
+}
+
+// Returns true when the posterior can be used to compute the responsibilities.
+func (vb *vbState) ready(gmm *Model) bool {
+	return len(vb.comps) == gmm.NComponents
+}
+
+// Adds sum r log r to the entropy term of the ELBO where post = w r.
+func (vb *vbState) addEntropy(post []float64, w float64) {
+	for _, p := range post {
+		if p > 0 {
+			vb.entropy += p * math.Log(p/w)
+		}
+	}
+}
+
+// Updates the statistics using the expected log probabilities of the components.
+// The likelihood is not updated. Use the ELBO to monitor training.
+func (gmm *Model) updateOneVB(o model.Obs, w float64) {
+
+	x := o.Value().([]float64)
+	d := make([]float64, gmm.ModelDim)
+	for k, c := range gmm.vb.comps {
+		floats.SubTo(d, x, c.m)
+		gmm.tmpProbs[k] = c.logRho - c.nu*quad(c.w, d, c.diag)/2
+	}
+	logSum := floats.LogSumExp(gmm.tmpProbs)
+	for k, v := range gmm.tmpProbs {
+		gmm.tmpProbs[k] = w * math.Exp(v-logSum)
+	}
+	gmm.vb.addEntropy(gmm.tmpProbs, w)
+	floats.Add(gmm.PosteriorSum, gmm.tmpProbs)
+	for k, c := range gmm.Components {
+		c.UpdateOne(o, gmm.tmpProbs[k])
+	}
+	gmm.NSamples += w
+}
+
+func (p *VBPrior) check(gmm *Model) error {
+
+	dim := gmm.ModelDim
+	if len(p.Mean) != dim || len(p.Cov) != dim {
+		return fmt.Errorf("name:%s, prior dim is [%d/%d], model dim is [%d]", gmm.ModelName, len(p.Mean), len(p.Cov), dim)
+	}
+	if p.Alpha <= 0 || p.Beta <= 0 {
+		return fmt.Errorf("name:%s, prior alpha and beta must be positive, alpha:%f, beta:%f", gmm.ModelName, p.Alpha, p.Beta)
+	}
+	for _, c := range gmm.Components {
+		if q := wishartDim(dim, c.Diag); p.Nu <= float64(q-1) {
+			return fmt.Errorf("name:%s, prior degrees of freedom must be greater than %d, got %f", gmm.ModelName, q-1, p.Nu)
+		}
+	}
+	return nil
+}
+
+// Estimates the posterior, computes the ELBO, and prunes the components.
+func (gmm *Model) estimateVB() error {
+
+	vb := gmm.vb
+	p := vb.prior
+	if gmm.vbAlpha > 0 {
+		q := *p
+		q.Alpha = gmm.vbAlpha
+		p = &q
+	}
+	if err := p.check(gmm); err != nil {
+		return err
+	}
+	dim := gmm.ModelDim
+	fd := float64(dim)
+	w0inv := floatx.MakeFloat2D(dim, dim)
+	for i := range w0inv {
+		floats.AddScaled(w0inv[i], p.Nu, p.Cov[i])
+	}
+	_, logDetW0inv, err := floatx.InvertSPD(w0inv)
+	if err != nil {
+		return fmt.Errorf("name:%s, prior covariance, %s", gmm.ModelName, err)
+	}
+	_, logDetW0diag, err := invertWishart(w0inv, true)
+	if err != nil {
+		return fmt.Errorf("name:%s, prior covariance, %s", gmm.ModelName, err)
+	}
+
+	// M-step.
+	comps := make([]*vbComponent, gmm.NComponents)
+	xbar := floatx.MakeFloat2D(gmm.NComponents, dim)
+	ns := make([][][]float64, gmm.NComponents)
+	var sumAlpha float64
+	for k, c := range gmm.Components {
+		n := gmm.PosteriorSum[k]
+		vc := &vbComponent{
+			diag:  c.Diag,
+			alpha: p.Alpha + n,
+			beta:  p.Beta + n,
+			nu:    p.Nu + n,
+			m:     make([]float64, dim),
+			winv:  floatx.MakeFloat2D(dim, dim),
+		}
+		ns[k] = floatx.MakeFloat2D(dim, dim)
+		for i := range vc.m {
+			if n > 0 {
+				xbar[k][i] = c.Sumx[i] / n
+			}
+			vc.m[i] = (p.Beta*p.Mean[i] + c.Sumx[i]) / vc.beta
+		}
+		f := p.Beta * n / vc.beta
+		for i := range vc.winv {
+			for j := range vc.winv[i] {
+				if c.Diag && i != j {
+					continue
+				}
+				if n > 0 {
+					if c.Diag {
+						ns[k][i][i] = math.Max(c.Sumxsq[i]-n*xbar[k][i]*xbar[k][i], 0)
+					} else {
+						ns[k][i][j] = c.Sumxx[i][j] - n*xbar[k][i]*xbar[k][j]
+					}
+				}
+				vc.winv[i][j] = w0inv[i][j] + ns[k][i][j] + f*(xbar[k][i]-p.Mean[i])*(xbar[k][j]-p.Mean[j])
+			}
+		}
+		vc.w, vc.logDetW, err = invertWishart(vc.winv, c.Diag)
+		if err != nil {
+			return fmt.Errorf("name:%s, component:%s, %s", gmm.ModelName, c.Name(), err)
+		}
+		comps[k] = vc
+		sumAlpha += vc.alpha
+	}
+
+	// ELBO. Bishop (10.70-10.77)
+	fk := float64(gmm.NComponents)
+	elbo := floatx.Lgamma(fk*p.Alpha) - fk*floatx.Lgamma(p.Alpha) // ln C(alpha0)
+	elbo -= floatx.Lgamma(sumAlpha)                               // - ln C(alpha)
+	elbo -= vb.entropy
+	d := make([]float64, dim)
+	dig := floatx.Digamma(sumAlpha)
+	for k, vc := range comps {
+		n := gmm.PosteriorSum[k]
+		q := wishartDim(dim, vc.diag)
+		eLogPi := floatx.Digamma(vc.alpha) - dig
+		eLogDet := wishartELogDet(vc.logDetW, vc.nu, dim, q)
+		logDetW0 := -logDetW0inv
+		if vc.diag {
+			logDetW0 = logDetW0diag
+		}
+
+		// E[ln p(X|Z,mean,L)] + E[ln p(Z|w)]
+		floats.SubTo(d, xbar[k], vc.m)
+		elbo += n * eLogPi
+		elbo += (n*(eLogDet-fd/vc.beta-fd*math.Log(2*math.Pi)) - vc.nu*trace(ns[k], vc.w) - n*vc.nu*quad(vc.w, d, vc.diag)) / 2
+
+		// E[ln p(w)] - E[ln q(w)]
+		elbo += (p.Alpha-1)*eLogPi - (vc.alpha-1)*eLogPi + floatx.Lgamma(vc.alpha)
+
+		// E[ln p(mean,L)]
+		floats.SubTo(d, vc.m, p.Mean)
+		elbo += (fd*math.Log(p.Beta/(2*math.Pi)) + eLogDet - fd*p.Beta/vc.beta - p.Beta*vc.nu*quad(vc.w, d, vc.diag)) / 2
+		elbo += wishartLogB(logDetW0, p.Nu, dim, q) + (p.Nu-float64(q)-1)/2*eLogDet - vc.nu*trace(w0inv, vc.w)/2
+
+		// - E[ln q(mean,L)]
+		h := -wishartLogB(vc.logDetW, vc.nu, dim, q) - (vc.nu-float64(q)-1)/2*eLogDet + vc.nu*fd/2
+		elbo -= eLogDet/2 + fd/2*math.Log(vc.beta/(2*math.Pi)) - fd/2 - h
+
+		vc.logRho = eLogPi + eLogDet/2 - fd/2*math.Log(2*math.Pi) - fd/(2*vc.beta)
+	}
+	vb.elbo = elbo
+	vb.comps = comps
+
+	// Set the model parameters using the expected values.
+	for k, vc := range comps {
+		c := gmm.Components[k]
+		gmm.Weights[k] = vc.alpha / sumAlpha
+		sd := make([]float64, dim)
+		for i := range sd {
+			sd[i] = math.Sqrt(math.Max(vc.winv[i][i]/vc.nu, smallVar))
+		}
+		mean := make([]float64, dim)
+		copy(mean, vc.m)
+		options := []gaussian.Option{gaussian.Clone(c), gaussian.Mean(mean), gaussian.StdDev(sd)}
+		if !c.Diag {
+			cov := floatx.MakeFloat2D(dim, dim)
+			for i := range cov {
+				floats.AddScaled(cov[i], 1/vc.nu, vc.winv[i])
+			}
+			options = append(options, gaussian.Cov(cov))
+		}
+		gmm.Components[k] = gaussian.NewModel(dim, options...)
+	}
+	floatx.Log(gmm.LogWeights, gmm.Weights)
+	gmm.Iteration++
+	glog.Infof("vb, name:%s, iteration:%d, elbo:%f, num components:%d", gmm.ModelName, gmm.Iteration, elbo, gmm.NComponents)
+
+	if gmm.pruneWeight > 0 {
+		if n := gmm.Prune(gmm.pruneWeight); n > 0 {
+			glog.Infof("vb, name:%s, pruned %d components, num components:%d", gmm.ModelName, n, gmm.NComponents)
+		}
+	}
+	return nil
+}
+
+// Returns the dimension of the Wishart distributions of a component. Diagonal
+// components use a one-dimensional Wishart distribution for each dimension.
+func wishartDim(dim int, diag bool) int {
+	if diag {
+		return 1
+	}
+	return dim
+}
+
+// Returns E[ln|L|] for a product of dim/q Wishart distributions of dimension q.
+func wishartELogDet(logDetW, nu float64, dim, q int) float64 {
+	var v float64
+	for i := 1; i <= q; i++ {
+		v += floatx.Digamma((nu + 1 - float64(i)) / 2)
+	}
+	return float64(dim/q)*v + float64(dim)*math.Ln2 + logDetW
+}
+
+// Returns the log of the normalization constant B(W, nu) for a product of
+// dim/q Wishart distributions of dimension q. Bishop (B.79)
+func wishartLogB(logDetW, nu float64, dim, q int) float64 {
+	v := float64(q*(q-1)) / 4 * math.Log(math.Pi)
+	for i := 1; i <= q; i++ {
+		v += floatx.Lgamma((nu + 1 - float64(i)) / 2)
+	}
+	return -nu/2*logDetW - nu*float64(dim)/2*math.Ln2 - float64(dim/q)*v
+}
+
+// Returns the inverse and the log determinant of the inverse. When diag is true,
+// only the diagonal is used.
+func invertWishart(winv [][]float64, diag bool) ([][]float64, float64, error) {
+
+	if !diag {
+		w, logDet, err := floatx.InvertSPD(winv)
+		return w, -logDet, err
+	}
+	n := len(winv)
+	w := floatx.MakeFloat2D(n, n)
+	var logDet float64
+	for i := range w {
+		if winv[i][i] <= 0 {
+			return nil, 0, fmt.Errorf("matrix is not positive definite, element [%d] is %e", i, winv[i][i])
+		}
+		w[i][i] = 1 / winv[i][i]
+		logDet += math.Log(w[i][i])
+	}
+	return w, logDet, nil
+}
+
+// Returns d' w d.
+func quad(w [][]float64, d []float64, diag bool) float64 {
+	var v float64
+	for i, di := range d {
+		if diag {
+			v += w[i][i] * di * di
+			continue
+		}
+		v += di * floats.Dot(w[i], d)
+	}
+	return v
+}
+
+// Returns Tr(a w) for symmetric matrices.
+func trace(a, w [][]float64) float64 {
+	var v float64
+	for i := range a {
+		v += floats.Dot(a[i], w[i])
+	}
+	return v
+}
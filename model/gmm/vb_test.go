@@ -0,0 +1,269 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gmm
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/floatx"
+	"github.com/akualab/gjoa/model"
+	"github.com/akualab/gjoa/model/gaussian"
+)
+
+// Returns an observer with samples drawn from gmm.
+func sampleObserver(t *testing.T, gmm *Model, n int) model.Observer {
+	r := rand.New(rand.NewSource(33))
+	values := make([][]float64, n)
+	for i := range values {
+		values[i] = gmm.Sample(r).Value().([]float64)
+	}
+	fo, err := model.NewFloatObserver(values, make([]model.SimpleLabel, n))
+	fatalIf(t, err)
+	return fo
+}
+
+// Runs VB iterations. The ELBO must not decrease unless components are pruned.
+func trainVB(t *testing.T, gmm *Model, x model.Observer, numIter int) {
+	prev := math.Inf(-1)
+	numComp := gmm.NComponents
+	for i := 0; i < numIter; i++ {
+		gmm.Clear()
+		fatalIf(t, gmm.Update(x, model.NoWeight))
+		fatalIf(t, gmm.Estimate())
+		elbo := gmm.ELBO()
+		if elbo < prev-1e-6*math.Abs(prev) {
+			t.Fatalf("iter:%d, elbo decreased from %f to %f", i, prev, elbo)
+		}
+		prev = elbo
+		if gmm.NComponents != numComp {
+			// The model changed.
+			prev = math.Inf(-1)
+			numComp = gmm.NComponents
+		}
+	}
+}
+
+// Sorts the components by the first element of the mean.
+type byMean []*gaussian.Model
+
+func (s byMean) Len() int           { return len(s) }
+func (s byMean) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byMean) Less(i, j int) bool { return s[i].Mean[0] < s[j].Mean[0] }
+
+// Returns a GMM with three well separated components.
+func makeGMM3() *Model {
+	sd := []float64{0.5, 0.5}
+	cs := []*gaussian.Model{
+		gaussian.NewModel(2, gaussian.Name("g0"), gaussian.Mean([]float64{0, 0}), gaussian.StdDev(sd)),
+		gaussian.NewModel(2, gaussian.Name("g1"), gaussian.Mean([]float64{5, 5}), gaussian.StdDev(sd)),
+		gaussian.NewModel(2, gaussian.Name("g2"), gaussian.Mean([]float64{10, 0}), gaussian.StdDev(sd)),
+	}
+	return NewModel(2, 3, Components(cs), Weights([]float64{0.3, 0.3, 0.4}))
+}
+
+func TestVB(t *testing.T) {
+
+	gmm0 := makeGMM3()
+	x := sampleObserver(t, gmm0, 5000)
+	gmm, err := VBModel(x, 6, 99, Name("vb"))
+	fatalIf(t, err)
+	trainVB(t, gmm, x, 150)
+
+	if gmm.NComponents != 3 {
+		t.Fatalf("expected 3 components after pruning, got %d", gmm.NComponents)
+	}
+	if len(gmm.PosteriorSum) != 3 || len(gmm.LogWeights) != 3 {
+		t.Fatalf("wrong number of elements after pruning")
+	}
+	weights := make(map[*gaussian.Model]float64)
+	for k, c := range gmm.Components {
+		weights[c] = gmm.Weights[k]
+	}
+	sort.Sort(byMean(gmm.Components))
+	for k, c := range gmm.Components {
+		CompareGaussians(t, gmm0.Components[k], c, 0.05)
+		gjoa.CompareFloats(t, gmm0.Weights[k], weights[c], "wrong weight", 0.05)
+	}
+}
+
+func TestVBFullCov(t *testing.T) {
+
+	cov0 := [][]float64{{0.5, 0.3}, {0.3, 0.4}}
+	cov1 := [][]float64{{0.3, -0.2}, {-0.2, 0.6}}
+	g0 := gaussian.NewModel(2, gaussian.Mean([]float64{0, 0}), gaussian.Cov(cov0))
+	g1 := gaussian.NewModel(2, gaussian.Mean([]float64{4, 2}), gaussian.Cov(cov1))
+	gmm0 := NewModel(2, 2, Components([]*gaussian.Model{g0, g1}), Weights([]float64{0.3, 0.7}))
+	x := sampleObserver(t, gmm0, 5000)
+
+	// Start with full covariance components. The third component has no data.
+	p, err := DefaultVBPrior(x)
+	fatalIf(t, err)
+	var cs []*gaussian.Model
+	for _, mean := range [][]float64{{-1, 0}, {5, 2}, {10, 10}} {
+		cs = append(cs, gaussian.NewModel(2, gaussian.Mean(mean), gaussian.Cov([][]float64{{1, 0}, {0, 1}})))
+	}
+	gmm := NewModel(2, 3, Components(cs), VariationalBayes(p))
+	trainVB(t, gmm, x, 50)
+
+	if gmm.NComponents != 2 {
+		t.Fatalf("expected 2 components after pruning, got %d", gmm.NComponents)
+	}
+	sort.Sort(byMean(gmm.Components))
+	for k, g := range []*gaussian.Model{g0, g1} {
+		c := gmm.Components[k]
+		gjoa.CompareSliceFloat(t, g.Mean, c.Mean, "wrong mean", 0.05)
+		for i := range g.Cov {
+			gjoa.CompareSliceFloat(t, g.Cov[i], c.Cov[i], "wrong cov", 0.05)
+		}
+	}
+}
+
+// With one component, the posterior is exact and the ELBO is the log evidence.
+// Murphy, "Conjugate Bayesian analysis of the Gaussian distribution," (2007), Eq. 266.
+func TestELBO(t *testing.T) {
+
+	cov := [][]float64{{0.5, 0.3}, {0.3, 0.4}}
+	g := gaussian.NewModel(2, gaussian.Mean([]float64{1, 2}), gaussian.Cov(cov))
+	gmm0 := NewModel(2, 1, Components([]*gaussian.Model{g}))
+	n := 100
+	x := sampleObserver(t, gmm0, n)
+	p := &VBPrior{Alpha: 0.5, Beta: 2, Nu: 3, Mean: []float64{0, 1}, Cov: [][]float64{{1, 0.2}, {0.2, 2}}}
+	c := gaussian.NewModel(2, gaussian.Cov([][]float64{{1, 0}, {0, 1}}))
+	gmm := NewModel(2, 1, Components([]*gaussian.Model{c}), VariationalBayes(p))
+	trainVB(t, gmm, x, 2)
+
+	// Log determinant of the scale matrices inv(W).
+	logDet := func(a [][]float64) float64 { return math.Log(a[0][0]*a[1][1] - a[0][1]*a[1][0]) }
+	// Log of the multivariate gamma function.
+	lgamma2 := func(a float64) float64 { return math.Log(math.Pi)/2 + floatx.Lgamma(a) + floatx.Lgamma(a-0.5) }
+	w0inv := [][]float64{{p.Nu * p.Cov[0][0], p.Nu * p.Cov[0][1]}, {p.Nu * p.Cov[1][0], p.Nu * p.Cov[1][1]}}
+	vc := gmm.vb.comps[0]
+	nu := p.Nu + float64(n)
+	expected := -float64(n)*math.Log(math.Pi) + lgamma2(nu/2) - lgamma2(p.Nu/2) +
+		p.Nu/2*logDet(w0inv) - nu/2*logDet(vc.winv) + math.Log(p.Beta/vc.beta)
+	gjoa.CompareFloats(t, expected, gmm.ELBO(), "wrong elbo", 1e-9)
+	if gmm.Likelihood != 0 {
+		t.Fatalf("likelihood must not be updated by vb, got %f", gmm.Likelihood)
+	}
+}
+
+func TestVBNoPruning(t *testing.T) {
+
+	gmm0 := MakeGMM(t)
+	x := sampleObserver(t, gmm0, 1000)
+	gmm, err := VBModel(x, 4, 99, PruneWeight(0))
+	fatalIf(t, err)
+	trainVB(t, gmm, x, 30)
+	if gmm.NComponents != 4 {
+		t.Fatalf("expected 4 components, got %d", gmm.NComponents)
+	}
+}
+
+// onceObserver streams the observations of x the first time ObsChan is called.
+// Later calls return an empty stream.
+type onceObserver struct {
+	x    model.Observer
+	done bool
+}
+
+func (o *onceObserver) ObsChan() (<-chan model.Obs, error) {
+	if o.done {
+		c := make(chan model.Obs)
+		close(c)
+		return c, nil
+	}
+	o.done = true
+	return o.x.ObsChan()
+}
+
+// VBModel must read the observations once.
+func TestVBModelOneShot(t *testing.T) {
+
+	x := sampleObserver(t, MakeGMM(t), 500)
+	gmm, err := VBModel(&onceObserver{x: x}, 4, 99, PruneWeight(0))
+	fatalIf(t, err)
+	gmm1, err := VBModel(x, 4, 99, PruneWeight(0))
+	fatalIf(t, err)
+	for k, c := range gmm.Components {
+		gjoa.CompareSliceFloat(t, gmm1.Components[k].Mean, c.Mean, "wrong mean", 1e-12)
+	}
+	gjoa.CompareSliceFloat(t, gmm1.vb.prior.Mean, gmm.vb.prior.Mean, "wrong prior mean", 1e-12)
+}
+
+func TestPruneWeightOption(t *testing.T) {
+
+	p := &VBPrior{Alpha: 1, Beta: 1, Nu: 3, Mean: []float64{0, 0}, Cov: [][]float64{{1, 0}, {0, 1}}}
+	for _, options := range [][]Option{
+		{PruneWeight(0.2), VariationalBayes(p)},
+		{VariationalBayes(p), PruneWeight(0.2)},
+	} {
+		gmm := NewModel(2, 2, options...)
+		if gmm.pruneWeight != 0.2 {
+			t.Fatalf("wrong prune weight, expected 0.2, got %f", gmm.pruneWeight)
+		}
+	}
+	if gmm := NewModel(2, 2, VariationalBayes(p)); gmm.pruneWeight != defaultVBPruneWeight {
+		t.Fatalf("wrong default prune weight, got %f", gmm.pruneWeight)
+	}
+}
+
+// The concentration set with VBAlpha overrides the prior.
+func TestVBAlpha(t *testing.T) {
+
+	gmm0 := MakeGMM(t)
+	x := sampleObserver(t, gmm0, 500)
+	gmm, err := VBModel(x, 4, 99, PruneWeight(0), VBAlpha(100))
+	fatalIf(t, err)
+	trainVB(t, gmm, x, 1)
+	if gmm.vb.prior.Alpha != defaultVBAlpha {
+		t.Fatalf("prior was modified, alpha:%f", gmm.vb.prior.Alpha)
+	}
+	for k, vc := range gmm.vb.comps {
+		gjoa.CompareFloats(t, 100+gmm.PosteriorSum[k], vc.alpha, "wrong posterior alpha", 1e-9)
+	}
+}
+
+func TestVBErrors(t *testing.T) {
+
+	gmm := MakeGMM(t)
+	p := &VBPrior{Alpha: 1, Beta: 1, Nu: 3, Mean: []float64{0}, Cov: [][]float64{{1}}}
+	VariationalBayes(p)(gmm)
+	if err := gmm.Estimate(); err == nil {
+		t.Fatalf("expected error, prior dim doesn't match")
+	}
+	p.Mean, p.Cov = []float64{0, 0}, [][]float64{{1, 0}, {0, 1}}
+	p.Nu = 0
+	if err := gmm.Estimate(); err == nil {
+		t.Fatalf("expected error, degrees of freedom must be positive")
+	}
+	p.Nu = 3
+	p.Cov[1][1] = -1
+	if err := gmm.Estimate(); err == nil {
+		t.Fatalf("expected error, prior covariance is not positive definite")
+	}
+}
+
+func TestPrune(t *testing.T) {
+
+	gmm := MakeGMM(t)
+	if n := gmm.Prune(0.5); n != 1 {
+		t.Fatalf("expected 1 pruned component, got %d", n)
+	}
+	if gmm.NComponents != 1 || gmm.Components[0].Name() != "g0" {
+		t.Fatalf("wrong components after pruning")
+	}
+	gjoa.CompareSliceFloat(t, []float64{1}, gmm.Weights, "wrong weights", 1e-12)
+	gjoa.CompareSliceFloat(t, []float64{0}, gmm.LogWeights, "wrong log weights", 1e-12)
+
+	// Keep at least one component.
+	if n := gmm.Prune(2); n != 0 {
+		t.Fatalf("expected no pruned components, got %d", n)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hmm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akualab/gjoa/model"
+	"github.com/golang/glog"
+)
+
+// Align computes the forced alignment for an observation sequence of type
+// model.FloatObsSequence. The chain of nets is created from the labels of the
+// observation using the assigner. Uses a DirectAssigner if assigner is nil.
+// The alignment is returned as a slice of trees, one per label. Each label node
+// has the net nodes assigned to the label as children, and each net node has
+// state nodes as children. Labels whose nets don't consume any observations in
+// the best path (using skip transitions) have no node. To group the nets by
+// label, the assigner must implement the LabelAssigner interface or assign
+// exactly one model to each label.
+func (ms *Set) Align(obs model.Obs, assigner Assigner) ([]*model.ANode, error) {
+
+	if assigner == nil {
+		assigner = DirectAssigner{}
+	}
+	path, err := ms.Viterbi(obs, assigner)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the chain positions that correspond to each label.
+	labels := strings.Split(obs.Label().String(), ",")
+	groups, err := assignLabels(assigner, labels)
+	if err != nil {
+		return nil, fmt.Errorf("oid:%s, %s", obs.ID(), err)
+	}
+	last := make([]int, len(labels)) // last chain position for each label (exclusive)
+	pos := 0
+	for k, label := range labels {
+		n := len(groups[k])
+		if n == 0 {
+			return nil, fmt.Errorf("oid:%s, no models assigned to label [%s]", obs.ID(), label)
+		}
+		pos += n
+		last[k] = pos
+	}
+
+	// Create label nodes and move net nodes under their label.
+	var nodes []*model.ANode
+	start, i := 0, 0
+	for k, label := range labels {
+		node := model.NewANode(start, start, label, nil)
+		for ; i < len(path.Alignment) && path.positions[i] < last[k]; i++ {
+			net := path.Alignment[i]
+			node.End = net.End
+			child := node.AppendChild(net.End, net.Name)
+			child.Children = net.Children
+		}
+		if len(node.Children) == 0 {
+			glog.V(2).Infof("oid:%s, label [%s] has no observations in the alignment", obs.ID(), label)
+			continue
+		}
+		nodes = append(nodes, node)
+		start = node.End
+	}
+	if i < len(path.Alignment) {
+		return nil, fmt.Errorf("oid:%s, the models grouped by label don't match the chain", obs.ID())
+	}
+	return nodes, nil
+}
+
+// assignLabels assigns model names to the full label sequence and groups them
+// by label. Assigners that don't implement LabelAssigner must assign one model
+// to each label.
+func assignLabels(assigner Assigner, labels []string) ([][]string, error) {
+
+	if a, ok := assigner.(LabelAssigner); ok {
+		groups := a.AssignLabels(labels)
+		if len(groups) != len(labels) {
+			return nil, fmt.Errorf("assigner returned %d groups for %d labels", len(groups), len(labels))
+		}
+		return groups, nil
+	}
+	names := assigner.Assign(labels)
+	if len(names) != len(labels) {
+		return nil, fmt.Errorf("assigner of type %T assigned %d models to %d labels, it must implement LabelAssigner to group the models by label",
+			assigner, len(names), len(labels))
+	}
+	groups := make([][]string, len(names))
+	for k, name := range names {
+		groups[k] = []string{name}
+	}
+	return groups, nil
+}
+
+// AlignSeqs reads a stream of JSON-encoded model.Seq values from r, computes
+// the forced alignment for each sequence, and writes the sequences to w with
+// the Alignments field set. Sequences that fail to align are skipped.
+// Returns an error with the number of failed sequences once the stream is consumed.
+func (ms *Set) AlignSeqs(r io.Reader, w io.Writer, assigner Assigner) error {
+
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	var count, failCount int
+	for {
+		var v model.Seq
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		count++
+		obs := model.NewFloatObsSequence(v.Vectors, model.SimpleLabel(strings.Join(v.Labels, ",")), v.ID)
+		al, err := ms.Align(obs, assigner)
+		if err != nil {
+			failCount++
+			glog.Warningf("skipping, failed to align sequence, oid:%s, error: %s", v.ID, err)
+			continue
+		}
+		v.Alignments = al
+		err = enc.Encode(v)
+		if err != nil {
+			return err
+		}
+	}
+	glog.Infof("aligned %d sequences, failed:%d", count-failCount, failCount)
+	if failCount > 0 {
+		return fmt.Errorf("failed to align %d out of %d sequences", failCount, count)
+	}
+	return nil
+}
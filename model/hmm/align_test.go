@@ -0,0 +1,249 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hmm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/akualab/gjoa/model"
+	gm "github.com/akualab/gjoa/model/gaussian"
+	narray "github.com/akualab/narray/na64"
+)
+
+var testDict = MapAssigner{
+	"A": []string{"m0", "m1"},
+	"B": []string{"m2"},
+	"C": []string{"m3", "m4", "m0"},
+}
+
+// generates a sequence of words using the dictionary.
+func genWords(r *rand.Rand, ms *Set, id string) (*model.FloatObsSequence, []string, []string) {
+
+	words := []string{"A", "B", "C"}
+	var labels, states []string
+	var obs []*model.FloatObsSequence
+	n := r.Intn(4) + 1
+	for k := 0; k < n; k++ {
+		w := words[r.Intn(len(words))]
+		labels = append(labels, w)
+		for _, name := range testDict[w] {
+			net, _ := ms.net(name)
+			o, s := newGenerator(r, true, net).next("")
+			obs = append(obs, o)
+			states = append(states, s...)
+		}
+	}
+	fos := model.JoinFloatObsSequence(id, obs...).(*model.FloatObsSequence)
+	return fos, labels, states
+}
+
+func TestAlign(t *testing.T) {
+
+	r := rand.New(rand.NewSource(444))
+	ms := makeRandomSet(t, r, 5, 5, 4)
+
+	numErrors, n := 0, 0
+	for i := 0; i < 50; i++ {
+		fos, labels, states := genWords(r, ms, "oid-"+fi(i))
+		obs := model.NewFloatObsSequence(fos.Value().([][]float64), model.SimpleLabel(strings.Join(labels, ",")), fos.ID())
+		al, err := ms.Align(obs, testDict)
+		fatalIf(t, err)
+
+		root := model.NewANode(0, len(states), fos.ID(), nil)
+		root.Children = al
+		if !root.IsValid() {
+			t.Fatalf("invalid alignment tree: %v", root.Alignment())
+		}
+		if root.Level() != 3 {
+			t.Fatalf("expected level 3, got level %d", root.Level())
+		}
+		for k, node := range al {
+			if node.Name != labels[k] {
+				t.Fatalf("wrong label - got %s, expected %s", node.Name, labels[k])
+			}
+			for j, net := range node.Children {
+				if net.Name != testDict[labels[k]][j] {
+					t.Fatalf("wrong net - got %s, expected %s", net.Name, testDict[labels[k]][j])
+				}
+			}
+		}
+		for _, node := range leaves(al) {
+			for p := node.Start; p < node.End; p++ {
+				n++
+				if node.Name != states[p] {
+					numErrors++
+				}
+			}
+		}
+	}
+	t.Logf("num_states:%d, num_errors:%d", n, numErrors)
+	if float64(numErrors)/float64(n) > 0.02 {
+		t.Fatalf("alignment error rate is too high - num_states:%d, num_errors:%d", n, numErrors)
+	}
+}
+
+// A label whose net is skipped has no node in the alignment.
+func TestAlignSkipLabel(t *testing.T) {
+
+	r := rand.New(rand.NewSource(444))
+	ms := makeRandomSet(t, r, 5, 5, 4)
+
+	// The entry state can go to the exit state. The output PDF is far from the data.
+	a := narray.New(3, 3)
+	a.Set(0.5, 0, 1)
+	a.Set(0.5, 0, 2)
+	a.Set(0.5, 1, 1)
+	a.Set(0.5, 1, 2)
+	g := gm.NewModel(4, gm.Name("tee-1"), gm.Mean([]float64{1e4, 1e4, 1e4, 1e4}), gm.StdDev([]float64{1, 1, 1, 1}))
+	_, err := ms.NewNet("tee", narray.Log(a, a), []model.Modeler{nil, g, nil})
+	fatalIf(t, err)
+	dict := MapAssigner{"A": testDict["A"], "B": testDict["B"], "S": []string{"tee"}}
+
+	var obs []*model.FloatObsSequence
+	var states []string
+	for _, name := range []string{"m0", "m1", "m2"} {
+		net, _ := ms.net(name)
+		o, s := newGenerator(r, true, net).next("")
+		obs = append(obs, o)
+		states = append(states, s...)
+	}
+	fos := model.JoinFloatObsSequence("skip", obs...).(*model.FloatObsSequence)
+	x := model.NewFloatObsSequence(fos.Value().([][]float64), model.SimpleLabel("A,S,B"), fos.ID())
+	al, err := ms.Align(x, dict)
+	fatalIf(t, err)
+
+	if len(al) != 2 || al[0].Name != "A" || al[1].Name != "B" {
+		t.Fatalf("expected label nodes A and B, got %v", al)
+	}
+	root := model.NewANode(0, len(states), fos.ID(), nil)
+	root.Children = al
+	if !root.IsValid() || root.Level() != 3 {
+		t.Fatalf("invalid alignment tree: %v", root.Alignment())
+	}
+}
+
+// ctxAssigner assigns an extra net to label B when it follows label A.
+type ctxAssigner struct{}
+
+func (a ctxAssigner) Assign(labels []string) []string {
+	var names []string
+	for _, g := range a.AssignLabels(labels) {
+		names = append(names, g...)
+	}
+	return names
+}
+
+func (a ctxAssigner) AssignLabels(labels []string) [][]string {
+	names := testDict.AssignLabels(labels)
+	for k, label := range labels {
+		if label == "B" && k > 0 && labels[k-1] == "A" {
+			names[k] = []string{"m3", "m2"}
+		}
+	}
+	return names
+}
+
+// The nets are grouped by label using the assignment of the full label sequence.
+func TestAlignContext(t *testing.T) {
+
+	r := rand.New(rand.NewSource(444))
+	ms := makeRandomSet(t, r, 5, 5, 4)
+
+	var obs []*model.FloatObsSequence
+	for _, name := range []string{"m0", "m1", "m3", "m2"} {
+		net, _ := ms.net(name)
+		o, _ := newGenerator(r, true, net).next("")
+		obs = append(obs, o)
+	}
+	fos := model.JoinFloatObsSequence("ctx", obs...).(*model.FloatObsSequence)
+	x := model.NewFloatObsSequence(fos.Value().([][]float64), model.SimpleLabel("A,B"), fos.ID())
+	al, err := ms.Align(x, ctxAssigner{})
+	fatalIf(t, err)
+
+	if len(al) != 2 || len(al[0].Children) != 2 || len(al[1].Children) != 2 {
+		t.Fatalf("expected two nets per label, got %v", al)
+	}
+	if al[1].Children[0].Name != "m3" || al[1].Children[1].Name != "m2" {
+		t.Fatalf("wrong nets for label B - got %s,%s", al[1].Children[0].Name, al[1].Children[1].Name)
+	}
+}
+
+// Align uses a DirectAssigner when the assigner is nil.
+func TestAlignNilAssigner(t *testing.T) {
+
+	r := rand.New(rand.NewSource(444))
+	ms := makeRandomSet(t, r, 5, 5, 4)
+
+	var obs []*model.FloatObsSequence
+	for _, name := range []string{"m2", "m4"} {
+		net, _ := ms.net(name)
+		o, _ := newGenerator(r, true, net).next("")
+		obs = append(obs, o)
+	}
+	fos := model.JoinFloatObsSequence("direct", obs...).(*model.FloatObsSequence)
+	x := model.NewFloatObsSequence(fos.Value().([][]float64), model.SimpleLabel("m2,m4"), fos.ID())
+	al, err := ms.Align(x, nil)
+	fatalIf(t, err)
+	if len(al) != 2 || al[0].Name != "m2" || al[1].Name != "m4" {
+		t.Fatalf("expected label nodes m2 and m4, got %v", al)
+	}
+}
+
+func TestAlignSeqs(t *testing.T) {
+
+	r := rand.New(rand.NewSource(444))
+	ms := makeRandomSet(t, r, 5, 5, 4)
+
+	var in bytes.Buffer
+	enc := json.NewEncoder(&in)
+	n := 20
+	for i := 0; i < n; i++ {
+		fos, labels, _ := genWords(r, ms, "oid-"+fi(i))
+		err := enc.Encode(model.Seq{
+			Vectors: fos.Value().([][]float64),
+			Labels:  labels,
+			ID:      fos.ID(),
+		})
+		fatalIf(t, err)
+	}
+
+	var out bytes.Buffer
+	fatalIf(t, ms.AlignSeqs(&in, &out, testDict))
+
+	// Read aligned sequences and train from alignments.
+	hmm := NewModel(OSet(ms), OAssign(testDict), UseAlignments(true))
+	hmm.Clear()
+	dec := json.NewDecoder(&out)
+	count := 0
+	for ; ; count++ {
+		var v model.Seq
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		fatalIf(t, err)
+		root := model.NewANode(0, len(v.Vectors), v.ID, nil)
+		root.Children = v.Alignments
+		if !root.IsValid() || root.Level() != 3 {
+			t.Fatalf("invalid alignment tree: %v", root.Alignment())
+		}
+		obs := model.NewFloatObsSequence(v.Vectors, model.SimpleLabel(strings.Join(v.Labels, ",")), v.ID).(model.FloatObsSequence)
+		obs.SetAlignment(v.Alignments)
+		hmm.UpdateOne(obs, 1.0)
+	}
+	if count != n {
+		t.Fatalf("expected %d sequences, got %d", n, count)
+	}
+	if hmm.updateFailCount > 0 {
+		t.Fatalf("failed to update %d sequences using alignments", hmm.updateFailCount)
+	}
+	fatalIf(t, hmm.Estimate())
+}
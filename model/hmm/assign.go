@@ -21,7 +21,17 @@ type Assigner interface {
 	Assign(labels []string) (modelNames []string)
 }
 
-// DirectAssigner implements the Assigner interface.
+// LabelAssigner is an Assigner that also groups the model names by label.
+// Set.Align uses it to find the nets assigned to each label when the models
+// assigned to a label depend on its context.
+type LabelAssigner interface {
+	Assigner
+	// AssignLabels returns the model names assigned to each label. The
+	// concatenation of the groups must be equal to the result of Assign.
+	AssignLabels(labels []string) [][]string
+}
+
+// DirectAssigner implements the Assigner and LabelAssigner interfaces.
 // Model names correspond one-to-one to the label names.
 type DirectAssigner struct{}
 
@@ -31,7 +41,17 @@ func (a DirectAssigner) Assign(labels []string) []string {
 	return append([]string(nil), labels...)
 }
 
-// MapAssigner implements the Assigner interface.
+// AssignLabels returns the model name assigned to each label.
+func (a DirectAssigner) AssignLabels(labels []string) [][]string {
+
+	names := make([][]string, len(labels))
+	for k, label := range labels {
+		names[k] = []string{label}
+	}
+	return names
+}
+
+// MapAssigner implements the Assigner and LabelAssigner interfaces.
 // Labes are mapped using a dictionary.
 type MapAssigner map[string][]string
 
@@ -44,3 +64,13 @@ func (a MapAssigner) Assign(labels []string) []string {
 	}
 	return names
 }
+
+// AssignLabels returns the model names assigned to each label.
+func (a MapAssigner) AssignLabels(labels []string) [][]string {
+
+	names := make([][]string, len(labels))
+	for k, word := range labels {
+		names[k] = append([]string(nil), a[word]...)
+	}
+	return names
+}
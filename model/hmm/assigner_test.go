@@ -32,4 +32,12 @@ func TestAssigner(t *testing.T) {
 			t.Fatalf("map assigner failed - subword [%s] does not match expected [%s]", name, expected[i])
 		}
 	}
+	groups := b.AssignLabels(words)
+	if len(groups) != 2 || len(groups[0]) != 4 || len(groups[1]) != 4 || groups[1][0] != "W" {
+		t.Fatalf("map assigner failed - wrong groups %v", groups)
+	}
+	groups = a.AssignLabels(words)
+	if len(groups) != 2 || groups[0][0] != "HELLO" || groups[1][0] != "WORLD" {
+		t.Fatalf("direct assigner failed - wrong groups %v", groups)
+	}
 }
@@ -7,6 +7,7 @@ package hmm
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"math"
 	"math/rand"
@@ -14,9 +15,11 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/akualab/gjoa/floatx"
 	"github.com/akualab/gjoa/model"
 	"github.com/akualab/ju"
 	"github.com/golang/glog"
+	"github.com/gonum/floats"
 
 	narray "github.com/akualab/narray/na64"
 )
@@ -61,6 +64,24 @@ func (ms *Set) add(m *Net) error {
 	return nil
 }
 
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (ms *Set) UnmarshalJSON(b []byte) error {
+
+	var v struct {
+		Nets []*Net `json:"networks"`
+	}
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+	set, err := NewSet(v.Nets...)
+	if err != nil {
+		return err
+	}
+	*ms = *set
+	return nil
+}
+
 func (ms *Set) net(name string) (*Net, bool) {
 
 	// check that model for name exist
@@ -82,6 +103,83 @@ func (ms *Set) reset() {
 	}
 }
 
+// splitter is implemented by output PDFs that can increase the number of mixture components.
+type splitter interface {
+	Split(numComponents int, epsilon float64) error
+}
+
+// Split increases the number of components of every mixture output PDF in
+// the set to numComponents. Returns an error if a PDF already has numComponents
+// or more. See gmm.Model.Split for details.
+func (ms *Set) Split(numComponents int, epsilon float64) error {
+
+	done := make(map[splitter]bool) // output PDFs may be shared.
+	for _, net := range ms.Nets {
+		for i := 1; i < net.ns-1; i++ {
+			s, ok := net.B[i].(splitter)
+			if !ok || done[s] {
+				continue
+			}
+			done[s] = true
+			err := s.Split(numComponents, epsilon)
+			if err != nil {
+				return fmt.Errorf("net:%s, state:%d, %s", net.Name, i, err)
+			}
+		}
+	}
+	if len(done) == 0 {
+		return fmt.Errorf("no mixture output PDFs found in model set")
+	}
+	glog.V(1).Infof("split %d output PDFs to %d components", len(done), numComponents)
+	return nil
+}
+
+// Merge adds the accumulators of the networks in src to the networks in the
+// set with the same name. Output PDFs must implement the model.Merger interface.
+// Both sets must have the same networks and topologies. The sets are validated
+// before the accumulators are modified.
+func (ms *Set) Merge(src *Set) error {
+
+	if src.size() != ms.size() {
+		return fmt.Errorf("can't merge model sets, num networks:%d/%d", ms.size(), src.size())
+	}
+	for _, s := range src.Nets {
+		net, ok := ms.net(s.Name)
+		if !ok {
+			return fmt.Errorf("can't merge model sets, net:%s not found", s.Name)
+		}
+		if s.ns != net.ns || len(s.TrAcc.Data) != len(net.TrAcc.Data) || len(s.OccAcc.Data) != len(net.OccAcc.Data) {
+			return fmt.Errorf("can't merge model sets, net:%s, num states:%d/%d", s.Name, net.ns, s.ns)
+		}
+		for i := 1; i < net.ns-1; i++ {
+			if _, ok := net.B[i].(model.Merger); !ok {
+				return fmt.Errorf("net:%s, state:%d, output PDF of type %T can't be merged", net.Name, i, net.B[i])
+			}
+			if reflect.TypeOf(s.B[i]) != reflect.TypeOf(net.B[i]) || s.B[i].Dim() != net.B[i].Dim() {
+				return fmt.Errorf("net:%s, state:%d, can't merge output PDF of type %T and dim %d into type %T and dim %d",
+					net.Name, i, s.B[i], s.B[i].Dim(), net.B[i], net.B[i].Dim())
+			}
+		}
+	}
+	done := make(map[model.Modeler]bool) // output PDFs may be shared.
+	for _, s := range src.Nets {
+		net, _ := ms.net(s.Name)
+		floats.Add(net.TrAcc.Data, s.TrAcc.Data)
+		floats.Add(net.OccAcc.Data, s.OccAcc.Data)
+		for i := 1; i < net.ns-1; i++ {
+			if done[net.B[i]] {
+				continue
+			}
+			done[net.B[i]] = true
+			err := net.B[i].(model.Merger).Merge(s.B[i])
+			if err != nil {
+				return fmt.Errorf("net:%s, state:%d, %s", net.Name, i, err)
+			}
+		}
+	}
+	return nil
+}
+
 func (ms *Set) size() int {
 	return len(ms.Nets)
 }
@@ -135,6 +233,62 @@ func (ms *Set) NewNet(name string, a *narray.NArray, b []model.Modeler) (*Net, e
 	return net, nil
 }
 
+// MarshalJSON implements the json.Marshaler interface.
+// Transition log probabilities equal to -Inf are encoded as -MaxFloat64.
+func (m *Net) MarshalJSON() ([]byte, error) {
+
+	type net Net // prevents recursion
+	v := net(*m)
+	v.A = m.A.Copy()
+	floatx.ConvertInfSlice(v.A.Data)
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+// The output PDFs are created using the model decoders registered in package model.
+func (m *Net) UnmarshalJSON(b []byte) error {
+
+	type net Net // prevents recursion
+	var v struct {
+		net
+		B []json.RawMessage `json:"output_prob"`
+	}
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+	*m = Net(v.net)
+	if m.A == nil || len(m.A.Shape) != 2 {
+		return fmt.Errorf("net:%s, missing transition probabilities", m.Name)
+	}
+	m.ns = m.A.Shape[0]
+	if len(v.B) != m.ns {
+		return fmt.Errorf("net:%s, num output PDFs is [%d], expected [%d]", m.Name, len(v.B), m.ns)
+	}
+	for i, x := range m.A.Data {
+		if x == -math.MaxFloat64 {
+			m.A.Data[i] = math.Inf(-1)
+		}
+	}
+	m.B = make([]model.Modeler, m.ns)
+	for i, raw := range v.B {
+		if string(raw) == "null" {
+			continue
+		}
+		m.B[i], err = model.Decode(raw)
+		if err != nil {
+			return fmt.Errorf("net:%s, state:%d, %s", m.Name, i, err)
+		}
+	}
+	if m.TrAcc == nil {
+		m.TrAcc = narray.New(m.ns, m.ns)
+	}
+	if m.OccAcc == nil {
+		m.OccAcc = narray.New(m.ns)
+	}
+	return nil
+}
+
 func (m *Net) logProb(s int, x []float64) float64 {
 	o := model.NewFloatObs(x, model.SimpleLabel(""))
 	return m.B[s].LogProb(o)
@@ -183,14 +337,30 @@ type chain struct {
 	totalProb float64
 }
 
-func (ms *Set) chainFromNets(obs model.Obs, m ...*Net) (*chain, error) {
+// floatObsSequence checks the obs type and returns the underlying sequence.
+func floatObsSequence(obs model.Obs) (model.FloatObsSequence, error) {
 
-	fos, ok := obs.(model.FloatObsSequence)
-	if !ok {
-		return nil, fmt.Errorf("obs must be of type model.FloatObsSequence, found type %s which is not supported", reflect.TypeOf(obs.Value()))
+	var fos model.FloatObsSequence
+	switch o := obs.(type) {
+	case model.FloatObsSequence:
+		fos = o
+	case *model.FloatObsSequence:
+		fos = *o
+	default:
+		return fos, fmt.Errorf("obs must be of type model.FloatObsSequence, found type %s which is not supported",
+			reflect.TypeOf(obs))
 	}
 	if len(fos.ValueAsSlice()) == 0 {
-		return nil, fmt.Errorf("obs sequence has no data")
+		return fos, fmt.Errorf("obs sequence has no data")
+	}
+	return fos, nil
+}
+
+func (ms *Set) chainFromNets(obs model.Obs, m ...*Net) (*chain, error) {
+
+	fos, err := floatObsSequence(obs)
+	if err != nil {
+		return nil, err
 	}
 
 	ch := &chain{
@@ -241,18 +411,9 @@ func (ms *Set) chainFromNets(obs model.Obs, m ...*Net) (*chain, error) {
 func (ms *Set) chainFromAssigner(obs model.Obs, assigner Assigner) (*chain, error) {
 
 	var hmms []*Net
-	var fos model.FloatObsSequence
-	switch o := obs.(type) {
-	case model.FloatObsSequence:
-		fos = o
-	case *model.FloatObsSequence:
-		fos = *o
-	default:
-		return nil, fmt.Errorf("obs must be of type model.FloatObsSequence, found type %s which is not supported",
-			reflect.TypeOf(obs))
-	}
-	if len(fos.ValueAsSlice()) == 0 {
-		return nil, fmt.Errorf("obs sequence has no data")
+	fos, err := floatObsSequence(obs)
+	if err != nil {
+		return nil, err
 	}
 
 	if assigner == nil && ms.size() == 1 {
@@ -327,6 +488,46 @@ func (ms *Set) chainFromAssigner(obs model.Obs, assigner Assigner) (*chain, erro
 	return ch, nil
 }
 
+// netScores returns log P(obs|net) for each net in the set. Each net is scored
+// as a chain of length one. Nets that can't be used as a single-net chain
+// (entry to exit transition) get a score of -Inf.
+func (ms *Set) netScores(obs model.Obs) ([]float64, error) {
+
+	scores := make([]float64, ms.size())
+	for k, net := range ms.Nets {
+		if isTeeModel(net) {
+			scores[k] = math.Inf(-1)
+			continue
+		}
+		ch, err := ms.chainFromNets(obs, net)
+		if err != nil {
+			return nil, err
+		}
+		scores[k] = ch.forward()
+	}
+	return scores, nil
+}
+
+// logProbAll returns log P(obs|set) when all the nets in the set compete
+// with equal prior probability. Returns an error if no net can score the
+// observation.
+func (ms *Set) logProbAll(obs model.Obs) (float64, error) {
+
+	if ms.size() == 0 {
+		return math.Inf(-1), fmt.Errorf("model set has no networks")
+	}
+	scores, err := ms.netScores(obs)
+	if err != nil {
+		return math.Inf(-1), err
+	}
+	if floats.Max(scores) == math.Inf(-1) {
+		return math.Inf(-1), fmt.Errorf("oid:%s, no network can score the observation", obs.ID())
+	}
+	logp := -math.Log(float64(ms.size()))
+	floats.AddConst(logp, scores)
+	return floats.LogSumExp(scores), nil
+}
+
 func (ch *chain) computeLikelihoods() {
 
 	ch.likelihoods = narray.New(ch.nq, ch.maxNS, ch.nobs)
@@ -341,9 +542,18 @@ func (ch *chain) computeLikelihoods() {
 	}
 }
 
-func (ch *chain) update() error {
+// update accumulates forward-backward counts. Counts are scaled by weight w.
+func (ch *chain) update(w float64) error {
 
 	ch.fb() // Compute forward-backward probabilities.
+	return ch.accumulate(w)
+}
+
+// accumulate adds the counts to the accumulators of the nets and output PDFs
+// in the chain. Requires the forward-backward probabilities. Counts are scaled
+// by weight w.
+func (ch *chain) accumulate(w float64) error {
+
 	logProb := ch.beta.At(0, 0, 0)
 	totalProb := math.Exp(logProb)
 	if logProb == math.Inf(-1) {
@@ -357,11 +567,11 @@ func (ch *chain) update() error {
 		exit := ch.ns[q] - 1
 		for t, vec := range ch.vectors {
 			for i := 0; i < exit; i++ {
-				w := ch.doOccAcc(q, i, t, totalProb) / totalProb
-				ch.doTrAcc(q, i, t, totalProb)
+				occ := ch.doOccAcc(q, i, t, totalProb, w) / totalProb
+				ch.doTrAcc(q, i, t, totalProb, w)
 				if i > 0 {
 					o := model.F64ToObs(vec, "")
-					h.B[i].UpdateOne(o, w) // TODO prove!
+					h.B[i].UpdateOne(o, occ*w) // TODO prove!
 				}
 			}
 		}
@@ -369,7 +579,9 @@ func (ch *chain) update() error {
 	return nil
 }
 
-func (ch *chain) updateFromAlignments() error {
+// updateFromAlignments accumulates output PDF counts using the alignment
+// info in the obs object. Counts are scaled by weight w.
+func (ch *chain) updateFromAlignments(w float64) error {
 
 	// Get alignments.
 	aligner, ok := ch.obs.(model.Aligner)
@@ -387,11 +599,11 @@ func (ch *chain) updateFromAlignments() error {
 		return fmt.Errorf("oid:%s - alignment length is [%d] - does not match num observations in sequence [%d]", ch.obs.ID(), al[len(al)-1].End, ch.nobs)
 	}
 
-	// Iterate over alignment nodes. Find the net by name and state number.
-	// TODO: hardcoded for state alignments. Need to impl. net-level alignments?
-	// Also, this is using a naming convention (xxx-N), can we use a better design?
+	// Iterate over state alignment nodes. Find the net by name and state number.
+	// Net-level nodes are expanded using their children.
+	// TODO: this is using a naming convention (xxx-N), can we use a better design?
 	// Include state index in alignment node?
-	for _, node := range al {
+	for _, node := range leaves(al) {
 		s := strings.Split(node.Name, "-") // format is xxx-N where xxx is the net name and N is the state index.
 		if len(s) != 2 {
 			return fmt.Errorf("oid:%s - there must be exactly one \"-\" in the alignment node name [%s]", ch.obs.ID(), node.Name)
@@ -405,13 +617,27 @@ func (ch *chain) updateFromAlignments() error {
 		for p := node.Start; p < node.End; p++ {
 			vec := ch.vectors[p]
 			o := model.F64ToObs(vec, "")
-			h.B[int(st)].UpdateOne(o, 1)
+			h.B[int(st)].UpdateOne(o, w)
 		}
 	}
 	return nil
 }
 
-func (ch *chain) doOccAcc(q, i, t int, tp float64) float64 {
+// leaves returns the nodes at the lowest level of the alignment trees.
+func leaves(nodes []*model.ANode) []*model.ANode {
+
+	var out []*model.ANode
+	for _, node := range nodes {
+		if len(node.Children) == 0 {
+			out = append(out, node)
+			continue
+		}
+		out = append(out, leaves(node.Children)...)
+	}
+	return out
+}
+
+func (ch *chain) doOccAcc(q, i, t int, tp, weight float64) float64 {
 
 	h := ch.hmms[q]
 	exit := ch.ns[q] - 1
@@ -426,12 +652,12 @@ func (ch *chain) doOccAcc(q, i, t int, tp float64) float64 {
 			vv = w
 		}
 	}
-	h.OccAcc.Inc(v/tp, i)
+	h.OccAcc.Inc(weight*v/tp, i)
 	glog.V(6).Infof("oid:%s, q:%d, t:%d, i:%d, occ:%.0f", ch.obs.ID(), q, t, i, vv)
 	return v
 }
 
-func (ch *chain) doTrAcc(q, i, t int, tp float64) {
+func (ch *chain) doTrAcc(q, i, t int, tp, weight float64) {
 
 	h := ch.hmms[q]
 	ns := ch.ns[q]
@@ -457,7 +683,7 @@ func (ch *chain) doTrAcc(q, i, t int, tp float64) {
 		default:
 			continue
 		}
-		h.TrAcc.Inc(math.Exp(v)/tp, i, j)
+		h.TrAcc.Inc(weight*math.Exp(v)/tp, i, j)
 		glog.V(6).Infof("oid:%s, q:%d, t:%d, i:%d, j:%d, tracc:%.0f", ch.obs.ID(), q, t, i, j, v)
 	}
 }
@@ -509,11 +735,22 @@ func (ms *Set) reestimate(updateTP, updateOP bool) {
 
 func (ch *chain) fb() {
 
+	alphaLogProb := ch.forward()
+	betaLogProb := ch.backward()
+
+	diff := (alphaLogProb - betaLogProb) / float64(ch.nobs)
+	glog.V(2).Infof("alpha-beta relative diff:%e", diff)
+
+	return
+}
+
+// forward computes the alpha array and returns log P(obs|chain).
+func (ch *chain) forward() float64 {
+
 	nq := ch.nq // num hmm models in chain.
 	nobs := ch.nobs
 
 	alpha := ch.alpha
-	beta := ch.beta
 	hmms := ch.hmms
 	ns := ch.ns
 
@@ -589,6 +826,18 @@ func (ch *chain) fb() {
 	alphaLogProb := alpha.At(nq-1, ch.ns[nq-1]-1, nobs-1)
 	glog.V(2).Infof("alpha total prob:%.0f, avg per obs:%.0f",
 		alphaLogProb, alphaLogProb/float64(nobs))
+	return alphaLogProb
+}
+
+// backward computes the beta array and returns log P(obs|chain).
+func (ch *chain) backward() float64 {
+
+	nq := ch.nq // num hmm models in chain.
+	nobs := ch.nobs
+
+	beta := ch.beta
+	hmms := ch.hmms
+	ns := ch.ns
 
 	// Compute beta.
 
@@ -662,11 +911,7 @@ func (ch *chain) fb() {
 
 	betaLogProb := beta.At(0, 0, 0)
 	glog.V(2).Infof("beta total prob:%.0f, avg per obs:%.0f", betaLogProb, betaLogProb/float64(nobs))
-
-	diff := (alphaLogProb - betaLogProb) / float64(nobs)
-	glog.V(2).Infof("alpha-beta relative diff:%e", diff)
-
-	return
+	return betaLogProb
 }
 
 func isTeeModel(m *Net) bool {
@@ -9,6 +9,8 @@ import (
 	"testing"
 
 	"github.com/akualab/gjoa/model"
+	"github.com/akualab/gjoa/model/gaussian"
+	"github.com/akualab/gjoa/model/gmm"
 	"github.com/akualab/ju"
 	narray "github.com/akualab/narray/na64"
 	"github.com/golang/glog"
@@ -453,7 +455,7 @@ func TestAlphaBeta(t *testing.T) {
 
 	t.Log("")
 	t.Log("compute fb using package")
-	err = hmms.update()
+	err = hmms.update(1.0)
 	if err != nil {
 		t.Log(err)
 	}
@@ -581,7 +583,7 @@ func TestTeeModel(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	hmms2.update()
+	hmms2.update(1.0)
 	nq := hmms2.nq
 	alpha2 := hmms2.alpha.At(nq-1, hmms2.ns[nq-1]-1, nobs-1)
 	beta2 := hmms2.beta.At(0, 0, 0)
@@ -626,7 +628,7 @@ func TestLR(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	hmms2.update()
+	hmms2.update(1.0)
 	nq := hmms2.nq
 	alpha2 := hmms2.alpha.At(nq-1, hmms2.ns[nq-1]-1, nobs-1)
 	beta2 := hmms2.beta.At(0, 0, 0)
@@ -674,7 +676,7 @@ func TestLRAssign(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	hmms2.update()
+	hmms2.update(1.0)
 	nq := hmms2.nq
 	alpha2 := hmms2.alpha.At(nq-1, hmms2.ns[nq-1]-1, nobs-1)
 	beta2 := hmms2.beta.At(0, 0, 0)
@@ -692,6 +694,78 @@ func TestLRAssign(t *testing.T) {
 	}
 }
 
+func TestModelLogProb(t *testing.T) {
+
+	initChainFB(t)
+	modelSet, e := NewSet(hmm0, hmm1)
+	fatalIf(t, e)
+	testScorer := func() scorer {
+		return scorer{op: []float64{math.Log(0.4), math.Log(0.2), math.Log(0.4)}}
+	}
+
+	_, err := modelSet.makeLeftToRight("model 2", 4, 0.4, 0.1,
+		[]model.Modeler{nil, testScorer(), testScorer(), nil})
+	fatalIf(t, err)
+	_, errr := modelSet.makeLeftToRight("model 3", 6, 0.3, 0.2,
+		[]model.Modeler{nil, testScorer(), testScorer(), testScorer(), testScorer(), nil})
+	fatalIf(t, errr)
+
+	// The hmm model must be usable as a model.Modeler.
+	hmm := NewModel(OSet(modelSet), OAssign(DirectAssigner{}))
+	var m model.Modeler = hmm
+
+	// Same chain as in TestLRAssign().
+	simplelab := model.SimpleLabel("model 0,model 2,model 3,model 0,model 0,model 0,model 0,model 2")
+	oo := model.NewFloatObsSequence(xobs.Value().([][]float64), simplelab, "")
+	p, err := hmm.LabelLogProb(oo)
+	fatalIf(t, err)
+	if math.Abs(p-expectedProb) > smallNumber {
+		t.Fatalf("log prob:%f, expected:%f", p, expectedProb)
+	}
+	if _, err := hmm.LabelLogProb(xobs); err == nil {
+		t.Fatalf("expected error, observation has no label")
+	}
+
+	// No label, all nets compete.
+	var scores []float64
+	for _, net := range modelSet.Nets {
+		ch, err := modelSet.chainFromNets(xobs, net)
+		fatalIf(t, err)
+		ch.fb()
+		scores = append(scores, math.Exp(ch.beta.At(0, 0, 0)))
+	}
+	expected := math.Log((scores[0] + scores[1] + scores[2] + scores[3]) / 4)
+	p = m.LogProb(xobs)
+	if math.Abs(p-expected) > smallNumber {
+		t.Fatalf("log prob:%f, expected:%f", p, expected)
+	}
+
+	// With a label, the chain is created using the assigner.
+	p = m.LogProb(oo)
+	if math.Abs(p-expectedProb) > smallNumber {
+		t.Fatalf("log prob:%f, expected:%f", p, expectedProb)
+	}
+}
+
+func TestLogProbAllError(t *testing.T) {
+
+	ms, err := NewSet()
+	fatalIf(t, err)
+	zero := func() scorer {
+		return scorer{op: []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}}
+	}
+	_, err = ms.makeLeftToRight("zero", 4, 0.4, 0.1, []model.Modeler{nil, zero(), zero(), nil})
+	fatalIf(t, err)
+	if _, err := ms.logProbAll(xobs); err == nil {
+		t.Fatalf("expected error, no network can score the observation")
+	}
+	empty, err := NewSet()
+	fatalIf(t, err)
+	if _, err := empty.logProbAll(xobs); err == nil {
+		t.Fatalf("expected error, model set has no networks")
+	}
+}
+
 func TestHMMModel(t *testing.T) {
 
 	initChainFB(t)
@@ -776,3 +850,67 @@ func (m testModel) Predict(x model.Observer) ([]model.Labeler, error)        { r
 func (m testModel) LogProb(x model.Obs) float64                              { return 0 }
 func (m testModel) Sample(r *rand.Rand) model.Obs                            { return nil }
 func (m testModel) SampleChan(r *rand.Rand, size int) <-chan model.Obs       { return nil }
+
+func TestSplit(t *testing.T) {
+
+	mean, sd := []float64{1, 2}, []float64{0.5, 0.5}
+	g := gaussian.NewModel(2, gaussian.Mean(mean), gaussian.StdDev(sd))
+	shared := gmm.NewModel(2, 1, gmm.Name("shared"), gmm.Components([]*gaussian.Model{g}))
+	other := gmm.NewModel(2, 2, gmm.Name("other"))
+
+	ms, _ := NewSet()
+	_, err := ms.NewNet("a", MakeLeftToRight(4, .5, 0), []model.Modeler{nil, shared, shared, nil})
+	fatalIf(t, err)
+	_, err = ms.NewNet("b", MakeLeftToRight(3, .5, 0), []model.Modeler{nil, other, nil})
+	fatalIf(t, err)
+
+	fatalIf(t, ms.Split(4, 0.2))
+	for _, m := range []*gmm.Model{shared, other} {
+		if m.NComponents != 4 {
+			t.Fatalf("gmm:%s, expected 4 components, got %d", m.Name(), m.NComponents)
+		}
+	}
+
+	// Set without mixture output PDFs.
+	ms2, _ := NewSet()
+	_, err = ms2.NewNet("c", MakeLeftToRight(3, .5, 0), []model.Modeler{nil, g, nil})
+	fatalIf(t, err)
+	if ms2.Split(2, 0.2) == nil {
+		t.Fatalf("expected error for set without mixture output PDFs")
+	}
+}
+
+func TestReadModel(t *testing.T) {
+
+	r := rand.New(rand.NewSource(33))
+	ms := makeRandomSet(t, r, 3, 5, 2)
+	g := gmm.NewModel(2, 2, gmm.Name("mix"))
+	_, err := ms.NewNet("gmm", MakeLeftToRight(3, .5, 0), []model.Modeler{nil, g, nil})
+	fatalIf(t, err)
+	hmm := NewModel(OSet(ms), Name("test"), OAssign(DirectAssigner{}))
+
+	fn := filepath.Join(os.TempDir(), "hmm_read_model.json")
+	fatalIf(t, hmm.WriteJSONFile(fn))
+	m, err := model.ReadModelFile(fn)
+	fatalIf(t, err)
+	hmm1, ok := m.(*Model)
+	if !ok {
+		t.Fatalf("wrong model type: %T", m)
+	}
+	if hmm1.Name() != "test" || len(hmm1.Set.Nets) != 4 {
+		t.Fatalf("wrong model, name:%s, num nets:%d", hmm1.Name(), len(hmm1.Set.Nets))
+	}
+	if _, ok := hmm1.Set.byName["gmm"].B[1].(*gmm.Model); !ok {
+		t.Fatalf("wrong output PDF type: %T", hmm1.Set.byName["gmm"].B[1])
+	}
+
+	// Compare scores.
+	gen := newChainGen(r, true, 3, ms.Nets[:3]...)
+	for i := 0; i < 5; i++ {
+		obs, _ := gen.next("oid-" + fi(i))
+		p, p1 := hmm.LogProb(obs), hmm1.LogProb(obs)
+		if math.Abs(p-p1) > smallNumber {
+			t.Fatalf("log prob mismatch - expected:%f, got:%f", p, p1)
+		}
+	}
+}
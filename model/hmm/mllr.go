@@ -0,0 +1,816 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hmm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/akualab/gjoa/floatx"
+	"github.com/akualab/gjoa/model"
+	"github.com/akualab/gjoa/model/gaussian"
+	"github.com/akualab/gjoa/model/gmm"
+	"github.com/akualab/gjoa/model/kmeans"
+	"github.com/golang/glog"
+	"github.com/gonum/floats"
+)
+
+// Transform types.
+const (
+	// MLLR transforms the means of the output Gaussians.
+	MLLR = "mllr"
+	// FMLLR transforms the observations. Also known as constrained MLLR.
+	FMLLR = "fmllr"
+)
+
+// Num iterations over the rows of an FMLLR transform.
+const fmllrIterations = 20
+
+/*
+  Maximum likelihood linear regression. See:
+
+  C. J. Leggetter and P. C. Woodland, "Maximum likelihood linear regression for
+  speaker adaptation of continuous density hidden Markov models," Computer Speech
+  and Language, 1995.
+
+  M. J. F. Gales, "Maximum likelihood linear transformations for HMM-based speech
+  recognition," Computer Speech and Language, 1998.
+
+  The transform of a regression class is a dim x (dim+1) matrix W = [b A]. Row i
+  of W is estimated using the statistics of the Gaussians m in the class, where
+  g(m,t) is the occupation probability of Gaussian m at time t:
+
+  MLLR, xi(m) = [1 mean(m)]:
+
+   G(i) = sum_m sum_t g(m,t) / var(m,i) xi(m) xi(m)'
+   k(i) = sum_m sum_t g(m,t) o(t,i) / var(m,i) xi(m)
+   w(i) = inv(G(i)) k(i)
+
+  FMLLR, z(t) = [1 o(t)], beta = sum_m sum_t g(m,t):
+
+   G(i) = sum_m sum_t g(m,t) / var(m,i) z(t) z(t)'
+   k(i) = sum_m sum_t g(m,t) mean(m,i) / var(m,i) z(t)
+   w(i) = (alpha p(i) + k(i)) inv(G(i))
+
+  where p(i) is the extended cofactor row [0 c(i)] of A and alpha is the root of
+  alpha^2 p inv(G) p' + alpha p inv(G) k' - beta = 0 that maximizes the likelihood.
+  The rows are updated iteratively.
+
+  The Gaussians must have diagonal covariance matrices.
+
+*/
+
+// Transform is a set of affine transforms that adapt the output Gaussians of an HMM
+// to new data. Each Gaussian belongs to a regression class and all the Gaussians in a
+// class share a transform. The transform of class r is a dim x (dim+1) matrix W[r] = [b A].
+// For MLLR, the adapted mean is A mean + b. For FMLLR, observation o is scored
+// using A o + b and log|det(A)| is added to the log probability.
+type Transform struct {
+	Type     string `json:"type"`
+	ModelDim int    `json:"dim"`
+	// Maps the output Gaussians to regression classes. The key is net-state-component.
+	Classes map[string]int `json:"classes"`
+	// Transforms, one per class.
+	W [][][]float64 `json:"w"`
+	// Occupation counts, one per class.
+	Occupancy []float64 `json:"occupancy"`
+}
+
+// XformOption type is used to pass options to EstimateTransform().
+type XformOption func(*xformConfig)
+
+type xformConfig struct {
+	numClasses int
+	classFunc  func(net string, state, component int) int
+	minOcc     float64
+}
+
+// NumClasses is an option to group the output Gaussians into n regression classes
+// by clustering the means using the k-means algorithm. Default is one global class.
+func NumClasses(n int) XformOption {
+	return func(c *xformConfig) { c.numClasses = n }
+}
+
+// RegressionClasses is an option to assign the output Gaussians to regression
+// classes using a function of the net name, state, and mixture component. Single
+// Gaussian output PDFs are component zero. Classes must be numbered from zero.
+// Overrides the NumClasses option.
+func RegressionClasses(f func(net string, state, component int) int) XformOption {
+	return func(c *xformConfig) { c.classFunc = f }
+}
+
+// MinOccupancy is an option to set the minimum occupation count needed to estimate
+// the transform of a regression class. Classes with less data, or whose statistics
+// are singular, use the global transform estimated with the data of all the classes.
+func MinOccupancy(v float64) XformOption {
+	return func(c *xformConfig) { c.minOcc = v }
+}
+
+// EstimateTransform estimates a transform of type MLLR or FMLLR using the
+// adaptation data in x. The occupation probabilities of the Gaussians are computed
+// using the model options. (Forward-backward, Viterbi, or alignments.) The model
+// is not modified. Use Transform.Apply to get the adapted model. The output PDFs
+// must be of type *gaussian.Model or *gmm.Model with diagonal covariance.
+func (m *Model) EstimateTransform(x model.Observer, typ string, options ...XformOption) (*Transform, error) {
+
+	if typ != MLLR && typ != FMLLR {
+		return nil, fmt.Errorf("name:%s, unknown transform type [%s]", m.ModelName, typ)
+	}
+	cfg := &xformConfig{numClasses: 1}
+	for _, option := range options {
+		option(cfg)
+	}
+	dim := m.Dim()
+	t := &Transform{Type: typ, ModelDim: dim}
+	class, err := m.Set.regressionClasses(t, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("name:%s, %s", m.ModelName, err)
+	}
+
+	// Run the update using a copy of the model set whose output PDFs collect
+	// the statistics. The transition probabilities are not updated.
+	acc := newXformAcc(typ, dim, class, len(t.W))
+	set, err := m.Set.mapPDFs(func(net string, state int, pdf model.Modeler) (model.Modeler, error) {
+		cs, logw, err := gaussians(pdf)
+		if err != nil {
+			return nil, err
+		}
+		return &accPDF{Modeler: pdf, comps: cs, logw: logw, acc: acc}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("name:%s, %s", m.ModelName, err)
+	}
+	am := *m
+	am.Set = set
+	am.logProb, am.updateCount, am.updateFailCount = 0, 0, 0
+	err = am.Update(x, model.NoWeight)
+	if err != nil {
+		glog.Warningf("name:%s, estimate transform, %s", m.ModelName, err)
+	}
+
+	// Estimate the transforms. Use the global transform for classes with little data.
+	stats := acc.classStats()
+	global := newClassStats(dim)
+	for _, s := range stats {
+		global.add(s)
+	}
+	gw, err := global.estimate(typ)
+	if err != nil {
+		return nil, fmt.Errorf("name:%s, failed to estimate global transform, occupancy:%f, %s", m.ModelName, global.occ, err)
+	}
+	for r, s := range stats {
+		t.Occupancy[r] = s.occ
+		if len(stats) == 1 {
+			t.W[r] = gw
+			break
+		}
+		if s.occ < cfg.minOcc || s.occ == 0 {
+			glog.Warningf("name:%s, class:%d, occupancy:%f, using global transform", m.ModelName, r, s.occ)
+			t.W[r] = gw
+			continue
+		}
+		t.W[r], err = s.estimate(typ)
+		if err != nil {
+			glog.Warningf("name:%s, class:%d, occupancy:%f, using global transform, %s", m.ModelName, r, s.occ, err)
+			t.W[r] = gw
+		}
+	}
+	glog.V(1).Infof("name:%s, estimated %s transform, num classes:%d, occupancy:%f", m.ModelName, typ, len(t.W), global.occ)
+	return t, nil
+}
+
+// Apply returns a copy of m that uses the transforms to compute the output
+// probabilities. The transition probabilities are copied and m is not modified.
+// For MLLR, the output PDFs are Gaussians or GMMs with adapted means. For FMLLR,
+// the output PDFs transform the observations and can't be trained or written.
+func (t *Transform) Apply(m *Model) (*Model, error) {
+
+	if t.Type != MLLR && t.Type != FMLLR {
+		return nil, fmt.Errorf("unknown transform type [%s]", t.Type)
+	}
+	if dim := m.Dim(); dim != t.ModelDim {
+		return nil, fmt.Errorf("name:%s, transform dim is [%d], model dim is [%d]", m.ModelName, t.ModelDim, dim)
+	}
+	xf, err := t.affine()
+	if err != nil {
+		return nil, err
+	}
+	set, err := m.Set.mapPDFs(func(net string, state int, pdf model.Modeler) (model.Modeler, error) {
+		cs, logw, err := gaussians(pdf)
+		if err != nil {
+			return nil, err
+		}
+		classes := make([]int, len(cs))
+		for k := range cs {
+			r, ok := t.Classes[gaussKey(net, state, k)]
+			if !ok || r < 0 || r >= len(t.W) {
+				return nil, fmt.Errorf("no regression class for net:%s, state:%d, component:%d", net, state, k)
+			}
+			classes[k] = r
+		}
+		if t.Type == FMLLR {
+			return &fmllrPDF{name: pdf.Name(), dim: pdf.Dim(), comps: cs, logw: logw, xf: xf, classes: classes}, nil
+		}
+		acs := make([]*gaussian.Model, len(cs))
+		for k, c := range cs {
+			acs[k] = xf[classes[k]].adaptGaussian(c)
+		}
+		if logw == nil {
+			return acs[0], nil
+		}
+		lw := make([]float64, len(logw))
+		copy(lw, logw)
+		return gmm.NewModel(pdf.Dim(), len(acs), gmm.Name(pdf.Name()), gmm.Components(acs), gmm.LogWeights(lw)), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("name:%s, %s", m.ModelName, err)
+	}
+	am := *m
+	am.Set = set
+	am.Clear()
+	return &am, nil
+}
+
+// Returns the Gaussians of an output PDF and the log mixture weights. The
+// weights are nil for single Gaussians. Returns an error if a Gaussian has a
+// full covariance matrix.
+func gaussians(pdf model.Modeler) ([]*gaussian.Model, []float64, error) {
+
+	var cs []*gaussian.Model
+	var logw []float64
+	switch p := pdf.(type) {
+	case *gaussian.Model:
+		cs = []*gaussian.Model{p}
+	case *gmm.Model:
+		cs, logw = p.Components, p.LogWeights
+	default:
+		return nil, nil, fmt.Errorf("output PDF of type %T is not supported", pdf)
+	}
+	for _, c := range cs {
+		if !c.Diag {
+			return nil, nil, fmt.Errorf("gaussian [%s] has a full covariance matrix, only diagonal covariance is supported", c.ModelName)
+		}
+	}
+	return cs, logw, nil
+}
+
+func gaussKey(net string, state, component int) string {
+	return fmt.Sprintf("%s-%d-%d", net, state, component)
+}
+
+// Assigns the output Gaussians to regression classes. Initializes the class
+// map, transforms, and occupancy of t. A Gaussian shared by many states
+// belongs to the class of the first state.
+func (ms *Set) regressionClasses(t *Transform, cfg *xformConfig) (map[*gaussian.Model]int, error) {
+
+	type entry struct {
+		key string
+		g   *gaussian.Model
+	}
+	var entries []entry
+	class := make(map[*gaussian.Model]int)
+	var means [][]float64
+	numClasses := 1
+	for _, h := range ms.Nets {
+		for i := 1; i < h.ns-1; i++ {
+			cs, _, err := gaussians(h.B[i])
+			if err != nil {
+				return nil, fmt.Errorf("net:%s, state:%d, %s", h.Name, i, err)
+			}
+			for k, c := range cs {
+				entries = append(entries, entry{gaussKey(h.Name, i, k), c})
+				if _, ok := class[c]; ok {
+					continue
+				}
+				class[c] = 0
+				means = append(means, c.Mean)
+				if cfg.classFunc == nil {
+					continue
+				}
+				r := cfg.classFunc(h.Name, i, k)
+				if r < 0 {
+					return nil, fmt.Errorf("net:%s, state:%d, component:%d, negative regression class [%d]", h.Name, i, k, r)
+				}
+				class[c] = r
+				if r >= numClasses {
+					numClasses = r + 1
+				}
+			}
+		}
+	}
+	if len(class) == 0 {
+		return nil, fmt.Errorf("no output Gaussians found in model set")
+	}
+
+	if cfg.classFunc == nil && cfg.numClasses > 1 {
+		obs, err := model.NewFloatObserver(means, make([]model.SimpleLabel, len(means)))
+		if err != nil {
+			return nil, err
+		}
+		km := kmeans.NewModel(cfg.numClasses, kmeans.Seed(model.DefaultSeed))
+		err = km.Cluster(obs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to cluster gaussians, %s", err)
+		}
+		for c := range class {
+			class[c], _ = km.Nearest(c.Mean)
+		}
+		numClasses = cfg.numClasses
+	}
+
+	t.Classes = make(map[string]int, len(entries))
+	for _, e := range entries {
+		t.Classes[e.key] = class[e.g]
+	}
+	t.W = make([][][]float64, numClasses)
+	t.Occupancy = make([]float64, numClasses)
+	return class, nil
+}
+
+// Returns a copy of the set. The output PDFs are replaced using function f. Shared
+// output PDFs are replaced once. The accumulators are not copied.
+func (ms *Set) mapPDFs(f func(net string, state int, pdf model.Modeler) (model.Modeler, error)) (*Set, error) {
+
+	set, err := NewSet()
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[model.Modeler]model.Modeler)
+	for _, h := range ms.Nets {
+		b := make([]model.Modeler, h.ns)
+		for i := 1; i < h.ns-1; i++ {
+			pdf, ok := done[h.B[i]]
+			if !ok {
+				pdf, err = f(h.Name, i, h.B[i])
+				if err != nil {
+					return nil, fmt.Errorf("net:%s, state:%d, %s", h.Name, i, err)
+				}
+				done[h.B[i]] = pdf
+			}
+			b[i] = pdf
+		}
+		_, err = set.NewNet(h.Name, h.A.Copy(), b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// classStats holds the statistics needed to estimate the transform of a regression class.
+type classStats struct {
+	occ float64
+	g   [][][]float64 // one (dim+1) x (dim+1) matrix per row of the transform
+	k   [][]float64   // one (dim+1) vector per row of the transform
+}
+
+func newClassStats(dim int) *classStats {
+	s := &classStats{
+		g: make([][][]float64, dim),
+		k: floatx.MakeFloat2D(dim, dim+1),
+	}
+	for i := range s.g {
+		s.g[i] = floatx.MakeFloat2D(dim+1, dim+1)
+	}
+	return s
+}
+
+func (s *classStats) add(src *classStats) {
+	s.occ += src.occ
+	for i := range s.g {
+		for j := range s.g[i] {
+			floats.Add(s.g[i][j], src.g[i][j])
+		}
+		floats.Add(s.k[i], src.k[i])
+	}
+}
+
+// Adds w * c(i) z z' to G(i) and w * e(i) z to k(i) where z = [1 x].
+func (s *classStats) addOuter(x, c, e []float64, w float64) {
+
+	z := append([]float64{1}, x...)
+	for i := range s.g {
+		for j, zj := range z {
+			floats.AddScaled(s.g[i][j], w*c[i]*zj, z)
+		}
+		floats.AddScaled(s.k[i], w*e[i], z)
+	}
+}
+
+// Estimates the transform of the class.
+func (s *classStats) estimate(typ string) ([][]float64, error) {
+
+	if s.occ == 0 {
+		return nil, fmt.Errorf("no adaptation data")
+	}
+	dim := len(s.g)
+	ginv := make([][][]float64, dim)
+	w := floatx.MakeFloat2D(dim, dim+1)
+	for i := range s.g {
+		var err error
+		ginv[i], _, err = floatx.Invert(s.g[i])
+		if err != nil {
+			return nil, fmt.Errorf("row:%d, %s", i, err)
+		}
+		if typ == MLLR {
+			floatx.MulVec(ginv[i], s.k[i], w[i])
+		}
+	}
+	if typ == MLLR {
+		return w, nil
+	}
+
+	// FMLLR. Start with the identity transform.
+	for i := range w {
+		w[i][i+1] = 1
+	}
+	a := floatx.MakeFloat2D(dim, dim)
+	p := make([]float64, dim+1)
+	gp := make([]float64, dim+1)
+	gk := make([]float64, dim+1)
+	for iter := 0; iter < fmllrIterations; iter++ {
+		for i := range w {
+			for j := range a {
+				copy(a[j], w[j][1:])
+			}
+			ainv, _, err := floatx.Invert(a)
+			if err != nil {
+				return nil, fmt.Errorf("iter:%d, row:%d, %s", iter, i, err)
+			}
+			// The cofactor row of A is proportional to column i of inv(A).
+			for j := range ainv {
+				p[j+1] = ainv[j][i]
+			}
+			floatx.MulVec(ginv[i], p, gp)
+			floatx.MulVec(ginv[i], s.k[i], gk)
+			e1, e2 := floats.Dot(p, gp), floats.Dot(p, gk)
+			alpha := fmllrAlpha(e1, e2, s.occ)
+			for j := range w[i] {
+				w[i][j] = alpha*gp[j] + gk[j]
+			}
+		}
+	}
+	return w, nil
+}
+
+// Returns the root of e1 alpha^2 + e2 alpha - beta = 0 that maximizes
+// beta log|alpha e1 + e2| - alpha^2 e1 / 2.
+func fmllrAlpha(e1, e2, beta float64) float64 {
+
+	d := math.Sqrt(e2*e2 + 4*e1*beta)
+	a1, a2 := (-e2+d)/(2*e1), (-e2-d)/(2*e1)
+	f := func(a float64) float64 { return beta*math.Log(math.Abs(a*e1+e2)) - a*a*e1/2 }
+	if f(a1) >= f(a2) {
+		return a1
+	}
+	return a2
+}
+
+// xformAcc accumulates the transform statistics.
+type xformAcc struct {
+	typ   string
+	dim   int
+	class map[*gaussian.Model]int
+	stats []*classStats
+
+	// MLLR statistics per Gaussian.
+	occ  map[*gaussian.Model]float64
+	sumx map[*gaussian.Model][]float64
+
+	// FMLLR temporary storage per class.
+	c, e [][]float64
+	used []bool
+}
+
+func newXformAcc(typ string, dim int, class map[*gaussian.Model]int, numClasses int) *xformAcc {
+
+	acc := &xformAcc{
+		typ:   typ,
+		dim:   dim,
+		class: class,
+		stats: make([]*classStats, numClasses),
+		occ:   make(map[*gaussian.Model]float64),
+		sumx:  make(map[*gaussian.Model][]float64),
+		c:     floatx.MakeFloat2D(numClasses, dim),
+		e:     floatx.MakeFloat2D(numClasses, dim),
+		used:  make([]bool, numClasses),
+	}
+	for r := range acc.stats {
+		acc.stats[r] = newClassStats(dim)
+	}
+	return acc
+}
+
+// Adds observation x with occupation probabilities post for Gaussians cs.
+func (acc *xformAcc) add(cs []*gaussian.Model, post, x []float64) {
+
+	if acc.typ == MLLR {
+		for k, c := range cs {
+			if _, ok := acc.sumx[c]; !ok {
+				acc.sumx[c] = make([]float64, acc.dim)
+			}
+			acc.occ[c] += post[k]
+			floats.AddScaled(acc.sumx[c], post[k], x)
+		}
+		return
+	}
+
+	// FMLLR. Combine the Gaussians in each class before computing the outer product.
+	for k, c := range cs {
+		r := acc.class[c]
+		if !acc.used[r] {
+			floatx.Clear(acc.c[r])
+			floatx.Clear(acc.e[r])
+			acc.used[r] = true
+		}
+		for i, sd := range c.StdDev {
+			v := post[k] / (sd * sd)
+			acc.c[r][i] += v
+			acc.e[r][i] += v * c.Mean[i]
+		}
+		acc.stats[r].occ += post[k]
+	}
+	for r, used := range acc.used {
+		if used {
+			acc.stats[r].addOuter(x, acc.c[r], acc.e[r], 1)
+			acc.used[r] = false
+		}
+	}
+}
+
+// Returns the statistics for each class.
+func (acc *xformAcc) classStats() []*classStats {
+
+	if acc.typ == FMLLR {
+		return acc.stats
+	}
+	for c, occ := range acc.occ {
+		if occ == 0 {
+			continue
+		}
+		s := acc.stats[acc.class[c]]
+		xi := append([]float64{1}, c.Mean...)
+		ivar := make([]float64, acc.dim)
+		sx := make([]float64, acc.dim)
+		for i, sd := range c.StdDev {
+			ivar[i] = 1 / (sd * sd)
+			sx[i] = acc.sumx[c][i] * ivar[i]
+		}
+		for i := range s.g {
+			for j, xj := range xi {
+				floats.AddScaled(s.g[i][j], occ*ivar[i]*xj, xi)
+			}
+			floats.AddScaled(s.k[i], sx[i], xi)
+		}
+		s.occ += occ
+	}
+	return acc.stats
+}
+
+// accPDF wraps an output PDF to collect transform statistics. The chain
+// calls UpdateOne with the state occupation probability of each observation.
+type accPDF struct {
+	model.Modeler
+	comps []*gaussian.Model
+	logw  []float64
+	acc   *xformAcc
+}
+
+// UpdateOne adds the observation to the transform statistics.
+func (p *accPDF) UpdateOne(o model.Obs, w float64) {
+
+	if w < smallNumber {
+		return
+	}
+	x := o.Value().([]float64)
+	post := []float64{w}
+	if p.logw != nil {
+		post = make([]float64, len(p.comps))
+		for k, c := range p.comps {
+			post[k] = p.logw[k] + c.LogProb(o)
+		}
+		sum := floats.LogSumExp(post)
+		for k, v := range post {
+			post[k] = w * math.Exp(v-sum)
+		}
+	}
+	p.acc.add(p.comps, post, x)
+}
+
+// Clear doesn't modify the wrapped PDF.
+func (p *accPDF) Clear() {}
+
+// affine is the transform of a regression class: y = A x + b.
+type affine struct {
+	a      [][]float64
+	b      []float64
+	logDet float64
+	ainv   [][]float64
+}
+
+// Returns the affine transforms, one per class. Returns an error if an FMLLR
+// transform is singular.
+func (t *Transform) affine() ([]*affine, error) {
+
+	xf := make([]*affine, len(t.W))
+	for r, w := range t.W {
+		f := &affine{
+			a: floatx.MakeFloat2D(t.ModelDim, t.ModelDim),
+			b: make([]float64, t.ModelDim),
+		}
+		for i, row := range w {
+			f.b[i] = row[0]
+			copy(f.a[i], row[1:])
+		}
+		if t.Type == FMLLR {
+			var err error
+			f.ainv, f.logDet, err = floatx.Invert(f.a)
+			if err != nil {
+				return nil, fmt.Errorf("class:%d, %s", r, err)
+			}
+		}
+		xf[r] = f
+	}
+	return xf, nil
+}
+
+// Returns A x + b.
+func (f *affine) apply(x []float64) []float64 {
+	y := make([]float64, len(f.b))
+	floatx.MulVec(f.a, x, y)
+	floats.Add(y, f.b)
+	return y
+}
+
+// Returns a copy of g with the mean transformed. The covariance is not modified.
+func (f *affine) adaptGaussian(g *gaussian.Model) *gaussian.Model {
+
+	sd := make([]float64, g.ModelDim)
+	copy(sd, g.StdDev)
+	options := []gaussian.Option{gaussian.Name(g.ModelName), gaussian.Mean(f.apply(g.Mean)), gaussian.StdDev(sd)}
+	if !g.Diag {
+		options = append(options, gaussian.Cov(floatx.CopyFloat2D(g.Cov)))
+	}
+	return gaussian.NewModel(g.ModelDim, options...)
+}
+
+// fmllrPDF is an output PDF that transforms the observations before scoring
+// them with the Gaussians of the base model.
+type fmllrPDF struct {
+	name    string
+	dim     int
+	comps   []*gaussian.Model
+	logw    []float64
+	xf      []*affine
+	classes []int
+}
+
+// Name returns the name of the base output PDF.
+func (p *fmllrPDF) Name() string { return p.name }
+
+// Dim is the dimensionality of the observation vector.
+func (p *fmllrPDF) Dim() int { return p.dim }
+
+// LogProb returns the log probability of the observation including the log
+// determinant of the transform.
+func (p *fmllrPDF) LogProb(o model.Obs) float64 {
+
+	x := o.Value().([]float64)
+	probs := make([]float64, len(p.comps))
+	for k, c := range p.comps {
+		f := p.xf[p.classes[k]]
+		probs[k] = c.LogProb(model.F64ToObs(f.apply(x), "")) + f.logDet
+		if p.logw != nil {
+			probs[k] += p.logw[k]
+		}
+	}
+	if len(probs) == 1 {
+		return probs[0]
+	}
+	return floats.LogSumExp(probs)
+}
+
+// Sample returns a sample drawn from a Gaussian of the base model and
+// transformed using the inverse transform.
+func (p *fmllrPDF) Sample(r *rand.Rand) model.Obs {
+
+	k := 0
+	if p.logw != nil {
+		k = model.RandIntFromLogDist(p.logw, r)
+	}
+	f := p.xf[p.classes[k]]
+	y := p.comps[k].Sample(r).Value().([]float64)
+	floats.Sub(y, f.b)
+	x := make([]float64, p.dim)
+	floatx.MulVec(f.ainv, y, x)
+	return model.NewFloatObs(x, model.SimpleLabel(""))
+}
+
+// SampleChan returns a channel with "size" samples.
+func (p *fmllrPDF) SampleChan(r *rand.Rand, size int) <-chan model.Obs {
+
+	return model.SampleChanContext(context.Background(), p, r, size)
+}
+
+// Update is not supported.
+func (p *fmllrPDF) Update(x model.Observer, w func(model.Obs) float64) error {
+	return fmt.Errorf("name:%s, can't update an fmllr output PDF", p.name)
+}
+
+// UpdateOne is ignored. The base model is not modified.
+func (p *fmllrPDF) UpdateOne(o model.Obs, w float64) {}
+
+// Estimate is not supported.
+func (p *fmllrPDF) Estimate() error {
+	return fmt.Errorf("name:%s, can't estimate an fmllr output PDF", p.name)
+}
+
+// Clear is ignored. The base model is not modified.
+func (p *fmllrPDF) Clear() {}
+
+// Predict is not supported.
+func (p *fmllrPDF) Predict(x model.Observer) ([]model.Labeler, error) {
+	return nil, fmt.Errorf("name:%s, predict is not supported by an fmllr output PDF", p.name)
+}
+
+// MarshalJSON returns an error. Write the base model and the transform instead.
+func (p *fmllrPDF) MarshalJSON() ([]byte, error) {
+	return nil, fmt.Errorf("name:%s, can't write an fmllr output PDF - write the base model and the transform", p.name)
+}
+
+// IO
+
+// ReadTransform unmarshals json data from an io.Reader.
+func ReadTransform(r io.Reader) (*Transform, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	t := &Transform{}
+	err = json.Unmarshal(b, t)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.W) == 0 {
+		return nil, fmt.Errorf("transform has no classes")
+	}
+	for r, w := range t.W {
+		if len(w) != t.ModelDim {
+			return nil, fmt.Errorf("class:%d, transform has [%d] rows, expected [%d]", r, len(w), t.ModelDim)
+		}
+		for i, row := range w {
+			if len(row) != t.ModelDim+1 {
+				return nil, fmt.Errorf("class:%d, row:%d, transform has [%d] columns, expected [%d]", r, i, len(row), t.ModelDim+1)
+			}
+		}
+	}
+	return t, nil
+}
+
+// ReadTransformFile unmarshals json data from a file.
+func ReadTransformFile(fn string) (*Transform, error) {
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTransform(f)
+}
+
+// Write writes the transform to an io.Writer.
+func (t *Transform) Write(w io.Writer) error {
+
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, e := w.Write(b)
+	return e
+}
+
+// WriteFile writes the transform to a file.
+func (t *Transform) WriteFile(fn string) error {
+
+	e := os.MkdirAll(filepath.Dir(fn), 0755)
+	if e != nil {
+		return e
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return t.Write(f)
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hmm
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/akualab/gjoa"
+	gm "github.com/akualab/gjoa/model/gaussian"
+)
+
+// Transforms used to generate adaptation data. W = [b A].
+var (
+	testW0 = [][]float64{{20, 1.1, 0.1}, {-30, -0.05, 0.9}}
+	testW1 = [][]float64{{-10, 0.95, 0}, {15, 0.1, 1.05}}
+)
+
+// Returns a transform that maps the nets in the set to classes using f.
+func makeTransform(ms *Set, typ string, f func(net string) int, w ...[][]float64) *Transform {
+	t := &Transform{Type: typ, ModelDim: 2, Classes: make(map[string]int), W: w}
+	for _, h := range ms.Nets {
+		for i := 1; i < h.ns-1; i++ {
+			t.Classes[gaussKey(h.Name, i, 0)] = f(h.Name)
+		}
+	}
+	return t
+}
+
+func globalClass(net string) int { return 0 }
+
+// The first three nets are in class 0.
+func netClass(net string) int {
+	if net < "m3" {
+		return 0
+	}
+	return 1
+}
+
+// Generates sequences with alignments using the transformed model.
+func adaptationData(t *testing.T, m *Model, xf *Transform, n int) obsSlice {
+	am, err := xf.Apply(m)
+	fatalIf(t, err)
+	gen := newChainGen(rand.New(rand.NewSource(66)), true, 3, am.Set.Nets...)
+	var data obsSlice
+	for i := 0; i < n; i++ {
+		obs, _ := gen.next("oid-" + fi(i))
+		data = append(data, obs)
+	}
+	return data
+}
+
+func makeBaseModel(t *testing.T, options ...Option) *Model {
+	ms := makeRandomSet(t, rand.New(rand.NewSource(55)), 6, 6, 2)
+	return NewModel(append([]Option{OSet(ms), OAssign(DirectAssigner{})}, options...)...)
+}
+
+// Compares the transforms at the means of the Gaussians in class r. The bias
+// can't be estimated accurately because the means are far from the origin. The
+// error must be small compared to the standard deviation.
+func compareTransforms(t *testing.T, m *Model, class func(net string) int, r int, expected, actual [][]float64) {
+	for _, h := range m.Set.Nets {
+		if class(h.Name) != r {
+			continue
+		}
+		for i := 1; i < h.ns-1; i++ {
+			g := h.B[i].(*gm.Model)
+			y0, y1 := applyW(expected, g.Mean), applyW(actual, g.Mean)
+			for j := range y0 {
+				if math.Abs(y0[j]-y1[j]) > g.StdDev[j]/4 {
+					t.Errorf("wrong transform, net:%s, state:%d, expected %v, got %v", h.Name, i, y0, y1)
+				}
+			}
+		}
+	}
+}
+
+// Returns W [1 x].
+func applyW(w [][]float64, x []float64) []float64 {
+	y := make([]float64, len(w))
+	for i := range w {
+		y[i] = w[i][0]
+		for j, v := range x {
+			y[i] += w[i][j+1] * v
+		}
+	}
+	return y
+}
+
+func TestMLLR(t *testing.T) {
+
+	m := makeBaseModel(t, UseAlignments(true))
+	mean := append([]float64{}, m.Set.Nets[0].B[1].(*gm.Model).Mean...)
+	data := adaptationData(t, m, makeTransform(m.Set, MLLR, globalClass, testW0), 300)
+
+	xf, err := m.EstimateTransform(data, MLLR)
+	fatalIf(t, err)
+	compareTransforms(t, m, globalClass, 0, testW0, xf.W[0])
+
+	// The base model must not change.
+	gjoa.CompareSliceFloat(t, mean, m.Set.Nets[0].B[1].(*gm.Model).Mean, "base model was modified", 1e-12)
+
+	// The adapted model must score the data better.
+	am, err := xf.Apply(m)
+	fatalIf(t, err)
+	if ll, all := totalLogProb(m, data), totalLogProb(am, data); all <= ll {
+		t.Fatalf("adapted log prob %f must be greater than %f", all, ll)
+	}
+	g := am.Set.Nets[0].B[1].(*gm.Model)
+	gjoa.CompareSliceFloat(t, m.Set.Nets[0].B[1].(*gm.Model).StdDev, g.StdDev, "sd must not change", 1e-12)
+}
+
+func TestFMLLR(t *testing.T) {
+
+	m := makeBaseModel(t, UseAlignments(true))
+	data := adaptationData(t, m, makeTransform(m.Set, FMLLR, globalClass, testW0), 300)
+
+	xf, err := m.EstimateTransform(data, FMLLR)
+	fatalIf(t, err)
+	compareTransforms(t, m, globalClass, 0, testW0, xf.W[0])
+
+	am, err := xf.Apply(m)
+	fatalIf(t, err)
+	if ll, all := totalLogProb(m, data), totalLogProb(am, data); all <= ll {
+		t.Fatalf("adapted log prob %f must be greater than %f", all, ll)
+	}
+	if err := am.Set.Nets[0].B[1].Estimate(); err == nil {
+		t.Fatalf("expected error, fmllr output PDF can't be trained")
+	}
+}
+
+func TestRegressionClasses(t *testing.T) {
+
+	for _, typ := range []string{MLLR, FMLLR} {
+		m := makeBaseModel(t, UseAlignments(true))
+		data := adaptationData(t, m, makeTransform(m.Set, typ, netClass, testW0, testW1), 400)
+
+		f := func(net string, state, component int) int { return netClass(net) }
+		xf, err := m.EstimateTransform(data, typ, RegressionClasses(f))
+		fatalIf(t, err)
+		if len(xf.W) != 2 {
+			t.Fatalf("%s, wrong num classes, expected 2, got %d", typ, len(xf.W))
+		}
+		compareTransforms(t, m, netClass, 0, testW0, xf.W[0])
+		compareTransforms(t, m, netClass, 1, testW1, xf.W[1])
+
+		// Not enough data, all classes use the global transform.
+		xf, err = m.EstimateTransform(data, typ, RegressionClasses(f), MinOccupancy(1e9))
+		fatalIf(t, err)
+		for i := range xf.W[0] {
+			gjoa.CompareSliceFloat(t, xf.W[0][i], xf.W[1][i], "expected global transform", 1e-12)
+		}
+	}
+}
+
+func TestTransformFB(t *testing.T) {
+
+	// Use forward-backward and clustered regression classes.
+	m := makeBaseModel(t)
+	data := adaptationData(t, m, makeTransform(m.Set, MLLR, globalClass, testW0), 100)
+	xf, err := m.EstimateTransform(data, MLLR, NumClasses(2))
+	fatalIf(t, err)
+	if len(xf.W) != 2 {
+		t.Fatalf("wrong num classes, expected 2, got %d", len(xf.W))
+	}
+	am, err := xf.Apply(m)
+	fatalIf(t, err)
+	if ll, all := totalLogProb(m, data), totalLogProb(am, data); all <= ll {
+		t.Fatalf("adapted log prob %f must be greater than %f", all, ll)
+	}
+}
+
+func TestWriteReadTransform(t *testing.T) {
+
+	m := makeBaseModel(t, UseAlignments(true))
+	data := adaptationData(t, m, makeTransform(m.Set, FMLLR, globalClass, testW0), 50)
+	xf, err := m.EstimateTransform(data, FMLLR)
+	fatalIf(t, err)
+
+	var b bytes.Buffer
+	fatalIf(t, xf.Write(&b))
+	xf1, err := ReadTransform(&b)
+	fatalIf(t, err)
+	for i := range xf.W[0] {
+		gjoa.CompareSliceFloat(t, xf.W[0][i], xf1.W[0][i], "wrong transform", 1e-12)
+	}
+
+	am, err := xf.Apply(m)
+	fatalIf(t, err)
+	am1, err := xf1.Apply(m)
+	fatalIf(t, err)
+	gjoa.CompareFloats(t, totalLogProb(am, data), totalLogProb(am1, data), "wrong log prob", 1e-9)
+
+	// Full covariance is not supported.
+	fm := makeBaseModel(t, UseAlignments(true))
+	fm.Set.Nets[1].B[1] = gm.NewModel(2, gm.Diag(false))
+	if _, err := fm.EstimateTransform(data, MLLR); err == nil {
+		t.Fatalf("expected error, full covariance is not supported")
+	}
+	if _, err := xf.Apply(fm); err == nil {
+		t.Fatalf("expected error, full covariance is not supported")
+	}
+
+	// Transform doesn't match the model.
+	delete(xf1.Classes, gaussKey("m0", 1, 0))
+	if _, err := xf1.Apply(m); err == nil {
+		t.Fatalf("expected error, missing regression class")
+	}
+}
+
+func totalLogProb(m *Model, data obsSlice) float64 {
+	var ll float64
+	for _, o := range data {
+		p, err := m.LabelLogProb(o)
+		if err != nil {
+			panic(err)
+		}
+		ll += p
+	}
+	return ll
+}
@@ -13,6 +13,8 @@ package hmm
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"math"
 	"math/rand"
@@ -23,6 +25,7 @@ import (
 	"github.com/akualab/gjoa/model"
 	"github.com/akualab/ju"
 	"github.com/golang/glog"
+	"github.com/gonum/floats"
 )
 
 const (
@@ -48,12 +51,20 @@ type Model struct {
 	updateTP      bool
 	updateOP      bool
 	useAlignments bool
+	useViterbi    bool
+	numWorkers    int
 
 	logProb         float64
 	updateFailCount int
 	updateCount     int
 }
 
+func init() {
+	model.Register(reflect.TypeOf(Model{}).String(), func(b []byte) (model.Modeler, error) {
+		return ReadJSON(bytes.NewReader(b))
+	})
+}
+
 // Option type is used to pass options to NewModel().
 type Option func(*Model)
 
@@ -81,6 +92,9 @@ func NewModel(options ...Option) *Model {
 	if m.Set.size() > 1 && m.assigner == nil {
 		glog.Fatalf("need assigner to create an HMM model with more than one network - use OAssign option to specify assigner")
 	}
+	if m.useAlignments && m.useViterbi {
+		glog.Fatalf("the UseAlignments and UseViterbi options can't be used together")
+	}
 	if m.useAlignments {
 		m.updateTP = false
 	}
@@ -93,43 +107,9 @@ func NewModel(options ...Option) *Model {
 
 // UpdateOne updates model using a single weighted sample.
 func (m *Model) UpdateOne(o model.Obs, w float64) {
-	m.updateCount++
-	// We create a chain of hmms to update stats for an obs sequence.
-	// The chain is released once the update is done.
-	// The assigner has the logic for mapping labels to a chain of models.
-	chain, err := m.Set.chainFromAssigner(o, m.assigner)
-	if err != nil {
-		glog.Warningf("skipping, failed to update hmm model stats, oid:%s, error: %s", o.ID(), err)
-		return
-	}
-
-	// Use the forward-backward algorithm to compute counts.
-	if m.useAlignments {
-		err = chain.updateFromAlignments()
-		if err != nil {
-			m.updateFailCount++
-			if glog.V(6) {
-				glog.Fatal(err)
-			}
-			glog.Warning(err)
-			return
-		}
-	} else {
-		err = chain.update()
-		if err != nil {
-			m.updateFailCount++
-			if glog.V(6) {
-				glog.Fatal(err)
-			}
-			glog.Warning(err)
-			return
-		}
-
-		// Print log(prob(O/model))\
-		p := chain.beta.At(0, 0, 0)
-		m.logProb += p
-		glog.V(1).Infof("update hmm stats, oid:%s, logProb:%.2f total:%.2f", o.ID(), p, m.logProb)
-	}
+	u := &update{obs: o, w: w}
+	m.expect(u)
+	m.accumulate(u)
 }
 
 // SetFlags sets various flags in the model.
@@ -146,7 +126,29 @@ func (m *Model) SetFlags(useAlignments, updateTP, updateOP bool) {
 }
 
 // Update updates sufficient statistics using an observation stream.
+// Sequences that fail to update are skipped. Returns an error with the
+// number of failed sequences once the stream is consumed or the error
+// that ended the stream early.
 func (m *Model) Update(x model.Observer, w func(model.Obs) float64) error {
+	c, e := x.ObsChan()
+	if e != nil {
+		return e
+	}
+	count, failCount := m.updateCount, m.updateFailCount
+	if m.numWorkers > 1 {
+		m.updateParallel(c, w)
+	} else {
+		for v := range c {
+			m.UpdateOne(v, w(v))
+		}
+	}
+	count, failCount = m.updateCount-count, m.updateFailCount-failCount
+	if err := model.StreamErr(x); err != nil {
+		return err
+	}
+	if failCount > 0 {
+		return fmt.Errorf("failed to update hmm stats for %d out of %d sequences", failCount, count)
+	}
 	return nil
 }
 
@@ -161,34 +163,121 @@ func (m *Model) Estimate() error {
 	return nil
 }
 
-// LogProb returns the log probability.
-func (m *Model) LogProb(observation interface{}) float64 {
+// TotalLogProb returns the sum of the log probabilities of the sequences used
+// to update the model since the last call to Clear.
+func (m *Model) TotalLogProb() float64 {
+	return m.logProb
+}
+
+// Merge adds the accumulators of src to the model. See Set.Merge for details.
+func (m *Model) Merge(src model.Modeler) error {
 
-	// TODO
-	//obs := observation.([][]float64)
-	return 0
+	s, ok := src.(*Model)
+	if !ok {
+		return fmt.Errorf("name:%s, can't merge model of type %T", m.ModelName, src)
+	}
+	err := m.Set.Merge(s.Set)
+	if err != nil {
+		return fmt.Errorf("name:%s, %s", m.ModelName, err)
+	}
+	m.logProb += s.logProb
+	m.updateCount += s.updateCount
+	m.updateFailCount += s.updateFailCount
+	return nil
+}
+
+// LogProb returns log P(obs|model) using the forward algorithm.
+// The observation must be of type model.FloatObsSequence. When the observation
+// has a label, the chain of hmms is created using the assigner. Otherwise, all
+// the networks in the set compete with equal prior probability.
+// Returns -Inf if the observation can't be scored.
+func (m *Model) LogProb(o model.Obs) float64 {
+
+	if o.Label() == nil || len(o.Label().String()) == 0 {
+		p, err := m.Set.logProbAll(o)
+		if err != nil {
+			glog.Warningf("failed to compute log prob, oid:%s, error: %s", o.ID(), err)
+		}
+		return p
+	}
+	p, err := m.LabelLogProb(o)
+	if err != nil {
+		glog.Warningf("failed to compute log prob, oid:%s, error: %s", o.ID(), err)
+	}
+	return p
+}
+
+// LabelLogProb returns log P(obs|label) using the forward algorithm. The chain
+// of networks is created from the label of the observation using the assigner.
+// This is the forced alignment score used for training. Returns an error if the
+// observation has no label or the chain can't be created.
+func (m *Model) LabelLogProb(o model.Obs) (float64, error) {
+
+	if o.Label() == nil || len(o.Label().String()) == 0 {
+		return math.Inf(-1), fmt.Errorf("oid:%s, observation has no label", o.ID())
+	}
+	chain, err := m.Set.chainFromAssigner(o, m.assigner)
+	if err != nil {
+		return math.Inf(-1), err
+	}
+	return chain.forward(), nil
 }
 
 // Prob returns the probability.
-func (m *Model) Prob(observation interface{}) float64 {
+func (m *Model) Prob(o model.Obs) float64 {
+	return math.Exp(m.LogProb(o))
+}
+
+// Predict returns the name of the network with the highest
+// log probability for each observation sequence.
+func (m *Model) Predict(x model.Observer) ([]model.Labeler, error) {
 
-	obs := observation.([][]float64)
-	return math.Exp(m.LogProb(obs))
+	c, e := x.ObsChan()
+	if e != nil {
+		return nil, e
+	}
+	var labels []model.Labeler
+	for o := range c {
+		scores, err := m.Set.netScores(o)
+		if err != nil {
+			return nil, err
+		}
+		best := m.Set.Nets[floats.MaxIdx(scores)]
+		labels = append(labels, model.SimpleLabel(best.Name))
+	}
+	if err := model.StreamErr(x); err != nil {
+		return nil, err
+	}
+	return labels, nil
 }
 
-func (m *Model) Random(r *rand.Rand) (interface{}, []int, error) {
+// Sample returns an observation sequence generated by a network
+// selected at random from the model set.
+func (m *Model) Sample(r *rand.Rand) model.Obs {
 
-	//	return model.generator.Next(model.maxGenLen)
-	return nil, nil, nil
+	gen := newChainGen(r, true, 1, m.Set.Nets...)
+	obs, _ := gen.next("")
+	return obs
+}
+
+// SampleChan returns a channel with "size" observation sequences generated
+// by the model. The sequence ends when the channel closes.
+func (m *Model) SampleChan(r *rand.Rand, size int) <-chan model.Obs {
+
+	return model.SampleChanContext(context.Background(), m, r, size)
 }
 
 // Dim is the dimensionality of the observation vector.
+// Returns the dimension of the first output PDF found in the model set.
 func (m *Model) Dim() int {
 
-	// if hmm.ObsModels[0] == nil {
-	// 	glog.Fatalf("No observation model available.")
-	// }
-	// return hmm.ObsModels[0].Dim()
+	for _, net := range m.Set.Nets {
+		for i := 1; i < net.ns-1; i++ {
+			if net.B[i] != nil {
+				return net.B[i].Dim()
+			}
+		}
+	}
 	return 0
 }
 
@@ -272,26 +361,53 @@ func UseAlignments(flag bool) Option {
 	}
 }
 
+// UseViterbi option. When true, counts are computed using the best path found by the
+// Viterbi algorithm instead of the forward-backward algorithm. Both the output probability
+// densities and the transition probabilities are estimated. Can't be used with UseAlignments.
+func UseViterbi(flag bool) Option {
+	return func(m *Model) {
+		m.useViterbi = flag
+	}
+}
+
+// NumWorkers sets the number of goroutines used by Update to compute the
+// forward-backward or Viterbi counts. Sequences are processed concurrently
+// and the counts are accumulated in the order of the observation stream, so
+// the result is identical to using one worker. The LogProb method of the
+// output PDFs must be safe for concurrent use. Default is one worker.
+func NumWorkers(n int) Option {
+	return func(m *Model) { m.numWorkers = n }
+}
+
 // IO
 
 // ReadJSON unmarshals json data from an io.Reader anc creates a new HMM model.
-func ReadJSON(r io.Reader) (*Model, error) {
-	m := NewModel()
-	err := ju.ReadJSON(r, m)
+// The model uses a DirectAssigner unless the OAssign option is provided.
+// Options are applied after setting the model set read from r.
+func ReadJSON(r io.Reader, options ...Option) (*Model, error) {
+	var v struct {
+		ModelName string `json:"name"`
+		Set       *Set   `json:"hmm_set"`
+	}
+	err := ju.ReadJSON(r, &v)
 	if err != nil {
 		return nil, err
 	}
-	return m, nil
+	if v.Set == nil || v.Set.size() == 0 {
+		return nil, fmt.Errorf("hmm model [%s] has no networks", v.ModelName)
+	}
+	opts := []Option{OSet(v.Set), Name(v.ModelName), OAssign(DirectAssigner{})}
+	return NewModel(append(opts, options...)...), nil
 }
 
 // ReadJSONFile unmarshals json data from a file.
-func ReadJSONFile(fn string) (*Model, error) {
+func ReadJSONFile(fn string, options ...Option) (*Model, error) {
 	f, err := os.Open(fn)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	return ReadJSON(f)
+	return ReadJSON(f, options...)
 }
 
 // WriteJSON writes HMM model to an io.Writer.
@@ -6,8 +6,10 @@
 package hmm
 
 import (
+	"bytes"
 	"math/rand"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -82,6 +84,143 @@ func TestTrainBasic(t *testing.T) {
 	t.Logf("hmm  g1: %+v, g2:%+v", h.B[1], h.B[2])
 }
 
+// obsSlice implements the model.Observer interface.
+type obsSlice []model.Obs
+
+func (s obsSlice) ObsChan() (<-chan model.Obs, error) {
+	c := make(chan model.Obs, len(s))
+	for _, o := range s {
+		c <- o
+	}
+	close(c)
+	return c, nil
+}
+
+func TestUpdate(t *testing.T) {
+
+	r := rand.New(rand.NewSource(33))
+	m0 := makeHMM(t)
+	gen := newGenerator(r, false, m0.Set.Nets[0])
+	var data obsSlice
+	for i := 0; i < 20; i++ {
+		obs, _ := gen.next("oid-" + fi(i))
+		data = append(data, obs)
+	}
+
+	// Accumulate using UpdateOne.
+	m1 := makeHMM(t)
+	m1.Clear()
+	for _, o := range data {
+		m1.UpdateOne(o, 2.0)
+	}
+	h1 := m1.Set.Nets[0]
+	tracc := h1.TrAcc.Copy()
+	occacc := h1.OccAcc.Copy()
+	nsamples := h1.B[1].(*gm.Model).NSamples
+
+	// Accumulate using Update with weight.
+	m2 := makeHMM(t)
+	m2.Clear()
+	fatalIf(t, m2.Update(data, model.Weight(2.0)))
+	h2 := m2.Set.Nets[0]
+	gjoa.CompareSliceFloat(t, tracc.Data, h2.TrAcc.Data, "TrAcc mismatch", smallNumber)
+	gjoa.CompareSliceFloat(t, occacc.Data, h2.OccAcc.Data, "OccAcc mismatch", smallNumber)
+	gjoa.CompareFloats(t, nsamples, h2.B[1].(*gm.Model).NSamples, "NSamples mismatch", smallNumber)
+
+	// Weight of one must produce half the counts.
+	m2.Clear()
+	fatalIf(t, m2.Update(data, model.NoWeight))
+	gjoa.CompareFloats(t, nsamples/2, h2.B[1].(*gm.Model).NSamples, "NSamples mismatch", smallNumber)
+
+	// Failed sequences are reported.
+	m2.Clear()
+	bad := append(data, model.NewFloatObsSequence(nil, model.SimpleLabel(""), "empty"))
+	if err := m2.Update(bad, model.NoWeight); err == nil {
+		t.Fatal("expected error, got nil - obs sequence has no data")
+	}
+}
+
+func TestUpdateStreamError(t *testing.T) {
+
+	// The second sequence can't be decoded.
+	data := `{"vectors":[[0.1],[0.3],[1.1],[5.5]],"id":"s0"}
+{"vectors":[[0.1],[0.3],"x"],"id":"s1"}
+{"vectors":[[0.1],[0.3],[1.1],[5.5]],"id":"s2"}
+`
+	obs, err := model.NewSeqObserver(strings.NewReader(data))
+	fatalIf(t, err)
+	m := makeHMM(t)
+	m.Clear()
+	err = m.Update(obs, model.NoWeight)
+	if err == nil {
+		t.Fatal("expected error, got nil - stream was truncated")
+	}
+	t.Log(err)
+	if m.updateCount != 1 {
+		t.Fatalf("wrong update count - got %d, expected 1", m.updateCount)
+	}
+}
+
+// Merged accumulators from data shards must match the accumulators
+// computed using all the data.
+func TestMerge(t *testing.T) {
+
+	newModel := func() *Model {
+		ms := makeRandomSet(t, rand.New(rand.NewSource(55)), 3, 4, 2)
+		m := NewModel(OSet(ms), OAssign(DirectAssigner{}))
+		m.Clear()
+		return m
+	}
+	gen := newChainGen(rand.New(rand.NewSource(66)), true, 3, newModel().Set.Nets...)
+	var data obsSlice
+	for i := 0; i < 30; i++ {
+		obs, _ := gen.next("oid-" + fi(i))
+		data = append(data, obs)
+	}
+	m := newModel()
+	fatalIf(t, m.Update(data, model.NoWeight))
+
+	// The reducer reads the statistics of the second shard from a file.
+	m1, m2 := newModel(), newModel()
+	fatalIf(t, m1.Update(data[:10], model.NoWeight))
+	fatalIf(t, m2.Update(data[10:], model.NoWeight))
+	var b bytes.Buffer
+	fatalIf(t, m2.WriteJSON(&b))
+	m3, err := ReadJSON(&b)
+	fatalIf(t, err)
+	reducer := newModel()
+	fatalIf(t, reducer.Merge(m1))
+	fatalIf(t, reducer.Merge(m3))
+
+	for k, h := range m.Set.Nets {
+		h1 := reducer.Set.Nets[k]
+		gjoa.CompareSliceFloat(t, h.TrAcc.Data, h1.TrAcc.Data, "TrAcc mismatch", smallNumber)
+		gjoa.CompareSliceFloat(t, h.OccAcc.Data, h1.OccAcc.Data, "OccAcc mismatch", smallNumber)
+		for i := 1; i < h.ns-1; i++ {
+			g, g1 := h.B[i].(*gm.Model), h1.B[i].(*gm.Model)
+			gjoa.CompareFloats(t, g.NSamples, g1.NSamples, "NSamples mismatch", smallNumber)
+			gjoa.CompareSliceFloat(t, g.Sumx, g1.Sumx, "Sumx mismatch", smallNumber)
+			gjoa.CompareSliceFloat(t, g.Sumxsq, g1.Sumxsq, "Sumxsq mismatch", smallNumber)
+		}
+	}
+
+	// Sets must have the same networks.
+	ms := makeRandomSet(t, rand.New(rand.NewSource(55)), 2, 4, 2)
+	if err := m.Merge(NewModel(OSet(ms), OAssign(DirectAssigner{}))); err == nil {
+		t.Fatal("expected error, got nil - sets have different networks")
+	}
+
+	// An output PDF in the last net doesn't match. The model must not change.
+	bad := newModel()
+	last := bad.Set.Nets[bad.Set.size()-1]
+	last.B[1] = gm.NewModel(3)
+	tr := append([]float64(nil), m.Set.Nets[0].TrAcc.Data...)
+	if err := m.Merge(bad); err == nil {
+		t.Fatal("expected error, got nil - output PDFs have different dims")
+	}
+	gjoa.CompareSliceFloat(t, tr, m.Set.Nets[0].TrAcc.Data, "accumulators changed after failed merge", 1e-12)
+}
+
 // should be equivalent to training a single gaussian, great for debugging.
 func TestSingleState(t *testing.T) {
 
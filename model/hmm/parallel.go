@@ -0,0 +1,129 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hmm
+
+import (
+	"sync"
+
+	"github.com/akualab/gjoa/model"
+	"github.com/golang/glog"
+)
+
+// update holds the state needed to update the model using one observation
+// sequence. The expectation step (chain creation, forward-backward, or best path)
+// only reads the model and can run concurrently. The accumulation step writes
+// to the accumulators of the nets and output PDFs and must run serially.
+type update struct {
+	obs   model.Obs
+	w     float64
+	chain *chain
+	err   error
+
+	// Viterbi best path.
+	graph   *vgraph
+	trace   []int
+	logProb float64
+
+	// Closed when the expectation step is done.
+	done chan struct{}
+}
+
+// expect computes the chain probabilities needed to update the model.
+func (m *Model) expect(u *update) {
+
+	ch, err := m.Set.chainFromAssigner(u.obs, m.assigner)
+	if err != nil {
+		u.err = err
+		return
+	}
+	u.chain = ch
+	switch {
+	case m.useViterbi:
+		u.graph, u.trace, u.logProb, u.err = ch.bestPath(false)
+	case m.useAlignments:
+		// Nothing to compute, counts come from the alignments.
+	default:
+		ch.fb()
+	}
+}
+
+// accumulate adds the counts computed in the expectation step to the model.
+func (m *Model) accumulate(u *update) {
+
+	m.updateCount++
+	if u.chain == nil {
+		m.updateFailCount++
+		glog.Warningf("skipping, failed to update hmm model stats, oid:%s, error: %s", u.obs.ID(), u.err)
+		return
+	}
+
+	// Compute counts using the best path, the alignments in the obs object,
+	// or the forward-backward algorithm.
+	var p float64
+	err := u.err
+	if err == nil {
+		switch {
+		case m.useViterbi:
+			u.chain.accumulateViterbi(u.graph, u.trace, u.w)
+			p = u.logProb
+		case m.useAlignments:
+			err = u.chain.updateFromAlignments(u.w)
+		default:
+			err = u.chain.accumulate(u.w)
+			p = u.chain.beta.At(0, 0, 0)
+		}
+	}
+	if err != nil {
+		m.updateFailCount++
+		if glog.V(6) {
+			glog.Fatal(err)
+		}
+		glog.Warning(err)
+		return
+	}
+	if m.useAlignments {
+		return
+	}
+	m.logProb += p
+	glog.V(1).Infof("update hmm stats, oid:%s, logProb:%.2f total:%.2f", u.obs.ID(), p, m.logProb)
+}
+
+// updateParallel runs the expectation step concurrently using m.numWorkers goroutines
+// and accumulates the counts in the order of the observations in channel c.
+func (m *Model) updateParallel(c <-chan model.Obs, w func(model.Obs) float64) {
+
+	jobs := make(chan *update, m.numWorkers)
+	queue := make(chan *update, 2*m.numWorkers) // in observation order
+
+	go func() {
+		for o := range c {
+			u := &update{obs: o, w: w(o), done: make(chan struct{})}
+			queue <- u
+			jobs <- u
+		}
+		close(jobs)
+		close(queue)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(m.numWorkers)
+	for i := 0; i < m.numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for u := range jobs {
+				m.expect(u)
+				close(u.done)
+			}
+		}()
+	}
+
+	for u := range queue {
+		<-u.done
+		m.accumulate(u)
+		u.chain = nil // release memory
+	}
+	wg.Wait()
+}
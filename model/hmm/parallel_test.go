@@ -0,0 +1,71 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hmm
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/akualab/gjoa/model"
+	gm "github.com/akualab/gjoa/model/gaussian"
+)
+
+// Parallel training must produce exactly the same model as serial training.
+func TestNumWorkers(t *testing.T) {
+
+	newSet := func() *Set {
+		return makeRandomSet(t, rand.New(rand.NewSource(55)), 5, 5, 3)
+	}
+	r := rand.New(rand.NewSource(66))
+	gen := newChainGen(r, true, 4, newSet().Nets...)
+	var data obsSlice
+	for i := 0; i < 50; i++ {
+		obs, _ := gen.next("oid-" + fi(i))
+		data = append(data, obs)
+	}
+	// Add a sequence that fails to update.
+	data = append(data[:10], append(obsSlice{model.NewFloatObsSequence(nil, model.SimpleLabel("m0"), "empty")}, data[10:]...)...)
+
+	for _, viterbi := range []bool{false, true} {
+		var models []*Model
+		for _, n := range []int{1, 2, 4} {
+			m := NewModel(OSet(newSet()), OAssign(DirectAssigner{}), UseViterbi(viterbi), NumWorkers(n))
+			for iter := 0; iter < 3; iter++ {
+				m.Clear()
+				if err := m.Update(data, model.NoWeight); err == nil {
+					t.Fatalf("expected error for the empty sequence")
+				}
+				fatalIf(t, m.Estimate())
+			}
+			models = append(models, m)
+		}
+
+		m1 := models[0]
+		for _, m2 := range models[1:] {
+			n := m2.numWorkers
+			if m1.logProb != m2.logProb || m1.updateCount != m2.updateCount || m1.updateFailCount != m2.updateFailCount {
+				t.Fatalf("viterbi:%t, workers:%d, serial and parallel stats don't match - logProb:%f/%f, count:%d/%d, fail:%d/%d",
+					viterbi, n, m1.logProb, m2.logProb, m1.updateCount, m2.updateCount, m1.updateFailCount, m2.updateFailCount)
+			}
+			for k, h1 := range m1.Set.Nets {
+				h2 := m2.Set.Nets[k]
+				for i, v := range h1.A.Data {
+					if v != h2.A.Data[i] {
+						t.Fatalf("viterbi:%t, workers:%d, net:%s, transition probs don't match", viterbi, n, h1.Name)
+					}
+				}
+				for i := 1; i < h1.ns-1; i++ {
+					g1, g2 := h1.B[i].(*gm.Model), h2.B[i].(*gm.Model)
+					for j, v := range g1.Mean {
+						if v != g2.Mean[j] || g1.StdDev[j] != g2.StdDev[j] {
+							t.Fatalf("viterbi:%t, workers:%d, net:%s, state:%d, gaussian params don't match", viterbi, n, h1.Name, i)
+						}
+					}
+				}
+			}
+		}
+	}
+}
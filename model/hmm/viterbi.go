@@ -0,0 +1,333 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hmm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/akualab/gjoa/model"
+	"github.com/golang/glog"
+)
+
+// Path is the best path found by the Viterbi algorithm.
+type Path struct {
+	// States is the best state sequence. There is one state name per
+	// observation using the format "name-N" where name is the net name
+	// and N is the state index.
+	States []string
+	// LogProb is the log probability of the best path.
+	LogProb float64
+	// Alignment has one node per net in the best path. The state-level
+	// alignment nodes are the children of the net nodes.
+	Alignment []*model.ANode
+	// Position in the chain of each net node in the alignment.
+	positions []int
+}
+
+// Viterbi finds the best path for an observation sequence of type model.FloatObsSequence.
+//
+// When an assigner is provided, the search is constrained to the chain of nets
+// assigned to the labels of the observation (forced alignment). When the assigner
+// is nil, any sequence of nets in the set is allowed and each net has equal
+// prior probability (decoding). Nets with a transition from entry to exit states
+// are not used for decoding.
+//
+// The alignment in the result can be passed directly to FloatObsSequence.SetAlignment
+// to train using the UseAlignments option.
+func (ms *Set) Viterbi(obs model.Obs, assigner Assigner) (*Path, error) {
+
+	if assigner != nil {
+		ch, err := ms.chainFromAssigner(obs, assigner)
+		if err != nil {
+			return nil, err
+		}
+		return ch.viterbi(false)
+	}
+
+	var nets []*Net
+	for _, net := range ms.Nets {
+		if !isTeeModel(net) {
+			nets = append(nets, net)
+		}
+	}
+	if len(nets) == 0 {
+		return nil, fmt.Errorf("no nets available for decoding")
+	}
+	ch, err := ms.chainFromNets(obs, nets...)
+	if err != nil {
+		return nil, err
+	}
+	return ch.viterbi(true)
+}
+
+// vnode is a node in the search graph. Nodes correspond to the state i
+// of the net in position q of the chain. The backoff node used for
+// decoding has q=-1.
+type vnode struct {
+	q, i  int
+	null  bool
+	preds []varc
+}
+
+// varc is an arc in the search graph.
+type varc struct {
+	from int
+	w    float64
+}
+
+// vgraph is the search graph used by the Viterbi algorithm.
+type vgraph struct {
+	nodes      []vnode
+	start, end int
+	// Non-emitting nodes in topological order.
+	nullOrder []int
+}
+
+// searchGraph converts the chain to a search graph. If loop is false, nets are
+// connected in the order of the chain. If loop is true, the exit state of every
+// net is connected to the entry states of all nets using a backoff node.
+func (ch *chain) searchGraph(loop bool) (*vgraph, error) {
+
+	g := &vgraph{}
+	offset := make([]int, ch.nq)
+	for q, h := range ch.hmms {
+		offset[q] = len(g.nodes)
+		exit := ch.ns[q] - 1
+		for j := 0; j <= exit; j++ {
+			node := vnode{q: q, i: j, null: j == 0 || j == exit}
+			for i := 0; i <= j && i < exit; i++ {
+				if i == j && node.null {
+					continue
+				}
+				w := h.A.At(i, j)
+				if w > math.Inf(-1) {
+					node.preds = append(node.preds, varc{from: offset[q] + i, w: w})
+				}
+			}
+			g.nodes = append(g.nodes, node)
+		}
+	}
+
+	if loop {
+		backoff := len(g.nodes)
+		logp := -math.Log(float64(ch.nq))
+		node := vnode{q: -1, null: true}
+		for q := range ch.hmms {
+			node.preds = append(node.preds, varc{from: offset[q] + ch.ns[q] - 1})
+			entry := &g.nodes[offset[q]]
+			entry.preds = append(entry.preds, varc{from: backoff, w: logp})
+		}
+		g.nodes = append(g.nodes, node)
+		g.start, g.end = backoff, backoff
+	} else {
+		for q := 1; q < ch.nq; q++ {
+			entry := &g.nodes[offset[q]]
+			entry.preds = append(entry.preds, varc{from: offset[q] - 1})
+		}
+		g.start, g.end = 0, offset[ch.nq-1]+ch.ns[ch.nq-1]-1
+	}
+
+	// Sort non-emitting nodes. Arcs between non-emitting nodes
+	// don't consume observations.
+	visited := make([]int, len(g.nodes)) // 0: new, 1: in progress, 2: done
+	var visit func(n int) error
+	visit = func(n int) error {
+		switch visited[n] {
+		case 1:
+			return fmt.Errorf("search graph has a loop of non-emitting nodes")
+		case 2:
+			return nil
+		}
+		visited[n] = 1
+		for _, arc := range g.nodes[n].preds {
+			if g.nodes[arc.from].null {
+				if err := visit(arc.from); err != nil {
+					return err
+				}
+			}
+		}
+		visited[n] = 2
+		g.nullOrder = append(g.nullOrder, n)
+		return nil
+	}
+	for n, node := range g.nodes {
+		if node.null {
+			if err := visit(n); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return g, nil
+}
+
+// bestPath runs the Viterbi search and returns the search graph, the sequence
+// of nodes in the best path including non-emitting nodes, and the log probability
+// of the best path.
+// Let delta(tau, n) be the best score to reach node n after consuming tau observations.
+// Arcs to emitting nodes consume one observation, arcs to non-emitting nodes don't.
+func (ch *chain) bestPath(loop bool) (*vgraph, []int, float64, error) {
+
+	g, err := ch.searchGraph(loop)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	nn := len(g.nodes)
+	nobs := ch.nobs
+	delta := make([][]float64, nobs+1)
+	bp := make([][]int, nobs+1)
+
+	for tau := 0; tau <= nobs; tau++ {
+		delta[tau] = make([]float64, nn)
+		bp[tau] = make([]int, nn)
+		for n := range g.nodes {
+			delta[tau][n] = math.Inf(-1)
+			bp[tau][n] = -1
+		}
+		if tau == 0 {
+			delta[0][g.start] = 0
+		} else {
+			// Emitting nodes.
+			for n, node := range g.nodes {
+				if node.null {
+					continue
+				}
+				for _, arc := range node.preds {
+					v := delta[tau-1][arc.from] + arc.w
+					if v > delta[tau][n] {
+						delta[tau][n] = v
+						bp[tau][n] = arc.from
+					}
+				}
+				delta[tau][n] += ch.likelihoods.At(node.q, node.i, tau-1)
+			}
+		}
+		// Non-emitting nodes.
+		for _, n := range g.nullOrder {
+			for _, arc := range g.nodes[n].preds {
+				v := delta[tau][arc.from] + arc.w
+				if v > delta[tau][n] {
+					delta[tau][n] = v
+					bp[tau][n] = arc.from
+				}
+			}
+		}
+	}
+
+	logProb := delta[nobs][g.end]
+	if logProb == math.Inf(-1) {
+		return nil, nil, 0, fmt.Errorf("oid:%s, no path found, num vectors:%d, chain len:%d", ch.obs.ID(), nobs, ch.nq)
+	}
+	glog.V(2).Infof("oid:%s, viterbi log prob:%.2f, avg per obs:%.2f", ch.obs.ID(), logProb, logProb/float64(nobs))
+
+	// Backtrace.
+	var trace []int
+	n, tau := g.end, nobs
+	for n >= 0 {
+		trace = append(trace, n)
+		p := bp[tau][n]
+		if !g.nodes[n].null {
+			tau--
+		}
+		n = p
+	}
+	if tau != 0 {
+		return nil, nil, 0, fmt.Errorf("oid:%s, backtrace failed at observation [%d]", ch.obs.ID(), tau)
+	}
+	for i, j := 0, len(trace)-1; i < j; i, j = i+1, j-1 {
+		trace[i], trace[j] = trace[j], trace[i]
+	}
+	return g, trace, logProb, nil
+}
+
+// viterbi finds the best path in the chain.
+func (ch *chain) viterbi(loop bool) (*Path, error) {
+
+	g, trace, logProb, err := ch.bestPath(loop)
+	if err != nil {
+		return nil, err
+	}
+
+	// Assign a unique instance number to each net in the best path.
+	nobs := ch.nobs
+	frames := make([]int, 0, nobs)
+	instances := make([]int, 0, nobs)
+	instance := 0
+	for _, n := range trace {
+		node := g.nodes[n]
+		if !node.null {
+			frames = append(frames, n)
+			instances = append(instances, instance)
+		} else if node.i == 0 && node.q >= 0 {
+			instance++
+		}
+	}
+
+	path := &Path{
+		States:  make([]string, nobs),
+		LogProb: logProb,
+	}
+	for t, n := range frames {
+		node := g.nodes[n]
+		path.States[t] = stateName(ch.hmms[node.q], node.i)
+	}
+
+	// Create net nodes and append state nodes as children.
+	for start := 0; start < nobs; {
+		end := start + 1
+		for end < nobs && instances[end] == instances[start] {
+			end++
+		}
+		q := g.nodes[frames[start]].q
+		net := ch.hmms[q]
+		anode := model.NewANode(start, end, net.Name, nil)
+		for t := start; t < end; t++ {
+			if t == end-1 || frames[t+1] != frames[t] {
+				anode.AppendChild(t+1, path.States[t])
+			}
+		}
+		path.Alignment = append(path.Alignment, anode)
+		path.positions = append(path.positions, q)
+		start = end
+	}
+	return path, nil
+}
+
+// accumulateViterbi adds hard counts for the best path to the accumulators of
+// the nets and output PDFs in the chain. Each observation is assigned to a
+// single state and each transition in the best path is counted once. Counts
+// are scaled by weight w. The backoff node of a decoding graph is skipped.
+func (ch *chain) accumulateViterbi(g *vgraph, trace []int, w float64) {
+
+	t := 0
+	for k, n := range trace {
+		node := g.nodes[n]
+		if node.q < 0 {
+			continue
+		}
+		h := ch.hmms[node.q]
+		if !node.null {
+			o := model.F64ToObs(ch.vectors[t], "")
+			h.B[node.i].UpdateOne(o, w)
+			t++
+		}
+		if k == 0 {
+			continue
+		}
+		// Arcs between nets in the chain are not counted.
+		prev := g.nodes[trace[k-1]]
+		if prev.q == node.q {
+			h.TrAcc.Inc(w, prev.i, node.i)
+			h.OccAcc.Inc(w, prev.i)
+		}
+	}
+}
+
+// stateName returns the name of state i in the net using the format "name-i".
+func stateName(net *Net, i int) string {
+	return net.Name + "-" + strconv.FormatInt(int64(i), 10)
+}
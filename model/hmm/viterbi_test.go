@@ -0,0 +1,252 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hmm
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/akualab/gjoa/model"
+	gm "github.com/akualab/gjoa/model/gaussian"
+)
+
+func makeRandomSet(t *testing.T, r *rand.Rand, numModels, maxNumStates, dim int) *Set {
+
+	ms, _ := NewSet()
+	for q := 0; q < numModels; q++ {
+		ns := int(r.Intn(maxNumStates-2) + 3)
+		id := "m" + strconv.FormatInt(int64(q), 10)
+		_, e := addRandomNet(r, ms, id, ns, dim)
+		fatalIf(t, e)
+	}
+	return ms
+}
+
+func TestViterbiAlign(t *testing.T) {
+
+	r := rand.New(rand.NewSource(444))
+	ms := makeRandomSet(t, r, 10, 6, 4)
+	gen := newChainGen(r, true, 5, ms.Nets...)
+
+	numErrors, n := 0, 0
+	for i := 0; i < 100; i++ {
+		obs, states := gen.next("oid-" + fi(i))
+		path, err := ms.Viterbi(obs, DirectAssigner{})
+		fatalIf(t, err)
+
+		if len(path.States) != len(states) {
+			t.Fatalf("length mismatch - got %d, expected %d", len(path.States), len(states))
+		}
+		for k, s := range states {
+			n++
+			if s != path.States[k] {
+				numErrors++
+			}
+		}
+
+		// Viterbi score can't be greater than the total probability.
+		ch, err := ms.chainFromAssigner(obs, DirectAssigner{})
+		fatalIf(t, err)
+		if path.LogProb > ch.forward()+smallNumber {
+			t.Fatalf("viterbi log prob:%f is greater than total log prob:%f", path.LogProb, ch.forward())
+		}
+
+		// Check alignment.
+		labels := strings.Split(obs.Label().String(), ",")
+		if len(path.Alignment) != len(labels) {
+			t.Fatalf("num net nodes is %d, expected %d", len(path.Alignment), len(labels))
+		}
+		root := model.NewANode(0, len(states), "root", nil)
+		root.Children = path.Alignment
+		if !root.IsValid() {
+			t.Fatalf("invalid alignment: %v", root.Alignment())
+		}
+		for k, node := range path.Alignment {
+			if node.Name != labels[k] {
+				t.Fatalf("wrong net name in alignment - got %s, expected %s", node.Name, labels[k])
+			}
+		}
+	}
+	t.Logf("num_states:%d, num_errors:%d", n, numErrors)
+	if float64(numErrors)/float64(n) > 0.01 {
+		t.Fatalf("alignment error rate is too high - num_states:%d, num_errors:%d", n, numErrors)
+	}
+}
+
+func TestViterbiDecode(t *testing.T) {
+
+	r := rand.New(rand.NewSource(444))
+	ms := makeRandomSet(t, r, 10, 6, 4)
+	gen := newChainGen(r, true, 5, ms.Nets...)
+
+	// Consecutive instances of the same net may be decoded as a single
+	// instance so we compare the state sequences.
+	numErrors, n := 0, 0
+	for i := 0; i < 100; i++ {
+		obs, states := gen.next("oid-" + fi(i))
+		path, err := ms.Viterbi(obs, nil)
+		fatalIf(t, err)
+
+		if len(path.States) != len(states) {
+			t.Fatalf("length mismatch - got %d, expected %d", len(path.States), len(states))
+		}
+		for k, s := range states {
+			n++
+			if s != path.States[k] {
+				numErrors++
+			}
+		}
+		for _, node := range path.Alignment {
+			if !node.IsValid() {
+				t.Fatalf("invalid alignment: %v", node)
+			}
+		}
+	}
+	t.Logf("num_states:%d, num_errors:%d", n, numErrors)
+	if float64(numErrors)/float64(n) > 0.01 {
+		t.Fatalf("decoding error rate is too high - num_states:%d, num_errors:%d", n, numErrors)
+	}
+}
+
+func TestViterbiTrain(t *testing.T) {
+
+	r := rand.New(rand.NewSource(444))
+	ms := makeRandomSet(t, r, 5, 5, 4)
+	gen := newChainGen(r, true, 5, ms.Nets...)
+	hmm := NewModel(OSet(ms), OAssign(DirectAssigner{}), UseAlignments(true))
+
+	hmm.Clear()
+	for i := 0; i < 20; i++ {
+		obs, _ := gen.next("oid-" + fi(i))
+		path, err := ms.Viterbi(obs, DirectAssigner{})
+		fatalIf(t, err)
+		obs.SetAlignment(path.Alignment)
+		hmm.UpdateOne(obs, 1.0)
+	}
+	if hmm.updateFailCount > 0 {
+		t.Fatalf("failed to update %d sequences using viterbi alignments", hmm.updateFailCount)
+	}
+	fatalIf(t, hmm.Estimate())
+}
+
+// Accumulating the best path of a decoding graph must skip the backoff node.
+func TestAccumulateViterbiLoop(t *testing.T) {
+
+	r := rand.New(rand.NewSource(444))
+	ms := makeRandomSet(t, r, 5, 5, 4)
+	gen := newChainGen(r, true, 5, ms.Nets...)
+	obs, states := gen.next("oid-0")
+	ch, err := ms.chainFromNets(obs, ms.Nets...)
+	fatalIf(t, err)
+	g, trace, _, err := ch.bestPath(true)
+	fatalIf(t, err)
+	ms.reset()
+	ch.accumulateViterbi(g, trace, 1.0)
+
+	var n float64
+	for _, h := range ms.Nets {
+		for _, b := range h.B {
+			if b != nil {
+				n += b.(*gm.Model).NSamples
+			}
+		}
+	}
+	if int(n) != len(states) {
+		t.Fatalf("wrong number of accumulated observations - got %f, expected %d", n, len(states))
+	}
+}
+
+func TestViterbiLR(t *testing.T) {
+
+	initChainFB(t)
+	ms2, e := NewSet(hmm0, hmm1)
+	fatalIf(t, e)
+
+	// Single net chain. Compare with a brute force search.
+	ch, err := ms2.chainFromNets(xobs, hmm1)
+	fatalIf(t, err)
+	path, err := ch.viterbi(false)
+	fatalIf(t, err)
+
+	var best float64 = math.Inf(-1)
+	n := len(ch.vectors)
+	var search func(tt, prev int, score float64)
+	search = func(tt, prev int, score float64) {
+		if tt == n {
+			score += hmm1.A.At(prev, hmm1.ns-1)
+			if score > best {
+				best = score
+			}
+			return
+		}
+		for j := 1; j < hmm1.ns-1; j++ {
+			search(tt+1, j, score+hmm1.A.At(prev, j)+ch.likelihoods.At(0, j, tt))
+		}
+	}
+	search(0, 0, 0)
+	if math.Abs(best-path.LogProb) > smallNumber {
+		t.Fatalf("viterbi log prob:%f, expected:%f", path.LogProb, best)
+	}
+}
+
+func TestUseViterbi(t *testing.T) {
+
+	r := rand.New(rand.NewSource(33))
+	ms := makeRandomSet(t, r, 5, 5, 4)
+	gen := newChainGen(r, true, 5, ms.Nets...)
+	var data []model.Obs
+	for i := 0; i < 200; i++ {
+		obs, _ := gen.next("oid-" + fi(i))
+		data = append(data, obs)
+	}
+	a0 := ms.Nets[0].A.Copy()
+	hmm := NewModel(OSet(ms), OAssign(DirectAssigner{}), UseViterbi(true))
+
+	prev := math.Inf(-1)
+	for iter := 0; iter < 4; iter++ {
+		hmm.Clear()
+		for _, obs := range data {
+			hmm.UpdateOne(obs, 1.0)
+		}
+		if hmm.updateFailCount > 0 {
+			t.Fatalf("failed to update %d sequences using viterbi training", hmm.updateFailCount)
+		}
+		t.Logf("iter:%d, logProb:%f", iter, hmm.logProb)
+
+		// The score of the best path can't decrease.
+		if hmm.logProb < prev-0.001*math.Abs(prev) {
+			t.Fatalf("iter:%d, log prob decreased from %f to %f", iter, prev, hmm.logProb)
+		}
+		prev = hmm.logProb
+		fatalIf(t, hmm.Estimate())
+	}
+
+	// Transition probabilities must be reestimated.
+	changed := false
+	for _, net := range ms.Nets {
+		exit := net.ns - 1
+		for i := 0; i < exit; i++ {
+			var sum float64
+			for j := 0; j < net.ns; j++ {
+				sum += math.Exp(net.A.At(i, j))
+			}
+			if math.Abs(sum-1) > smallNumber {
+				t.Fatalf("net:%s, transition probs from state %d add up to %f", net.Name, i, sum)
+			}
+		}
+	}
+	for i := range a0.Data {
+		if math.Abs(a0.Data[i]-ms.Nets[0].A.Data[i]) > smallNumber {
+			changed = true
+		}
+	}
+	if !changed {
+		t.Fatalf("transition probabilities were not updated")
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package kmeans implements the k-means clustering algorithm.
+
+Initial means are selected using the k-means++ seeding algorithm. Means are refined
+using Lloyd iterations until no vector changes cluster or the maximum number of
+iterations is reached. Once the algorithm converges, the model has the mean, variance,
+and weight of each cluster which can be used to initialize mixture models.
+*/
+package kmeans
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+
+	"github.com/akualab/gjoa/floatx"
+	"github.com/akualab/gjoa/model"
+	"github.com/golang/glog"
+	"github.com/gonum/floats"
+)
+
+const (
+	defaultMaxIter = 20
+	defaultSeed    = 33
+)
+
+// Model is a k-means clustering model.
+type Model struct {
+	Type       string      `json:"type"`
+	K          int         `json:"k"`
+	ModelDim   int         `json:"dim"`
+	Means      [][]float64 `json:"means,omitempty"`
+	Variances  [][]float64 `json:"variances,omitempty"`
+	Weights    []float64   `json:"weights,omitempty"`
+	Distortion float64     `json:"distortion"`
+	Iterations int         `json:"iterations"`
+	maxIter    int
+	seed       int64
+}
+
+// Option type is used to pass options to NewModel().
+type Option func(*Model)
+
+// NewModel creates a new k-means model with k clusters.
+func NewModel(k int, options ...Option) *Model {
+
+	m := &Model{
+		K:       k,
+		maxIter: defaultMaxIter,
+		seed:    defaultSeed,
+	}
+	m.Type = reflect.TypeOf(*m).String()
+
+	// Set options.
+	for _, option := range options {
+		option(m)
+	}
+	return m
+}
+
+// Cluster runs the k-means algorithm using the vectors in the observer.
+// The observer values must be of type []float64. All the vectors are kept in memory.
+func (m *Model) Cluster(x model.Observer) error {
+
+	data, err := readVectors(x)
+	if err != nil {
+		return err
+	}
+	if len(data) < m.K {
+		return fmt.Errorf("num vectors [%d] is less than the num clusters [%d]", len(data), m.K)
+	}
+	m.ModelDim = len(data[0])
+	r := rand.New(rand.NewSource(m.seed))
+	m.seedMeans(data, r)
+
+	assign := make([]int, len(data))
+	for i := range assign {
+		assign[i] = -1
+	}
+	for m.Iterations = 0; m.Iterations < m.maxIter; m.Iterations++ {
+		changed := 0
+		m.Distortion = 0
+		for i, v := range data {
+			k, d := m.Nearest(v)
+			if k != assign[i] {
+				assign[i] = k
+				changed++
+			}
+			m.Distortion += d
+		}
+		glog.V(2).Infof("kmeans iter:%d, changed:%d, distortion:%f", m.Iterations, changed, m.Distortion)
+		if changed == 0 {
+			break
+		}
+		m.updateMeans(data, assign)
+	}
+	// The last assignment pass may leave clusters empty.
+	m.fillEmpty(data, assign, clusterCounts(assign, m.K))
+	m.estimate(data, assign)
+	return nil
+}
+
+// Nearest returns the index of the nearest mean and the squared euclidean distance.
+func (m *Model) Nearest(v []float64) (int, float64) {
+
+	best, min := 0, math.Inf(1)
+	for k, mean := range m.Means {
+		d := sqDist(v, mean)
+		if d < min {
+			best, min = k, d
+		}
+	}
+	return best, min
+}
+
+// Dim is the dimensionality of the vectors.
+func (m *Model) Dim() int { return m.ModelDim }
+
+// Selects initial means using the k-means++ algorithm. The first mean is selected
+// uniformly at random. Each new mean is selected with probability proportional to the
+// squared distance to the nearest mean already selected.
+func (m *Model) seedMeans(data [][]float64, r *rand.Rand) {
+
+	m.Means = make([][]float64, 0, m.K)
+	m.Means = append(m.Means, copyOf(data[r.Intn(len(data))]))
+	dist := make([]float64, len(data))
+	for i, v := range data {
+		dist[i] = sqDist(v, m.Means[0])
+	}
+	for len(m.Means) < m.K {
+		sum := floats.Sum(dist)
+		next := r.Intn(len(data))
+		if sum > 0 {
+			target := r.Float64() * sum
+			for i, d := range dist {
+				target -= d
+				if target <= 0 && d > 0 {
+					next = i
+					break
+				}
+			}
+		}
+		mean := copyOf(data[next])
+		m.Means = append(m.Means, mean)
+		for i, v := range data {
+			dist[i] = math.Min(dist[i], sqDist(v, mean))
+		}
+	}
+}
+
+// Computes the means using the current assignments. Empty clusters
+// are filled using fillEmpty.
+func (m *Model) updateMeans(data [][]float64, assign []int) {
+
+	counts := clusterCounts(assign, m.K)
+	floatx.Clear2D(m.Means)
+	for i, v := range data {
+		floats.Add(m.Means[assign[i]], v)
+	}
+	for k, mean := range m.Means {
+		if counts[k] > 0 {
+			floats.Scale(1/counts[k], mean)
+		}
+	}
+	m.fillEmpty(data, assign, counts)
+}
+
+// Assigns a vector to each empty cluster and uses it as the mean. The vector is
+// the one with the largest distance to its mean among the clusters with more than
+// one vector, so no other cluster becomes empty. When there are fewer distinct
+// vectors than clusters, some clusters get duplicate vectors. Requires at least
+// K vectors.
+func (m *Model) fillEmpty(data [][]float64, assign []int, counts []float64) {
+
+	for k := range m.Means {
+		if counts[k] > 0 {
+			continue
+		}
+		far, max := 0, -1.0
+		for i, v := range data {
+			if counts[assign[i]] < 2 {
+				continue
+			}
+			if d := sqDist(v, m.Means[assign[i]]); d > max {
+				far, max = i, d
+			}
+		}
+		glog.V(2).Infof("kmeans empty cluster:%d, reseed with vector:%d", k, far)
+		copy(m.Means[k], data[far])
+		counts[assign[far]]--
+		assign[far] = k
+		counts[k] = 1
+	}
+}
+
+// Returns the number of vectors assigned to each cluster.
+func clusterCounts(assign []int, k int) []float64 {
+
+	counts := make([]float64, k)
+	for _, a := range assign {
+		counts[a]++
+	}
+	return counts
+}
+
+// Computes the mean, variance, and weight of each cluster.
+func (m *Model) estimate(data [][]float64, assign []int) {
+
+	counts := make([]float64, m.K)
+	floatx.Clear2D(m.Means)
+	m.Variances = floatx.MakeFloat2D(m.K, m.ModelDim)
+	for i, v := range data {
+		k := assign[i]
+		floats.Add(m.Means[k], v)
+		for j, x := range v {
+			m.Variances[k][j] += x * x
+		}
+		counts[k]++
+	}
+	m.Weights = make([]float64, m.K)
+	for k := range m.Means {
+		m.Weights[k] = counts[k] / float64(len(data))
+		if counts[k] == 0 {
+			continue
+		}
+		floats.Scale(1/counts[k], m.Means[k])
+		for j, mu := range m.Means[k] {
+			m.Variances[k][j] = m.Variances[k][j]/counts[k] - mu*mu
+		}
+	}
+}
+
+// Reads all the vectors from the observer. Stops the producer if a vector
+// is not valid.
+func readVectors(x model.Observer) ([][]float64, error) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := model.ObsChanContext(ctx, x)
+	if err != nil {
+		return nil, err
+	}
+	var data [][]float64
+	for o := range c {
+		v, ok := o.Value().([]float64)
+		if !ok {
+			return nil, fmt.Errorf("oid:%s, observation value must be of type []float64", o.ID())
+		}
+		if len(data) > 0 && len(v) != len(data[0]) {
+			return nil, fmt.Errorf("oid:%s, vector dim is [%d], expected [%d]", o.ID(), len(v), len(data[0]))
+		}
+		data = append(data, v)
+	}
+	if err := model.StreamErr(x); err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no vectors found in observer")
+	}
+	return data, nil
+}
+
+func sqDist(x, y []float64) float64 {
+	d := floats.Distance(x, y, 2)
+	return d * d
+}
+
+func copyOf(v []float64) []float64 {
+	c := make([]float64, len(v))
+	copy(c, v)
+	return c
+}
+
+// Options
+
+// Seed is an option to set the seed of the random number generator used for k-means++ seeding.
+func Seed(seed int64) Option {
+	return func(m *Model) { m.seed = seed }
+}
+
+// MaxIter is an option to set the maximum number of Lloyd iterations.
+func MaxIter(n int) Option {
+	return func(m *Model) { m.maxIter = n }
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kmeans
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/model"
+)
+
+const epsilon = 0.05
+
+func makeData(t *testing.T, r *rand.Rand, n int, means, sds [][]float64) *model.FloatObserver {
+
+	values := make([][]float64, 0, n*len(means))
+	for i := 0; i < n; i++ {
+		for k := range means {
+			values = append(values, model.RandNormalVector(r, means[k], sds[k]))
+		}
+	}
+	fo, err := model.NewFloatObserver(values, make([]model.SimpleLabel, len(values)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fo
+}
+
+func TestKMeans(t *testing.T) {
+
+	means := [][]float64{{0, 0}, {5, 5}, {-5, 5}}
+	sds := [][]float64{{0.5, 0.5}, {1, 0.5}, {0.3, 0.3}}
+	r := rand.New(rand.NewSource(33))
+	fo := makeData(t, r, 10000, means, sds)
+
+	km := NewModel(3, Seed(1))
+	err := km.Cluster(fo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("iterations:%d, distortion:%f", km.Iterations, km.Distortion)
+
+	// Clusters can be in any order.
+	for k, mean := range means {
+		c, _ := km.Nearest(mean)
+		t.Logf("cluster:%d, mean:%v, var:%v, weight:%f", c, km.Means[c], km.Variances[c], km.Weights[c])
+		gjoa.CompareSliceFloat(t, mean, km.Means[c], "wrong mean", epsilon)
+		gjoa.CompareSliceFloat(t, []float64{sds[k][0] * sds[k][0], sds[k][1] * sds[k][1]},
+			km.Variances[c], "wrong variance", epsilon)
+		if !gjoa.Comparef64(1.0/3.0, km.Weights[c], epsilon) {
+			t.Errorf("wrong weight for cluster %d - expected: %f, got: %f", c, 1.0/3.0, km.Weights[c])
+		}
+	}
+}
+
+func TestKMeansErrors(t *testing.T) {
+
+	fo, err := model.NewFloatObserver([][]float64{{1, 2}, {3, 4}}, make([]model.SimpleLabel, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewModel(3).Cluster(fo); err == nil {
+		t.Fatalf("expected error when num vectors is less than k")
+	}
+
+	fo, err = model.NewFloatObserver([][]float64{{1, 2}, {3}}, make([]model.SimpleLabel, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewModel(1).Cluster(fo); err == nil {
+		t.Fatalf("expected error when vectors have different dimensions")
+	}
+}
+
+func TestKMeansDuplicates(t *testing.T) {
+
+	// Only two distinct vectors, three clusters. No cluster can be empty.
+	values := [][]float64{{1, 1}, {1, 1}, {1, 1}, {2, 2}, {2, 2}, {2, 2}}
+	fo, err := model.NewFloatObserver(values, make([]model.SimpleLabel, len(values)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	km := NewModel(3)
+	err = km.Cluster(fo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, w := range km.Weights {
+		if w == 0 {
+			t.Fatalf("cluster %d is empty", k)
+		}
+	}
+}
+
+func TestKMeansAllDuplicates(t *testing.T) {
+
+	// All the vectors are the same.
+	values := [][]float64{{1, 1}, {1, 1}, {1, 1}, {1, 1}, {1, 1}}
+	fo, err := model.NewFloatObserver(values, make([]model.SimpleLabel, len(values)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	km := NewModel(3, MaxIter(5))
+	err = km.Cluster(fo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum float64
+	for k, w := range km.Weights {
+		if w == 0 {
+			t.Fatalf("cluster %d is empty", k)
+		}
+		gjoa.CompareSliceFloat(t, values[0], km.Means[k], "wrong mean", 1e-12)
+		sum += w
+	}
+	if math.Abs(sum-1) > 1e-12 {
+		t.Fatalf("weights don't add up to one: %v", km.Weights)
+	}
+}
+
+// chanObserver sends the values using an unbuffered channel and closes done
+// when all the values are sent.
+type chanObserver struct {
+	values [][]float64
+	done   chan struct{}
+}
+
+func (o *chanObserver) ObsChan() (<-chan model.Obs, error) {
+	c := make(chan model.Obs)
+	go func() {
+		defer close(o.done)
+		defer close(c)
+		for _, v := range o.values {
+			c <- model.F64ToObs(v, "")
+		}
+	}()
+	return c, nil
+}
+
+// The producer must not block when a vector is not valid.
+func TestKMeansStopProducer(t *testing.T) {
+
+	x := &chanObserver{
+		values: [][]float64{{1, 2}, {3}, {5, 6}, {7, 8}},
+		done:   make(chan struct{}),
+	}
+	if err := NewModel(1).Cluster(x); err == nil {
+		t.Fatalf("expected error when vectors have different dimensions")
+	}
+	select {
+	case <-x.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the producer is blocked")
+	}
+}
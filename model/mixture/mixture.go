@@ -0,0 +1,347 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package mixture implements a mixture model whose components can be any model.Modeler.
+
+Parameters are estimated using the EM algorithm. In the E-step, the posterior
+probability of each component is computed using the component's LogProb method. The
+components are updated with the observation weighted by the posterior probability
+using UpdateOne. In the M-step, the mixture weights are estimated using the posterior
+sums and the components are estimated using Estimate.
+
+Components can be of any type, for example, full-covariance Gaussians or HMMs for
+sequence clustering. The JSON representation includes the type of each component.
+To read a model, the packages that implement the components must be imported.
+*/
+package mixture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/akualab/gjoa/floatx"
+	"github.com/akualab/gjoa/model"
+	"github.com/golang/glog"
+	"github.com/gonum/floats"
+)
+
+// Model is a mixture of models.
+type Model struct {
+	Type         string          `json:"type"`
+	ModelName    string          `json:"name"`
+	ModelDim     int             `json:"dim"`
+	NSamples     float64         `json:"nsamples"`
+	NComponents  int             `json:"num_components"`
+	PosteriorSum []float64       `json:"posterior_sum,omitempty"`
+	Weights      []float64       `json:"-"`
+	LogWeights   []float64       `json:"weights,omitempty"`
+	Likelihood   float64         `json:"likelihood"`
+	Components   []model.Modeler `json:"components,omitempty"`
+	Iteration    int             `json:"iteration"`
+	tmpProbs     []float64
+}
+
+func init() {
+	model.Register(reflect.TypeOf(Model{}).String(), func(b []byte) (model.Modeler, error) {
+		return Read(bytes.NewReader(b))
+	})
+}
+
+// Option type is used to pass options to NewModel().
+type Option func(*Model)
+
+// NewModel creates a new mixture model. Use the Components option to
+// set the mixture components.
+func NewModel(options ...Option) *Model {
+
+	m := &Model{
+		ModelName: "Mixture",
+	}
+	m.Type = reflect.TypeOf(*m).String()
+
+	// Set options.
+	for _, option := range options {
+		option(m)
+	}
+	if len(m.Components) == 0 {
+		glog.Fatalf("need components to create a mixture model - use the Components option")
+	}
+	m.NComponents = len(m.Components)
+	m.ModelDim = m.Components[0].Dim()
+	m.tmpProbs = make([]float64, m.NComponents)
+	if len(m.PosteriorSum) == 0 {
+		m.PosteriorSum = make([]float64, m.NComponents)
+	}
+
+	// Initialize weights.
+	// Caller may pass weight, log(weights), or no weights.
+	switch {
+
+	case len(m.LogWeights) > 0 && len(m.Weights) > 0:
+		glog.Fatal("options not allowed: provide only one of LogWeights or Weights")
+
+	case len(m.LogWeights) == 0 && len(m.Weights) == 0:
+		m.LogWeights = make([]float64, m.NComponents)
+		floatx.Apply(floatx.SetValueFunc(-math.Log(float64(m.NComponents))), m.LogWeights, nil)
+		m.Weights = make([]float64, m.NComponents)
+		floatx.Exp(m.Weights, m.LogWeights)
+
+	case len(m.LogWeights) > 0:
+		m.Weights = make([]float64, m.NComponents)
+		floatx.Exp(m.Weights, m.LogWeights)
+
+	case len(m.Weights) > 0:
+		m.LogWeights = make([]float64, m.NComponents)
+		floatx.Log(m.LogWeights, m.Weights)
+	}
+	if len(m.Weights) != m.NComponents {
+		glog.Fatalf("num weights [%d] doesn't match num components [%d]", len(m.Weights), m.NComponents)
+	}
+	return m
+}
+
+// Computes the log prob of each component plus the log weight and writes the
+// values to probs. Returns the log prob of the mixture.
+func (m *Model) logProbInternal(o model.Obs, probs []float64) float64 {
+
+	for i, c := range m.Components {
+		probs[i] = c.LogProb(o) + m.LogWeights[i]
+	}
+	return floats.LogSumExp(probs)
+}
+
+// LogProb returns log probability for observation.
+func (m *Model) LogProb(o model.Obs) float64 {
+	return m.logProbInternal(o, make([]float64, m.NComponents))
+}
+
+// Posteriors returns the posterior probability of each component given the observation.
+func (m *Model) Posteriors(o model.Obs) []float64 {
+
+	probs := make([]float64, m.NComponents)
+	logProb := m.logProbInternal(o, probs)
+	floatx.Apply(floatx.AddScalarFunc(-logProb), probs, nil)
+	floatx.Exp(probs, probs)
+	return probs
+}
+
+// Predict returns the name of the component with the highest posterior
+// probability for each observation.
+func (m *Model) Predict(x model.Observer) ([]model.Labeler, error) {
+
+	c, err := x.ObsChan()
+	if err != nil {
+		return nil, err
+	}
+	var labels []model.Labeler
+	probs := make([]float64, m.NComponents)
+	for o := range c {
+		m.logProbInternal(o, probs)
+		k := floats.MaxIdx(probs)
+		labels = append(labels, model.SimpleLabel(m.Components[k].Name()))
+	}
+	if err := model.StreamErr(x); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// UpdateOne updates sufficient statistics using one weighted observation.
+// Each component is updated using the observation weighted by the posterior
+// probability of the component.
+func (m *Model) UpdateOne(o model.Obs, w float64) {
+
+	logProb := m.logProbInternal(o, m.tmpProbs)
+	m.Likelihood += logProb
+	floatx.Apply(floatx.AddScalarFunc(-logProb+math.Log(w)), m.tmpProbs, nil)
+	floatx.Exp(m.tmpProbs, m.tmpProbs)
+	floats.Add(m.PosteriorSum, m.tmpProbs)
+	for i, c := range m.Components {
+		c.UpdateOne(o, m.tmpProbs[i])
+	}
+	m.NSamples += w
+}
+
+// Update updates sufficient statistics using observations.
+func (m *Model) Update(x model.Observer, w func(model.Obs) float64) error {
+	c, e := x.ObsChan()
+	if e != nil {
+		return e
+	}
+	for v := range c {
+		m.UpdateOne(v, w(v))
+	}
+	return model.StreamErr(x)
+}
+
+// Estimate computes model parameters using sufficient statistics.
+func (m *Model) Estimate() error {
+
+	if m.NSamples == 0 {
+		return fmt.Errorf("name:%s, can't estimate mixture weights using zero samples", m.ModelName)
+	}
+
+	// Estimate mixture weights.
+	floatx.Apply(floatx.ScaleFunc(1.0/m.NSamples), m.PosteriorSum, m.Weights)
+	floatx.Log(m.LogWeights, m.Weights)
+
+	// Estimate components.
+	for _, c := range m.Components {
+		err := c.Estimate()
+		if err != nil {
+			return err
+		}
+	}
+	m.Iteration++
+	return nil
+}
+
+// Clear resets sufficient statistics.
+func (m *Model) Clear() {
+
+	for _, c := range m.Components {
+		c.Clear()
+	}
+	floatx.Apply(floatx.SetValueFunc(0), m.PosteriorSum, nil)
+	m.NSamples = 0
+	m.Likelihood = 0
+}
+
+// Sample returns a sample drawn from a component selected using the mixture weights.
+func (m *Model) Sample(r *rand.Rand) model.Obs {
+	k := model.RandIntFromDist(m.Weights, r)
+	return m.Components[k].Sample(r)
+}
+
+// SampleChan returns a channel with samples generated by the mixture model.
+func (m *Model) SampleChan(r *rand.Rand, size int) <-chan model.Obs {
+
+	return model.SampleChanContext(context.Background(), m, r, size)
+}
+
+// Dim is the dimensionality of the observation vector.
+func (m *Model) Dim() int { return m.ModelDim }
+
+// Name returns the name of the model.
+func (m *Model) Name() string { return m.ModelName }
+
+// Options
+
+// Name is an option to set the model name.
+func Name(name string) Option {
+	return func(m *Model) { m.ModelName = name }
+}
+
+// Components sets the mixture components for the model.
+func Components(cs ...model.Modeler) Option {
+	return func(m *Model) { m.Components = cs }
+}
+
+// Weights sets the mixture weights for the model.
+func Weights(w []float64) Option {
+	return func(m *Model) { m.Weights = w }
+}
+
+// LogWeights sets the mixture weights for the model
+// using log(w) as the argument.
+func LogWeights(logw []float64) Option {
+	return func(m *Model) { m.LogWeights = logw }
+}
+
+// Clone create a clone of src.
+func Clone(src *Model) Option {
+	return func(m *Model) {
+		m.NSamples = src.NSamples
+		m.PosteriorSum = src.PosteriorSum
+		m.Likelihood = src.Likelihood
+		m.Iteration = src.Iteration
+	}
+}
+
+// IO
+
+// Read unmarshals json data from an io.Reader into a model struct.
+// Components are created using the model decoders registered in package model.
+func Read(r io.Reader) (*Model, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode components using their type.
+	var v struct {
+		Model
+		Components []json.RawMessage `json:"components"`
+	}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		return nil, err
+	}
+	cs := make([]model.Modeler, len(v.Components))
+	for i, raw := range v.Components {
+		cs[i], err = model.Decode(raw)
+		if err != nil {
+			return nil, fmt.Errorf("name:%s, component:%d, %s", v.ModelName, i, err)
+		}
+	}
+	m := NewModel(Clone(&v.Model), Name(v.ModelName), LogWeights(v.LogWeights), Components(cs...))
+	return m, nil
+}
+
+// ReadFile unmarshals json data from a file into a model struct.
+func ReadFile(fn string) (*Model, error) {
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	glog.Infof("Reading model from file %s.", fn)
+	return Read(f)
+}
+
+// Write writes the model to an io.Writer.
+func (m *Model) Write(w io.Writer) error {
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, e := w.Write(b)
+	return e
+}
+
+// WriteFile writes the model to file.
+func (m *Model) WriteFile(fn string) error {
+
+	e := os.MkdirAll(filepath.Dir(fn), 0755)
+	if e != nil {
+		return e
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ee := m.Write(f)
+	if ee != nil {
+		return ee
+	}
+
+	glog.Infof("Wrote model \"%s\" to file %s.", m.Name(), fn)
+	return nil
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mixture
+
+import (
+	"bytes"
+	"flag"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/akualab/gjoa"
+	"github.com/akualab/gjoa/model"
+	"github.com/akualab/gjoa/model/gaussian"
+	"github.com/akualab/gjoa/model/gmm"
+)
+
+const epsilon = 0.05
+
+func init() {
+	flag.Set("logtostderr", "true")
+	flag.Set("v", "0")
+}
+
+func makeMixture() *Model {
+
+	g0 := gaussian.NewModel(2, gaussian.Name("g0"), gaussian.Mean([]float64{1, 2}),
+		gaussian.Cov([][]float64{{0.5, 0.3}, {0.3, 0.4}}))
+	g1 := gaussian.NewModel(2, gaussian.Name("g1"), gaussian.Mean([]float64{4, 4}),
+		gaussian.Cov([][]float64{{1, -0.5}, {-0.5, 1}}))
+	return NewModel(Name("mix"), Components(g0, g1), Weights([]float64{0.6, 0.4}))
+}
+
+func TestLogProb(t *testing.T) {
+
+	// A mixture of diagonal Gaussians must match the GMM.
+	mean0, sd0 := []float64{1, 2}, []float64{0.3, 0.3}
+	mean1, sd1 := []float64{4, 4}, []float64{1, 1}
+	weights := []float64{0.6, 0.4}
+	newComponents := func() []*gaussian.Model {
+		return []*gaussian.Model{
+			gaussian.NewModel(2, gaussian.Mean(mean0), gaussian.StdDev(sd0)),
+			gaussian.NewModel(2, gaussian.Mean(mean1), gaussian.StdDev(sd1)),
+		}
+	}
+	g := gmm.NewModel(2, 2, gmm.Components(newComponents()), gmm.Weights(weights))
+	cs := newComponents()
+	m := NewModel(Components(cs[0], cs[1]), Weights(weights))
+
+	for _, v := range [][]float64{{1, 2}, {2.5, 3}, {4, 5}} {
+		obs := model.F64ToObs(v, "")
+		if !gjoa.Comparef64(g.LogProb(obs), m.LogProb(obs), 0.00001) {
+			t.Fatalf("wrong log prob. Expected: [%f], Got: [%f]", g.LogProb(obs), m.LogProb(obs))
+		}
+		post := m.Posteriors(obs)
+		if !gjoa.Comparef64(1, post[0]+post[1], 0.00001) {
+			t.Fatalf("posteriors don't add up to one: %v", post)
+		}
+	}
+}
+
+func TestTrainMixture(t *testing.T) {
+
+	m0 := makeMixture()
+	r := rand.New(rand.NewSource(33))
+	data := make([][]float64, 20000)
+	for i := range data {
+		data[i] = m0.Sample(r).Value().([]float64)
+	}
+	fo, err := model.NewFloatObserver(data, make([]model.SimpleLabel, len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c0 := gaussian.NewModel(2, gaussian.Name("c0"), gaussian.Mean([]float64{0, 1}), gaussian.StdDev([]float64{1, 1}), gaussian.Diag(false))
+	c1 := gaussian.NewModel(2, gaussian.Name("c1"), gaussian.Mean([]float64{5, 5}), gaussian.StdDev([]float64{1, 1}), gaussian.Diag(false))
+	m := NewModel(Components(c0, c1))
+	for iter := 0; iter < 20; iter++ {
+		m.Clear()
+		err := m.Update(fo, model.NoWeight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Estimate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("iter:%d, likelihood:%f, weights:%v", iter, m.Likelihood, m.Weights)
+	}
+
+	gjoa.CompareSliceFloat(t, m0.Weights, m.Weights, "wrong weights", epsilon)
+	for k := range m.Components {
+		g0 := m0.Components[k].(*gaussian.Model)
+		g := m.Components[k].(*gaussian.Model)
+		gjoa.CompareSliceFloat(t, g0.Mean, g.Mean, "wrong mean", epsilon)
+		for i := range g0.Cov {
+			gjoa.CompareSliceFloat(t, g0.Cov[i], g.Cov[i], "wrong cov", epsilon)
+		}
+	}
+
+	// Predict returns the name of the best component.
+	fo, err = model.NewFloatObserver([][]float64{{1, 2}, {4, 4}}, make([]model.SimpleLabel, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels, err := m.Predict(fo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels[0].String() != "c0" || labels[1].String() != "c1" {
+		t.Fatalf("wrong predictions: %v", labels)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+
+	// Mixture with components of different types.
+	g := gaussian.NewModel(2, gaussian.Name("g"), gaussian.Mean([]float64{1, 2}), gaussian.StdDev([]float64{1, 2}))
+	gm := gmm.NewModel(2, 2, gmm.Name("gm"))
+	m := NewModel(Name("mix"), Components(makeMixture(), g, gm), Weights([]float64{0.5, 0.3, 0.2}))
+
+	var b bytes.Buffer
+	err := m.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{"mixture.Model", "gaussian.Model", "gmm.Model"} {
+		if !strings.Contains(b.String(), `"type":"`+typ+`"`) {
+			t.Fatalf("missing type %s in json: %s", typ, b.String())
+		}
+	}
+
+	m1, err := Read(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m1.Name() != "mix" || m1.NComponents != 3 {
+		t.Fatalf("wrong model: %s", m1.Name())
+	}
+	if _, ok := m1.Components[0].(*Model); !ok {
+		t.Fatalf("component 0 has wrong type: %T", m1.Components[0])
+	}
+	if _, ok := m1.Components[2].(*gmm.Model); !ok {
+		t.Fatalf("component 2 has wrong type: %T", m1.Components[2])
+	}
+	gjoa.CompareSliceFloat(t, m.Weights, m1.Weights, "wrong weights", 0.00001)
+	obs := model.F64ToObs([]float64{2, 3}, "")
+	if !gjoa.Comparef64(m.LogProb(obs), m1.LogProb(obs), 0.00001) {
+		t.Fatalf("wrong log prob. Expected: [%f], Got: [%f]", m.LogProb(obs), m1.LogProb(obs))
+	}
+
+	// Unknown component type.
+	_, err = Read(strings.NewReader(`{"type":"mixture.Model","name":"x","components":[{"type":"foo.Model"}]}`))
+	if err == nil {
+		t.Fatalf("expected error for unknown component type")
+	}
+}
@@ -6,6 +6,7 @@
 package model
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 )
@@ -46,6 +47,17 @@ type Trainer interface {
 	Clear()
 }
 
+// A Merger type can combine sufficient statistics computed by other
+// models with the same structure. Use it to run the update step on
+// data shards, for example, in separate processes that write the
+// models to files. The reducer reads the models, merges them into one,
+// and calls Estimate.
+type Merger interface {
+
+	// Adds the sufficient statistics of src to the model.
+	Merge(src Modeler) error
+}
+
 // NoWeight is a parameter for the Update() method.
 // Applies a weight of one to the observations.
 var NoWeight = func(o Obs) float64 { return 1.0 }
@@ -98,13 +110,35 @@ type Observer interface {
 	ObsChan() (<-chan Obs, error)
 }
 
+// ErrObserver is an Observer that can fail after the stream starts, for example,
+// when the data is decoded from a file. The channel is closed when an error occurs
+// so consumers must check Err after the channel is closed to distinguish a complete
+// stream from a truncated one.
+type ErrObserver interface {
+	Observer
+
+	// Returns the error that ended the stream or nil if the stream ended normally.
+	Err() error
+}
+
+// StreamErr returns the error that ended the stream if x implements the
+// ErrObserver interface. Otherwise, returns nil. Call StreamErr after the
+// channel returned by ObsChan is closed.
+func StreamErr(x Observer) error {
+	if eo, ok := x.(ErrObserver); ok {
+		return eo.Err()
+	}
+	return nil
+}
+
 // The Sampler type generates random data using the model.
 type Sampler interface {
 	// Returns a sample drawn from the underlying distribution.
 	Sample(*rand.Rand) Obs
 
 	// Returns a sample of size "size" drawn from the underlying distribution.
-	// The sequence ends when the channel closes.
+	// The sequence ends when the channel closes. Use SampleChanContext to
+	// stop sampling before the end of the sequence.
 	SampleChan(r *rand.Rand, size int) <-chan Obs
 }
 
@@ -280,13 +314,22 @@ func NewFloatObserver(v [][]float64, lab []SimpleLabel) (*FloatObserver, error)
 
 // ObsChan implements the ObsChan method for the observer interface.
 func (fo FloatObserver) ObsChan() (<-chan Obs, error) {
+	return fo.ObsChanContext(context.Background())
+}
+
+// ObsChanContext is like ObsChan but stops sending when ctx is done.
+func (fo FloatObserver) ObsChanContext(ctx context.Context) (<-chan Obs, error) {
 
 	obsChan := make(chan Obs, 1000)
 	go func() {
+		defer close(obsChan)
 		for i := 0; i < fo.length; i++ {
-			obsChan <- NewFloatObs(fo.Values[i], fo.Labels[i])
+			select {
+			case obsChan <- NewFloatObs(fo.Values[i], fo.Labels[i]):
+			case <-ctx.Done():
+				return
+			}
 		}
-		close(obsChan)
 	}()
 
 	return obsChan, nil
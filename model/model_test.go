@@ -9,13 +9,13 @@ import (
 	"bufio"
 	"encoding/json"
 	"flag"
-	"fmt"
 	"io"
 	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/akualab/gjoa"
@@ -61,17 +61,19 @@ func TestSeqObserver(t *testing.T) {
 	}
 	i := 0
 	for v := range c {
-		if len(v.Value().([]float64)) != len(data[i].Vectors[0]) {
-			t.Fatalf("length mismatch - got %d, expected %d", len(v.Value().([]float64)), len(data[i].Vectors[0]))
+		seq := v.Value().([][]float64)
+		if len(seq) != 1 {
+			t.Fatalf("length mismatch - got %d, expected 1", len(seq))
 		}
-		for j, exp := range data[i].Vectors[0] {
-			got := v.Value().([]float64)[j]
-			if exp != got {
-				t.Logf("got: %5.3f, expected: %5.3f", exp, got)
-			}
+		gjoa.CompareSliceFloat(t, data[i].Vectors[0], seq[0], "value mismatch", 0.001)
+		if v.ID() != data[i].ID || v.Label().String() != data[i].Labels[0] {
+			t.Fatalf("wrong id or label - got %s/%s, expected %s/%s", v.ID(), v.Label(), data[i].ID, data[i].Labels[0])
 		}
 		i++
 	}
+	if i != numObs {
+		t.Fatalf("wrong num observations - got %d, expected %d, err: %v", i, numObs, obs.Err())
+	}
 
 	// Test sequence data.
 	reader = makeObsData(r, numObs, dim, maxSeqLen)
@@ -98,6 +100,9 @@ func TestSeqObserver(t *testing.T) {
 		}
 		i++
 	}
+	if i != numObs {
+		t.Fatalf("wrong num observations - got %d, expected %d, err: %v", i, numObs, obs.Err())
+	}
 
 	// Write to a file
 	reader = makeObsData(r, numObs, dim, maxSeqLen)
@@ -156,15 +161,131 @@ func TestSeqObserver(t *testing.T) {
 		}
 		i++
 	}
+	if i != numObs {
+		t.Fatalf("wrong num observations - got %d, expected %d, err: %v", i, numObs, obs.Err())
+	}
+	if obs.Err() != nil {
+		t.Fatal(obs.Err())
+	}
 	err = obs.Close()
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestSeqObserverAlignment(t *testing.T) {
+
+	data := `{"vectors":[[1],[2],[3]],"labels":["a","b"],"id":"s0","alignments":[{"s":0,"e":1,"n":"a"},{"s":1,"e":3,"n":"b"}]}
+{"vectors":[[4]],"labels":["c"],"id":"s1"}
+`
+	obs, err := NewSeqObserver(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := obs.ObsChan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []Obs
+	for v := range c {
+		res = append(res, v)
+	}
+	if obs.Err() != nil {
+		t.Fatal(obs.Err())
+	}
+	if len(res) != 2 {
+		t.Fatalf("wrong num observations - got %d, expected 2", len(res))
+	}
+	fos, ok := res[0].(FloatObsSequence)
+	if !ok {
+		t.Fatalf("wrong obs type %T", res[0])
+	}
+	if fos.ID() != "s0" || fos.Label().String() != "a,b" {
+		t.Fatalf("wrong id or label - got %s/%s", fos.ID(), fos.Label())
+	}
+	al := fos.Alignment()
+	if len(al) != 2 || al[1].Name != "b" || al[1].Start != 1 || al[1].End != 3 {
+		t.Fatalf("wrong alignment: %+v", al)
+	}
+	if res[1].(FloatObsSequence).Alignment() != nil {
+		t.Fatalf("expected nil alignment")
+	}
+}
+
+// Seq writes alignment trees and reads alignments written before trees were supported.
+func TestSeqAlignmentJSON(t *testing.T) {
+
+	root := makeTree()
+	b, err := json.Marshal(Seq{Vectors: make([][]float64, 15), Labels: []string{"a", "b", "c"}, ID: "s0", Alignments: root.Children})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v Seq
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root2 := NewANode(0, 15, "root", nil)
+	root2.Children = v.Alignments
+	if !root2.IsValid() || root2.Level() != root.Level() {
+		t.Fatalf("wrong alignment tree: %s", b)
+	}
+	if len(v.Alignments[1].Children) != 3 || v.Alignments[1].Children[2].Name != "b2" {
+		t.Fatalf("wrong children: %s", b)
+	}
+
+	// Flat alignment.
+	old := `{"vectors":[[1],[2],[3]],"labels":["a","b"],"id":"s0","alignments":[{"s":0,"e":1,"n":"a"},{"s":1,"e":3,"n":"b"}]}`
+	err = json.Unmarshal([]byte(old), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Alignments) != 2 || v.Alignments[1].Name != "b" || v.Alignments[1].End != 3 || v.Alignments[1].Children != nil {
+		t.Fatalf("wrong alignment: %+v", v.Alignments)
+	}
+}
+
+// SeqObserver must report errors found while streaming.
+var _ ErrObserver = (*SeqObserver)(nil)
+
+func TestSeqObserverError(t *testing.T) {
+
+	data := `{"vectors":[[1],[2]],"labels":["a"],"id":"s0"}
+{"vectors":[[1],"x"],"labels":["a"],"id":"s1"}
+{"vectors":[[3]],"labels":["a"],"id":"s2"}
+`
+	obs, err := NewSeqObserver(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := obs.ObsChan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range c {
+		n++
+	}
+	if n != 1 {
+		t.Fatalf("wrong num observations - got %d, expected 1", n)
+	}
+	if obs.Err() == nil {
+		t.Fatalf("expected decoding error")
+	}
+	if StreamErr(obs) != obs.Err() {
+		t.Fatalf("StreamErr must return the observer error")
+	}
+	fo, _ := NewFloatObserver([][]float64{{1}}, []SimpleLabel{""})
+	if StreamErr(fo) != nil {
+		t.Fatalf("expected nil error for an observer that doesn't implement ErrObserver")
+	}
+	t.Log(obs.Err())
+}
+
 type obsReader struct {
 	data []Seq
 	idx  int
+	buf  []byte // unread bytes of the current value
 }
 
 func (or *obsReader) Read(p []byte) (int, error) {
@@ -172,19 +293,19 @@ func (or *obsReader) Read(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
-	if or.idx >= len(or.data) {
-		return 0, io.EOF
-	}
-	b, err := json.Marshal(or.data[or.idx])
-	if err != nil {
-		return 0, err
-	}
-	or.idx++
-	if len(b) > len(p) {
-		return 0, fmt.Errorf("buffer too short b:%d > p:%d", len(b), len(p))
+	if len(or.buf) == 0 {
+		if or.idx >= len(or.data) {
+			return 0, io.EOF
+		}
+		b, err := json.Marshal(or.data[or.idx])
+		if err != nil {
+			return 0, err
+		}
+		or.idx++
+		or.buf = append(b, '\n')
 	}
-	b = append(b, '\n')
-	n := copy(p, b)
+	n := copy(p, or.buf)
+	or.buf = or.buf[n:]
 	return n, nil
 }
 
@@ -1,15 +1,17 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"strings"
-
-	"github.com/golang/glog"
+	"sync"
 )
 
 // Seq is a data format to represent a sequence of observation vectors.
-// We use it to read json data.
+// We use it to read json data. The alignments are written as trees using
+// the ATree type so the children of the nodes are preserved.
 type Seq struct {
 	Vectors    [][]float64 `json:"vectors"`
 	Labels     []string    `json:"labels"`
@@ -17,10 +19,45 @@ type Seq struct {
 	Alignments []*ANode    `json:"alignments,omitempty"`
 }
 
+// seqJSON is the JSON representation of Seq.
+type seqJSON struct {
+	Vectors    [][]float64 `json:"vectors"`
+	Labels     []string    `json:"labels"`
+	ID         string      `json:"id"`
+	Alignments []*ATree    `json:"alignments,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s Seq) MarshalJSON() ([]byte, error) {
+
+	v := seqJSON{Vectors: s.Vectors, Labels: s.Labels, ID: s.ID}
+	for _, a := range s.Alignments {
+		v.Alignments = append(v.Alignments, NewATree(a))
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (s *Seq) UnmarshalJSON(b []byte) error {
+
+	var v seqJSON
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+	*s = Seq{Vectors: v.Vectors, Labels: v.Labels, ID: v.ID}
+	for _, t := range v.Alignments {
+		s.Alignments = append(s.Alignments, t.ANode())
+	}
+	return nil
+}
+
 // SeqObserver implements an observer whose undelying values are of type
 // FloatObsSequence.
 type SeqObserver struct {
 	reader io.Reader
+	err    error
+	mu     sync.Mutex
 }
 
 // NewSeqObserver creates a new SeqObserver. The data is read as a stream of JSON objects
@@ -33,6 +70,8 @@ type SeqObserver struct {
 //   obs, _ = NewSeqObserver(r)      // Create observer that reads from file.
 //   c, _ = obs.ObsChan()            // Get channel. (See model.Observer interface.)
 //                                   // Obs type is model.FloatObsSequence.
+//   for o := range c { ... }        // Consume observations.
+//   err = obs.Err()                 // Check for decoding errors.
 //  _ = obs.Close()                  // Closes the underlying file reader.
 func NewSeqObserver(reader io.Reader) (*SeqObserver, error) {
 	so := &SeqObserver{
@@ -42,33 +81,65 @@ func NewSeqObserver(reader io.Reader) (*SeqObserver, error) {
 }
 
 // ObsChan implements the ObsChan method for the observer interface.
-// Each observation is a sequence of type model.FloatObsSequence.
+// Each observation is a sequence of type model.FloatObsSequence with the
+// labels joined using a comma and the alignment set when available.
+// If a value can't be decoded, the channel is closed and the error is
+// available using the Err method.
 func (so *SeqObserver) ObsChan() (<-chan Obs, error) {
+	return so.ObsChanContext(context.Background())
+}
+
+// ObsChanContext is like ObsChan but stops reading when ctx is done. The
+// context error is available using the Err method.
+func (so *SeqObserver) ObsChanContext(ctx context.Context) (<-chan Obs, error) {
 	obsChan := make(chan Obs, 1000)
+	so.setErr(nil)
 	go func() {
-
+		defer close(obsChan)
 		dec := json.NewDecoder(so.reader)
-		for {
+		for n := 0; ; n++ {
+			if err := ctx.Err(); err != nil {
+				so.setErr(err)
+				return
+			}
 			var v Seq
 			err := dec.Decode(&v)
 			if err == io.EOF {
-				break
+				return
 			}
 			if err != nil {
-				glog.Warning(err)
-				break
+				so.setErr(fmt.Errorf("item:%d, failed to decode sequence: %s", n, err))
+				return
+			}
+			fos := NewFloatObsSequence(v.Vectors, SimpleLabel(strings.Join(v.Labels, ",")), v.ID).(FloatObsSequence)
+			if len(v.Alignments) > 0 {
+				fos.SetAlignment(v.Alignments)
 			}
-			fos := NewFloatObsSequence(v.Vectors, SimpleLabel(strings.Join(v.Labels, ",")), v.ID)
-			if v.Alignments != nil && len(v.Alignments) > 0 {
-				x := fos.(FloatObsSequence)
-				x.SetAlignment(v.Alignments)
+			select {
+			case obsChan <- fos:
+			case <-ctx.Done():
+				so.setErr(ctx.Err())
+				return
 			}
 		}
-		close(obsChan)
 	}()
 	return obsChan, nil
 }
 
+// Err returns the error that stopped the stream or nil if the
+// stream ended normally. Call Err after the channel is closed.
+func (so *SeqObserver) Err() error {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	return so.err
+}
+
+func (so *SeqObserver) setErr(err error) {
+	so.mu.Lock()
+	so.err = err
+	so.mu.Unlock()
+}
+
 // Close underlying reader if reader implements the io.Closer interface.
 func (so *SeqObserver) Close() error {
 
@@ -0,0 +1,82 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Decoder creates a model from its JSON representation.
+type Decoder func(b []byte) (Modeler, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Decoder)
+)
+
+// Register makes a model decoder available using the model type name. The type
+// name is the value of the "type" field in the JSON representation of the model.
+// Model packages register their decoders in an init function.
+// Register panics if called twice with the same type name or if the decoder is nil.
+func Register(typeName string, d Decoder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if d == nil {
+		panic("model: register decoder is nil")
+	}
+	if _, dup := registry[typeName]; dup {
+		panic("model: register called twice for type " + typeName)
+	}
+	registry[typeName] = d
+}
+
+// Decode creates a model from its JSON representation. The "type" field is
+// used to select the decoder. The package that implements the model must be
+// imported to register the decoder.
+func Decode(b []byte) (Modeler, error) {
+
+	var v struct {
+		Type string `json:"type"`
+	}
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return nil, err
+	}
+	registryMu.RLock()
+	d, ok := registry[v.Type]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown model type [%s] - the model package may not be imported", v.Type)
+	}
+	return d(b)
+}
+
+// ReadModel reads a JSON-encoded model from r and returns a model of the
+// concrete type registered for the "type" field.
+func ReadModel(r io.Reader) (Modeler, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(b)
+}
+
+// ReadModelFile reads a JSON-encoded model from a file.
+func ReadModelFile(fn string) (Modeler, error) {
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadModel(f)
+}
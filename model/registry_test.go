@@ -0,0 +1,71 @@
+// Copyright (c) 2015 AKUALAB INC., All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+type constModel struct {
+	Type  string  `json:"type"`
+	Value float64 `json:"value"`
+}
+
+func (m *constModel) Name() string                                 { return "const" }
+func (m *constModel) Dim() int                                     { return 1 }
+func (m *constModel) Update(x Observer, w func(Obs) float64) error { return nil }
+func (m *constModel) UpdateOne(o Obs, w float64)                   {}
+func (m *constModel) Estimate() error                              { return nil }
+func (m *constModel) Clear()                                       {}
+func (m *constModel) Predict(x Observer) ([]Labeler, error)        { return nil, nil }
+func (m *constModel) LogProb(x Obs) float64                        { return m.Value }
+func (m *constModel) Sample(r *rand.Rand) Obs                      { return nil }
+func (m *constModel) SampleChan(r *rand.Rand, size int) <-chan Obs { return nil }
+
+func init() {
+	Register("model.constModel", func(b []byte) (Modeler, error) {
+		m := &constModel{}
+		err := json.Unmarshal(b, m)
+		return m, err
+	})
+}
+
+func TestReadModel(t *testing.T) {
+
+	m, err := ReadModel(strings.NewReader(`{"type":"model.constModel","value":-2.5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, ok := m.(*constModel)
+	if !ok {
+		t.Fatalf("wrong model type: %T", m)
+	}
+	if cm.LogProb(nil) != -2.5 {
+		t.Fatalf("wrong value - expected: -2.5, got: %f", cm.LogProb(nil))
+	}
+
+	_, err = ReadModel(strings.NewReader(`{"type":"foo.Model"}`))
+	if err == nil {
+		t.Fatalf("expected error for unknown type")
+	}
+	_, err = ReadModel(strings.NewReader(`{"type":`))
+	if err == nil {
+		t.Fatalf("expected error for invalid json")
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic when registering a type twice")
+		}
+	}()
+	Register("model.constModel", func(b []byte) (Modeler, error) { return nil, nil })
+}